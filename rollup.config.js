@@ -5,21 +5,32 @@ import nodeResolve from "@rollup/plugin-node-resolve";
 
 /** @type import('rollup').RollupOptions */
 const common = {
-  external: ["@grpc/grpc-js", "axios"],
+  external: ["@grpc/grpc-js", "@opentelemetry/api", "axios", "undici"],
   plugins: [json(), commonjs(), nodeResolve(), typescript({ tsconfig: "./tsconfig.build.json" })],
 };
 
-export default {
-  input: "src/index.ts",
-  output: [
-    {
+export default [
+  {
+    input: "src/index.ts",
+    output: [
+      {
+        format: "esm",
+        file: "lib/index.mjs",
+      },
+      {
+        format: "cjs",
+        file: "lib/index.js",
+      },
+    ],
+    ...common,
+  },
+  {
+    // bin/grpc-proxy-interceptor.mjs 的实现
+    input: "src/cli.ts",
+    output: {
       format: "esm",
-      file: "lib/index.mjs",
+      file: "lib/cli.mjs",
     },
-    {
-      format: "cjs",
-      file: "lib/index.js",
-    },
-  ],
-  ...common,
-};
+    ...common,
+  },
+];
@@ -0,0 +1,70 @@
+import { ProxyHandler } from "./interceptor-call";
+import { getServicePath } from "./grpc-utils";
+import { errorResult, TransportError } from "./proxy-error";
+import { CallCredentials, ChannelCredentials, Metadata } from "@grpc/grpc-js";
+
+// 调用凭证：ChannelCredentials 中只有通过 combineChannelCredentials 组合的 CallCredentials 会生效
+export type CredentialsOption = ChannelCredentials | CallCredentials;
+
+/**
+ * 把配置的凭证和单次调用的凭证组合成一个 CallCredentials，都没有时返回 null
+ * @param items {Array<CredentialsOption | null | undefined>}
+ * @return {CallCredentials | null}
+ */
+export function toCallCredentials(
+  ...items: Array<CredentialsOption | null | undefined>
+): CallCredentials | null {
+  return items.reduce<CallCredentials | null>((result, item) => {
+    if (!item) return result;
+    const callCredentials =
+      item instanceof ChannelCredentials ? item._getCallCredentials() : item;
+    return result ? result.compose(callCredentials) : callCredentials;
+  }, null);
+}
+
+/**
+ * 生成 CallCredentials 时使用的 service_url，和 grpc-js 的格式相同：https://{authority}/{service}
+ * @param target {string} - grpc-gateway 地址或者 host
+ * @param callPath {string}
+ * @return {string}
+ */
+export function getServiceUrl(target: string, callPath: string): string {
+  const authority = target.replace(/^[a-z]+:\/\//i, "").replace(/\/.*$/, "");
+  return `https://${authority}/${getServicePath(callPath).replace(/^\//, "")}`;
+}
+
+/**
+ * 每次调用前通过 credentials 生成 metadata，合并到调用的 metadata 中
+ * 生成的 authorization 会作为 Authorization 头发送给 grpc-gateway
+ * 生成失败时不会发送请求，返回 UNAVAILABLE
+ * @param handler {ProxyHandler}
+ * @param credentials {CallCredentials | null}
+ * @param serviceUrl {string}
+ * @return {ProxyHandler}
+ */
+export function withCallCredentials(
+  handler: ProxyHandler,
+  credentials: CallCredentials | null,
+  serviceUrl: string
+): ProxyHandler {
+  if (!credentials) return handler;
+  return async (message, metadata, signal) => {
+    let generated: Metadata;
+    try {
+      generated = await credentials.generateMetadata({
+        service_url: serviceUrl,
+      });
+    } catch (err) {
+      return errorResult(
+        new TransportError(
+          "Getting metadata from plugin failed with error: " +
+            (err as Error).message,
+          err
+        )
+      );
+    }
+    const merged = metadata.clone();
+    merged.merge(generated);
+    return handler(message, merged, signal);
+  };
+}
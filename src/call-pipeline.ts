@@ -0,0 +1,218 @@
+import { EventEmitter } from "node:events";
+import type { Tracer } from "@opentelemetry/api";
+import { ChannelOptions } from "@grpc/grpc-js";
+import { CallHandler } from "./proxy-channel";
+import { HttpTransport } from "./http-transport";
+import { TlsOptions } from "./tls-options";
+import { ProxyConfig } from "./http-proxy";
+import { createDefaultClient, HttpClient } from "./http-client";
+import { CircuitBreaker, CircuitBreakerOptions } from "./circuit-breaker";
+import { withHedging } from "./hedging-policy";
+import { LoadBalancer, LoadBalancingOptions } from "./load-balancer";
+import { isGatewayResolver } from "./gateway-resolver";
+import { ClientCredentialsProvider, OAuth2Options } from "./oauth2";
+import { RequestSigner, signingMiddleware } from "./request-signer";
+import { HeaderMatchingOptions } from "./header-matcher";
+import { ProxyTransport, Tracing } from "./tracing";
+import { Metrics, ProxyMetrics } from "./metrics";
+import { consoleLogger, Logging, ProxyLogger } from "./logger";
+import { ProxyHandler } from "./interceptor-call";
+import { Idempotent, isIdempotent } from "./idempotency";
+import {
+  getMethodPolicy,
+  parseServiceConfig,
+  ServiceConfig,
+  withRetry,
+} from "./retry-policy";
+
+// 拦截器和 Channel 共用的调用配置
+export interface PipelineOptions {
+  // 幂等的方法，只有幂等的方法才会被重发
+  idempotent?: Idempotent;
+  // 和 client 相同的 channel options，会读取 grpc.service_config 中的 retryPolicy 和 hedgingPolicy
+  channelOptions?: ChannelOptions;
+  // 按 grpc-gateway 地址熔断，不配置时不熔断
+  circuitBreaker?: CircuitBreakerOptions;
+  // grpc-gateway 地址，是列表或者 GatewayResolver 时按 loadBalancing 分配
+  getaway?: unknown;
+  loadBalancing?: LoadBalancingOptions;
+  // 发送请求使用的 http 客户端，默认 axios.create()
+  httpClient?: HttpClient;
+  // 默认 http 客户端和健康检查使用的 TLS 配置
+  tls?: TlsOptions;
+  // 默认 http 客户端和健康检查使用的代理，不配置时读取 HTTPS_PROXY/HTTP_PROXY/NO_PROXY
+  proxy?: ProxyConfig;
+  // grpc-gateway 前的 OAuth2 鉴权，token 使用默认的 http 客户端获取
+  oauth2?: OAuth2Options | ClientCredentialsProvider;
+  // 发送前对最终的请求签名，返回需要添加的头
+  signRequest?: RequestSigner;
+  // metadata 和 http 头之间的转换规则
+  headers?: HeaderMatchingOptions;
+  // @opentelemetry/api 的 Tracer，配置后每个调用创建一个 client span 并传播链路头
+  tracer?: Tracer;
+  // 调用、http 请求和 openapi 加载的统计
+  metrics?: ProxyMetrics;
+  // 日志的接收方，默认使用 consoleLogger("warn")
+  logger?: ProxyLogger;
+  // 日志中隐藏值的头，默认 DEFAULT_REDACTED_HEADERS
+  redactedHeaders?: string[];
+}
+
+// http 发送层和调用共用的观测工具，链路头需要找到调用的 span
+export interface Observers {
+  tracing: Tracing | null;
+  metrics: Metrics | null;
+  logging: Logging | null;
+}
+
+/**
+ * 根据配置创建链路追踪、统计和日志，没有配置链路追踪和统计时为 null
+ * @param opts {PipelineOptions}
+ * @param transport {ProxyTransport}
+ * @return {Observers}
+ */
+export function createObservers(
+  opts: PipelineOptions,
+  transport: ProxyTransport
+): Observers {
+  return {
+    tracing: opts.tracer ? new Tracing(opts.tracer, transport) : null,
+    metrics: opts.metrics ? new Metrics(opts.metrics, transport) : null,
+    logging: new Logging(
+      opts.logger || consoleLogger(),
+      transport,
+      opts.redactedHeaders,
+      opts.headers?.request?.matcher
+    ),
+  };
+}
+
+const NO_OBSERVERS: Observers = {
+  tracing: null,
+  metrics: null,
+  logging: null,
+};
+
+/**
+ * 根据配置创建 http 发送层
+ * 调用过程中产生的事件：
+ *  - endpoint：grpc-gateway 被摘除或者恢复时触发，参数为 EndpointHealthEvent
+ *  - circuit：熔断状态变化时触发，参数为 CircuitStateEvent
+ * close 时会停止健康检查和 resolver，并关闭 http 客户端的连接
+ * @param opts {PipelineOptions}
+ * @param emitter {EventEmitter}
+ * @param [observers] {Observers} - 和 CallPipeline 使用同一个 Observers
+ * @return {HttpTransport}
+ */
+export function createTransport(
+  opts: PipelineOptions,
+  emitter: EventEmitter,
+  observers: Observers = NO_OBSERVERS
+): HttpTransport {
+  // 健康检查总是使用默认的客户端
+  const defaultClient = createDefaultClient(opts);
+  const transport = new HttpTransport(
+    opts.httpClient || defaultClient,
+    opts.headers?.response
+  );
+  // 先选择 grpc-gateway，熔断才能按最终的地址统计
+  if (Array.isArray(opts.getaway) || isGatewayResolver(opts.getaway)) {
+    const balancer = new LoadBalancer(
+      opts.getaway,
+      opts.loadBalancing || {},
+      (event) => emitter.emit("endpoint", event),
+      defaultClient
+    );
+    transport.use(balancer.middleware()).onClose(() => balancer.close());
+  }
+  if (opts.circuitBreaker) {
+    const breaker = new CircuitBreaker(opts.circuitBreaker, (event) =>
+      emitter.emit("circuit", event)
+    );
+    transport.use(breaker.middleware());
+  }
+  // 放在熔断之后，401 重发的请求只会被统计一次
+  if (opts.oauth2) {
+    const provider =
+      opts.oauth2 instanceof ClientCredentialsProvider
+        ? opts.oauth2
+        : new ClientCredentialsProvider(opts.oauth2, defaultClient);
+    transport.use(provider.middleware());
+  }
+  const { tracing, metrics, logging } = observers;
+  // 链路头也需要被签名
+  if (tracing) {
+    transport.use(tracing.middleware());
+  }
+  const recordRequest = metrics?.middleware();
+  if (recordRequest) {
+    transport.use(recordRequest);
+  }
+  // 签名必须在最后，之前的 middleware 还会修改地址和头
+  if (opts.signRequest) {
+    transport.use(signingMiddleware(opts.signRequest));
+  }
+  // 在签名之后，输出的是最终发送的请求
+  const dumpRequest = logging?.middleware();
+  if (dumpRequest) {
+    transport.use(dumpRequest);
+  }
+  return transport;
+}
+
+/**
+ * 根据配置给 http 调用加上重试等处理，拦截器和 Channel 都通过它发送请求
+ * 调用过程中产生的事件：
+ *  - hedge：发送过对冲请求的调用结束时触发，参数为 HedgeEvent，同时记录到 metrics
+ */
+export class CallPipeline {
+  private readonly serviceConfig: ServiceConfig | null;
+
+  constructor(
+    private call: CallHandler,
+    private opts: PipelineOptions,
+    private emitter: EventEmitter,
+    // span 和调用的统计包含重试和对冲的所有请求
+    private observers: Observers = NO_OBSERVERS
+  ) {
+    this.serviceConfig = parseServiceConfig(opts.channelOptions);
+  }
+
+  /**
+   * 获取 callPath 对应的调用方法
+   * @param callPath {string}
+   * @return {ProxyHandler}
+   */
+  public handler(callPath: string): ProxyHandler {
+    const { tracing, metrics, logging } = this.observers;
+    let handler = this.policyHandler(callPath);
+    if (tracing) handler = tracing.wrap(handler, callPath);
+    if (metrics) handler = metrics.wrap(handler, callPath);
+    if (logging) handler = logging.wrap(handler, callPath);
+    return handler;
+  }
+
+  private policyHandler(callPath: string): ProxyHandler {
+    const idempotent = isIdempotent(this.opts.idempotent, callPath);
+    const handler: ProxyHandler = (message, metadata, signal) =>
+      this.call(callPath, message, metadata, signal);
+    const { serviceConfig } = this;
+    // 同一个方法只能配置 retryPolicy 和 hedgingPolicy 中的一个
+    const hedgingPolicy = getMethodPolicy(
+      serviceConfig,
+      callPath,
+      "hedgingPolicy"
+    );
+    if (hedgingPolicy) {
+      return withHedging(handler, hedgingPolicy, idempotent, callPath, (e) => {
+        this.observers.metrics?.recordHedge(e);
+        this.emitter.emit("hedge", e);
+      });
+    }
+    return withRetry(
+      handler,
+      getMethodPolicy(serviceConfig, callPath, "retryPolicy"),
+      idempotent
+    );
+  }
+}
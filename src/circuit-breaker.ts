@@ -0,0 +1,183 @@
+import { CallResult } from "./grpc-utils";
+import { errorResult, TransportError } from "./proxy-error";
+import { ProxyRequest, TransportMiddleware } from "./http-transport";
+
+export type CircuitState = "closed" | "open" | "half-open";
+
+export interface CircuitBreakerOptions {
+  // 是否按 grpc-gateway + 方法分别熔断，默认只按 grpc-gateway 熔断
+  perMethod?: boolean;
+  // 统计窗口内失败请求的比例达到该值时熔断，默认 0.5
+  failureRatio?: number;
+  // 统计窗口的时长，单位毫秒，默认 10000
+  window?: number;
+  // 统计窗口内至少有这么多请求才会熔断，默认 10
+  minimumRequests?: number;
+  // 熔断后多久进入半开状态，单位毫秒，默认 30000
+  openDuration?: number;
+  // 半开状态下允许通过的探测请求数，默认 1
+  halfOpenRequests?: number;
+  // 判断一次调用是否失败，默认没有收到响应或者 http 状态码为 502/503/504 时认为失败
+  isFailure?: (result: CallResult<any>) => boolean;
+}
+
+// 熔断状态变化时触发的 circuit 事件的参数
+export interface CircuitStateEvent {
+  key: string;
+  from: CircuitState;
+  to: CircuitState;
+}
+
+interface Circuit {
+  state: CircuitState;
+  // 统计窗口内的调用结果
+  outcomes: Array<{ time: number; failed: boolean }>;
+  openedAt: number;
+  // 半开状态下正在进行的探测请求数
+  probing: number;
+}
+
+const defaultOptions: Required<Omit<CircuitBreakerOptions, "isFailure">> = {
+  perMethod: false,
+  failureRatio: 0.5,
+  window: 10000,
+  minimumRequests: 10,
+  openDuration: 30000,
+  halfOpenRequests: 1,
+};
+
+function defaultIsFailure(result: CallResult<any>): boolean {
+  if (!result.http) return true;
+  return [502, 503, 504].includes(result.http.status);
+}
+
+/**
+ * 按 grpc-gateway 地址（可选加上方法）熔断
+ *  1. closed：统计窗口内失败比例达到 failureRatio 时进入 open
+ *  2. open：直接返回 UNAVAILABLE，openDuration 之后进入 half-open
+ *  3. half-open：只允许 halfOpenRequests 个探测请求通过，成功则 closed，失败则重新 open
+ */
+export class CircuitBreaker {
+  private readonly opts: Required<CircuitBreakerOptions>;
+  private readonly circuits = new Map<string, Circuit>();
+
+  constructor(
+    opts: CircuitBreakerOptions,
+    private onStateChange: (event: CircuitStateEvent) => void
+  ) {
+    this.opts = {
+      ...defaultOptions,
+      isFailure: defaultIsFailure,
+      ...opts,
+    };
+  }
+
+  private getKey(request: ProxyRequest): string {
+    return this.opts.perMethod
+      ? `${request.baseUrl}${request.callPath}`
+      : request.baseUrl;
+  }
+
+  private getCircuit(key: string): Circuit {
+    let circuit = this.circuits.get(key);
+    if (!circuit) {
+      circuit = { state: "closed", outcomes: [], openedAt: 0, probing: 0 };
+      this.circuits.set(key, circuit);
+    }
+    return circuit;
+  }
+
+  private transition(key: string, circuit: Circuit, to: CircuitState) {
+    const from = circuit.state;
+    if (from === to) return;
+    circuit.state = to;
+    circuit.outcomes = [];
+    circuit.probing = 0;
+    if (to === "open") circuit.openedAt = Date.now();
+    this.onStateChange({ key, from, to });
+  }
+
+  /**
+   * 获取熔断状态，open 超过 openDuration 时会进入 half-open
+   * @param key {string}
+   * @return {CircuitState}
+   */
+  public getState(key: string): CircuitState {
+    const circuit = this.getCircuit(key);
+    if (
+      circuit.state === "open" &&
+      Date.now() - circuit.openedAt >= this.opts.openDuration
+    ) {
+      this.transition(key, circuit, "half-open");
+    }
+    return circuit.state;
+  }
+
+  private record(
+    key: string,
+    circuit: Circuit,
+    failed: boolean,
+    probe: boolean
+  ) {
+    const now = Date.now();
+    if (circuit.state === "half-open") {
+      // 只有探测请求的结果才会改变半开状态
+      if (probe) this.transition(key, circuit, failed ? "open" : "closed");
+      return;
+    }
+    if (circuit.state !== "closed") return;
+    circuit.outcomes.push({ time: now, failed });
+    circuit.outcomes = circuit.outcomes.filter(
+      (item) => now - item.time < this.opts.window
+    );
+    const failures = circuit.outcomes.filter((item) => item.failed).length;
+    if (
+      circuit.outcomes.length >= this.opts.minimumRequests &&
+      failures / circuit.outcomes.length >= this.opts.failureRatio
+    ) {
+      this.transition(key, circuit, "open");
+    }
+  }
+
+  private reject(key: string, request: ProxyRequest): CallResult<any> {
+    const circuit = this.getCircuit(key);
+    const retryAt = new Date(circuit.openedAt + this.opts.openDuration);
+    // 半开状态下 retryAt 已经过去，要等待探测请求的结果
+    const reason =
+      circuit.state === "half-open"
+        ? "waiting for the probe request in flight"
+        : `retry after ${retryAt.toISOString()}`;
+    return errorResult(
+      new TransportError(
+        `circuit breaker is ${circuit.state} for ${key}, ` +
+          `${request.callPath} was not sent, ${reason}`
+      )
+    );
+  }
+
+  public middleware(): TransportMiddleware {
+    return async (request, next) => {
+      const key = this.getKey(request);
+      const state = this.getState(key);
+      const circuit = this.getCircuit(key);
+      if (state === "open") {
+        return this.reject(key, request);
+      }
+      const probe = state === "half-open";
+      if (probe) {
+        if (circuit.probing >= this.opts.halfOpenRequests) {
+          return this.reject(key, request);
+        }
+        circuit.probing += 1;
+      }
+      const result = await next(request);
+      // 被主动取消的请求（如对冲请求中失败的一方）不计入统计
+      if (!request.config.signal?.aborted) {
+        this.record(key, circuit, this.opts.isFailure(result), probe);
+      } else if (probe) {
+        circuit.probing -= 1;
+      }
+      return result;
+    };
+  }
+}
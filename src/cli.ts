@@ -0,0 +1,153 @@
+import {
+  formatDriftReport,
+  verifyGateway,
+  VerifyOptions,
+} from "./contract-drift";
+
+export const USAGE = `Usage: grpc-proxy-interceptor verify --gateway <url> [options]
+
+Compare the local openapi files with the routes of a running grpc-gateway.
+
+Options:
+  -g, --gateway <url>        grpc-gateway address, e.g. http://127.0.0.1:4501
+  -d, --openapi-dir <dir>    local openapi directory (default: openapi)
+  -s, --swagger <path>       swagger published by the gateway, repeatable;
+                             routes are probed one by one when omitted
+  --probe-method <method>    OPTIONS or HEAD (default: OPTIONS)
+  -H, --header <name: value> header sent with every request, repeatable
+  --format <format>          text or json (default: text)
+  -h, --help                 show this message
+
+Exit codes: 0 no drift, 1 drift found, 2 usage or network error.`;
+
+export interface CliOptions extends VerifyOptions {
+  format: "text" | "json";
+}
+
+// 输出的目标，测试时可以替换
+export interface CliOutput {
+  stdout(text: string): void;
+  stderr(text: string): void;
+}
+
+const defaultOutput: CliOutput = {
+  stdout: (text) => process.stdout.write(`${text}\n`),
+  stderr: (text) => process.stderr.write(`${text}\n`),
+};
+
+/**
+ * 解析 verify 命令的参数，参数无效时抛出错误
+ * @param args {string[]} - 不包括命令名称
+ * @return {CliOptions}
+ */
+export function parseVerifyArgs(args: string[]): CliOptions {
+  const opts: CliOptions = {
+    openapiDir: "openapi",
+    gateway: "",
+    format: "text",
+  };
+  const swagger: string[] = [];
+  const headers: Record<string, string> = {};
+  for (let i = 0; i < args.length; i++) {
+    const arg = args[i];
+    // 支持 --name=value 的写法
+    const eq = arg.startsWith("--") ? arg.indexOf("=") : -1;
+    const name = eq > 0 ? arg.slice(0, eq) : arg;
+    const inline = eq > 0 ? arg.slice(eq + 1) : undefined;
+    const value = () => {
+      const next = inline ?? args[++i];
+      if (next === undefined) throw new Error(`${name} requires a value`);
+      return next;
+    };
+    switch (name) {
+      case "-g":
+      case "--gateway":
+        opts.gateway = value();
+        break;
+      case "-d":
+      case "--openapi-dir":
+        opts.openapiDir = value();
+        break;
+      case "-s":
+      case "--swagger":
+        swagger.push(value());
+        break;
+      case "--probe-method": {
+        const method = value().toUpperCase();
+        if (method !== "OPTIONS" && method !== "HEAD") {
+          throw new Error(`unsupported probe method ${method}`);
+        }
+        opts.probeMethod = method;
+        break;
+      }
+      case "-H":
+      case "--header": {
+        const header = value();
+        const index = header.indexOf(":");
+        if (index <= 0) throw new Error(`invalid header ${header}`);
+        const headerName = header.slice(0, index).trim();
+        headers[headerName] = header.slice(index + 1).trim();
+        break;
+      }
+      case "--format": {
+        const format = value();
+        if (format !== "text" && format !== "json") {
+          throw new Error(`unsupported format ${format}`);
+        }
+        opts.format = format;
+        break;
+      }
+      default:
+        throw new Error(`unknown option ${arg}`);
+    }
+  }
+  if (!opts.gateway) throw new Error("--gateway is required");
+  if (swagger.length > 0) opts.swagger = swagger;
+  if (Object.keys(headers).length > 0) opts.headers = headers;
+  return opts;
+}
+
+/**
+ * 命令行入口，返回进程的退出码
+ * @param argv {string[]} - process.argv.slice(2)
+ * @param [output] {CliOutput}
+ * @return {Promise<number>}
+ */
+export async function runCli(
+  argv: string[],
+  output: CliOutput = defaultOutput
+): Promise<number> {
+  const [command, ...args] = argv;
+  if (!command || command === "-h" || command === "--help") {
+    output.stdout(USAGE);
+    return command ? 0 : 2;
+  }
+  if (command !== "verify") {
+    output.stderr(`unknown command ${command}\n\n${USAGE}`);
+    return 2;
+  }
+  if (args.includes("-h") || args.includes("--help")) {
+    output.stdout(USAGE);
+    return 0;
+  }
+  let opts: CliOptions;
+  try {
+    opts = parseVerifyArgs(args);
+  } catch (err) {
+    output.stderr(`${(err as Error).message}\n\n${USAGE}`);
+    return 2;
+  }
+  try {
+    const { format, ...verify } = opts;
+    const report = await verifyGateway(verify);
+    output.stdout(
+      format === "json"
+        ? JSON.stringify(report, null, 2)
+        : formatDriftReport(report)
+    );
+    return report.ok ? 0 : 1;
+  } catch (err) {
+    output.stderr(`verify failed: ${(err as Error).message}`);
+    return 2;
+  }
+}
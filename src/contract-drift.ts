@@ -0,0 +1,350 @@
+import axios from "axios";
+import { OpenAPIV2 } from "openapi-types";
+import { resolveRef } from "./openapi-schema";
+import { OpenapiV2Parser } from "./openapi-v2-parser";
+import { HttpClient, toHttpClientAdapter } from "./http-client";
+
+// swagger：比较 grpc-gateway 发布的 openapi 文件；probe：逐个探测本地的路由
+export type DriftSource = "swagger" | "probe";
+
+export interface ParameterSignature {
+  name: string;
+  in: string;
+  required: boolean;
+  type?: string;
+  format?: string;
+}
+
+// 用于比较的路由信息
+export interface RouteSignature {
+  // 和 getOperations 相同的 callPath，没有 tag 时是 operationId
+  id: string;
+  operationId: string;
+  method: string;
+  path: string;
+  parameters: ParameterSignature[];
+}
+
+// 路由的一处变化，parameter 的 name 是 {in}.{name}，如 query.status
+// 参数缺少时 actual 为 null，多出时 expected 为 null
+export interface RouteChange {
+  field: "method" | "path" | "parameter";
+  name?: string;
+  expected: unknown;
+  actual: unknown;
+}
+
+export interface RouteDrift {
+  id: string;
+  operationId: string;
+  // missing、changed 是本地的定义，extra 是 grpc-gateway 的定义
+  method: string;
+  path: string;
+  // 只有 changed 有
+  changes?: RouteChange[];
+  // probe 模式下探测收到的 http 状态码
+  status?: number;
+}
+
+export interface DriftReport {
+  source: DriftSource;
+  gateway: string;
+  // 没有任何差异
+  ok: boolean;
+  // 本地定义的路由数量
+  checked: number;
+  // 本地有、grpc-gateway 没有的路由
+  missing: RouteDrift[];
+  // 两边都有但 method、path 或者参数不同的路由
+  changed: RouteDrift[];
+  // grpc-gateway 有、本地没有的路由，probe 模式下总是为空
+  extra: RouteDrift[];
+}
+
+export interface VerifyOptions {
+  // 本地的 openapi 目录
+  openapiDir: string;
+  // grpc-gateway 的地址，如 http://127.0.0.1:4501
+  gateway: string;
+  // grpc-gateway 发布的 openapi 文件的路径或者完整地址，如 /openapiv2/greeter.swagger.json
+  // 多个文件会合并比较，不配置时使用 probeMethod 逐个探测本地的路由
+  swagger?: string | string[];
+  // 默认 OPTIONS，grpc-gateway 对没有注册该 method 的路径返回 405，不存在的路径返回 404
+  probeMethod?: "OPTIONS" | "HEAD";
+  // 每个请求都会带上的头，如 grpc-gateway 前的鉴权
+  headers?: Record<string, string>;
+  // 默认 axios.create()
+  httpClient?: HttpClient;
+}
+
+function routeId(tag: string | undefined, operationId: string): string {
+  if (!tag) return operationId;
+  const service = tag.slice(tag.lastIndexOf(".") + 1);
+  if (!operationId.startsWith(`${service}_`)) return operationId;
+  return `/${tag}/${operationId.slice(service.length + 1)}`;
+}
+
+function toParameter(
+  document: OpenAPIV2.Document,
+  param: OpenAPIV2.ReferenceObject | OpenAPIV2.Parameter
+): ParameterSignature {
+  const resolved = (
+    "$ref" in param ? resolveRef(document, param.$ref) : param
+  ) as OpenAPIV2.Parameter;
+  return {
+    name: resolved.name,
+    in: resolved.in,
+    required: Boolean(resolved.required),
+    type: resolved.type,
+    format: resolved.format,
+  };
+}
+
+/**
+ * 收集 openapi 文件中所有有 operationId 的路由
+ * @param documents {OpenAPIV2.Document[]}
+ * @return {RouteSignature[]}
+ */
+export function collectRoutes(
+  documents: OpenAPIV2.Document[]
+): RouteSignature[] {
+  const routes: RouteSignature[] = [];
+  for (const document of documents) {
+    for (const [path, pathItem] of Object.entries(document.paths || {})) {
+      // path 级别的参数对所有 method 生效
+      const shared = pathItem?.parameters || [];
+      for (const [method, value] of Object.entries(pathItem || {})) {
+        if (method === "parameters") continue;
+        const definition = value as OpenAPIV2.OperationObject;
+        if (!definition?.operationId) continue;
+        routes.push({
+          path,
+          id: routeId(definition.tags?.[0], definition.operationId),
+          operationId: definition.operationId,
+          method: method.toLowerCase(),
+          parameters: [...shared, ...(definition.parameters || [])].map(
+            (param) => toParameter(document, param)
+          ),
+        });
+      }
+    }
+  }
+  return routes;
+}
+
+function parameterKey(param: ParameterSignature): string {
+  return `${param.in}.${param.name}`;
+}
+
+function compareParameters(
+  expected: ParameterSignature[],
+  actual: ParameterSignature[]
+): RouteChange[] {
+  const changes: RouteChange[] = [];
+  const remote = new Map(actual.map((param) => [parameterKey(param), param]));
+  for (const param of expected) {
+    const name = parameterKey(param);
+    const other = remote.get(name);
+    remote.delete(name);
+    if (!other) {
+      changes.push({
+        field: "parameter",
+        name,
+        expected: param,
+        actual: null,
+      });
+    } else if (
+      param.required !== other.required ||
+      param.type !== other.type ||
+      param.format !== other.format
+    ) {
+      changes.push({
+        field: "parameter",
+        name,
+        expected: param,
+        actual: other,
+      });
+    }
+  }
+  for (const [name, param] of remote) {
+    changes.push({ field: "parameter", name, expected: null, actual: param });
+  }
+  return changes;
+}
+
+function toDrift(route: RouteSignature): RouteDrift {
+  const { id, operationId, method, path } = route;
+  return { id, operationId, method, path };
+}
+
+/**
+ * 按 id 比较两组路由，id 相同时比较 method、path 和参数（in、name、required、type、format）
+ * @param expected {RouteSignature[]} - 本地的路由
+ * @param actual {RouteSignature[]} - grpc-gateway 的路由
+ * @return {Pick<DriftReport, "missing" | "changed" | "extra">}
+ */
+export function diffRoutes(
+  expected: RouteSignature[],
+  actual: RouteSignature[]
+): Pick<DriftReport, "missing" | "changed" | "extra"> {
+  const remote = new Map(actual.map((route) => [route.id, route]));
+  const missing: RouteDrift[] = [];
+  const changed: RouteDrift[] = [];
+  for (const route of expected) {
+    const other = remote.get(route.id);
+    remote.delete(route.id);
+    if (!other) {
+      missing.push(toDrift(route));
+      continue;
+    }
+    const changes: RouteChange[] = [];
+    if (route.method !== other.method) {
+      changes.push({
+        field: "method",
+        expected: route.method,
+        actual: other.method,
+      });
+    }
+    if (route.path !== other.path) {
+      changes.push({
+        field: "path",
+        expected: route.path,
+        actual: other.path,
+      });
+    }
+    changes.push(...compareParameters(route.parameters, other.parameters));
+    if (changes.length > 0) changed.push({ ...toDrift(route), changes });
+  }
+  return { missing, changed, extra: [...remote.values()].map(toDrift) };
+}
+
+// fetch 等客户端返回的 JSON 不是 application/json 时是字符串
+function parseDocument(data: unknown): OpenAPIV2.Document | null {
+  let document = data;
+  if (typeof data === "string") {
+    try {
+      document = JSON.parse(data);
+    } catch (err) {
+      return null;
+    }
+  }
+  const paths = (document as OpenAPIV2.Document | null)?.paths;
+  return paths && typeof paths === "object"
+    ? (document as OpenAPIV2.Document)
+    : null;
+}
+
+// path 参数替换成示例值，{name=shelves/*} 中的 * 也会被替换
+function samplePath(path: string): string {
+  return path.replace(/\{[^}=]+(?:=([^}]*))?\}/g, (_, pattern) =>
+    pattern ? pattern.replace(/\*\*?/g, "0") : "0"
+  );
+}
+
+/**
+ * 比较本地的 openapi 目录和 grpc-gateway 实际的路由
+ *  1. 配置了 swagger 时下载 grpc-gateway 发布的 openapi 文件，报告缺少、变化和多出的路由
+ *  2. 否则向每个本地的路由发送 probeMethod 请求，404 的路由报告为缺少，无法发现变化和多出的路由
+ * 本地文件无法加载、openapi 文件无法下载或者没有收到响应时抛出错误
+ * @param opts {VerifyOptions}
+ * @return {Promise<DriftReport>}
+ */
+export async function verifyGateway(opts: VerifyOptions): Promise<DriftReport> {
+  const parser = new OpenapiV2Parser(opts.openapiDir);
+  await parser.init(false);
+  const expected = collectRoutes(
+    parser.getDocuments().map(({ document }) => document)
+  );
+  const client = toHttpClientAdapter(opts.httpClient || axios.create());
+  const send = (callPath: string, method: string, url: string) =>
+    client.send({
+      callPath,
+      baseUrl: opts.gateway,
+      config: { method, url, headers: { ...opts.headers } },
+    });
+  try {
+    if (opts.swagger) {
+      const urls = Array.isArray(opts.swagger) ? opts.swagger : [opts.swagger];
+      const documents: OpenAPIV2.Document[] = [];
+      for (const url of urls) {
+        const response = await send("", "GET", url);
+        if (response.status !== 200) {
+          throw new Error(
+            `failed to fetch ${url}: received http ${response.status}`
+          );
+        }
+        const data = parseDocument(response.data);
+        if (!data) throw new Error(`${url} is not an openapi document`);
+        documents.push(data);
+      }
+      const diff = diffRoutes(expected, collectRoutes(documents));
+      return report("swagger", opts.gateway, expected.length, diff);
+    }
+    const missing: RouteDrift[] = [];
+    for (const route of expected) {
+      const method = opts.probeMethod || "OPTIONS";
+      const response = await send(route.id, method, samplePath(route.path));
+      if (response.status === 404) {
+        missing.push({ ...toDrift(route), status: response.status });
+      }
+    }
+    return report("probe", opts.gateway, expected.length, {
+      missing,
+      changed: [],
+      extra: [],
+    });
+  } finally {
+    await client.close?.();
+  }
+}
+
+function report(
+  source: DriftSource,
+  gateway: string,
+  checked: number,
+  diff: Pick<DriftReport, "missing" | "changed" | "extra">
+): DriftReport {
+  const ok =
+    diff.missing.length + diff.changed.length + diff.extra.length === 0;
+  return { source, gateway, ok, checked, ...diff };
+}
+
+function describeChange(change: RouteChange): string {
+  if (change.field !== "parameter") {
+    return `${change.field} ${change.expected} -> ${change.actual}`;
+  }
+  if (change.actual === null) return `parameter ${change.name} is missing`;
+  if (change.expected === null) return `parameter ${change.name} is extra`;
+  const { expected, actual } = change as {
+    expected: ParameterSignature;
+    actual: ParameterSignature;
+  };
+  const fields = (["required", "type", "format"] as const)
+    .filter((key) => expected[key] !== actual[key])
+    .map((key) => `${key} ${expected[key]} -> ${actual[key]}`);
+  return `parameter ${change.name} ${fields.join(", ")}`;
+}
+
+/**
+ * 文本格式的报告，用于命令行输出
+ * @param report {DriftReport}
+ * @return {string}
+ */
+export function formatDriftReport(report: DriftReport): string {
+  const lines = [
+    `checked ${report.checked} routes against ${report.gateway} ` +
+      `(${report.source}): ${report.missing.length} missing, ` +
+      `${report.changed.length} changed, ${report.extra.length} extra`,
+  ];
+  const route = (drift: RouteDrift) =>
+    `${drift.id} ${drift.method.toUpperCase()} ${drift.path}`;
+  for (const drift of report.missing) lines.push(`  missing ${route(drift)}`);
+  for (const drift of report.changed) {
+    lines.push(`  changed ${route(drift)}`);
+    for (const change of drift.changes || []) {
+      lines.push(`    ${describeChange(change)}`);
+    }
+  }
+  for (const drift of report.extra) lines.push(`  extra   ${route(drift)}`);
+  return lines.join("\n");
+}
@@ -0,0 +1,240 @@
+import { promises as dns } from "node:dns";
+import { readFile } from "node:fs/promises";
+import { FSWatcher, watch } from "node:fs";
+import { basename, dirname } from "node:path";
+import { isValidUrl } from "./helper";
+import { CallResult } from "./grpc-utils";
+import { errorResult, TransportError } from "./proxy-error";
+import { GatewayList } from "./load-balancer";
+
+// 异步获取 grpc-gateway 地址列表，结果交给 LoadBalancer 分配
+export interface GatewayResolver {
+  resolve(): Promise<GatewayList>;
+  // 停止监听文件等资源
+  close?(): void;
+}
+
+export interface ResolverOptions {
+  // 缓存时间，单位毫秒，默认 30000
+  ttl?: number;
+}
+
+export interface DnsSrvResolverOptions extends ResolverOptions {
+  // 拼接地址时使用的协议，默认 http
+  scheme?: "http" | "https";
+}
+
+const DEFAULT_TTL = 30000;
+
+/**
+ * getaway 函数抛出错误时调用的结果
+ * @param callPath {string}
+ * @param err {Error}
+ * @return {CallResult<any>}
+ */
+export function unresolvedResult(
+  callPath: string,
+  err: Error
+): CallResult<any> {
+  return errorResult(
+    new TransportError(
+      `failed to resolve grpc-gateway: ${err.message}, ` +
+        `${callPath} was not sent`,
+      err
+    )
+  );
+}
+
+/**
+ * 判断 getaway 是否是 GatewayResolver
+ * @param value {unknown}
+ * @return {boolean}
+ */
+export function isGatewayResolver(value: unknown): value is GatewayResolver {
+  return (
+    typeof value === "object" &&
+    value !== null &&
+    !Array.isArray(value) &&
+    typeof (value as GatewayResolver).resolve === "function"
+  );
+}
+
+function checkEndpoints(list: GatewayList, source: string): GatewayList {
+  const invalid = list.find(
+    (item) => !isValidUrl(typeof item === "string" ? item : item.url)
+  );
+  if (invalid !== undefined) {
+    throw new Error(
+      `invalid grpc-gateway endpoint ${JSON.stringify(invalid)} from ${source}`
+    );
+  }
+  return list;
+}
+
+/**
+ * 给 resolver 加上缓存
+ *  1. ttl 之内直接使用缓存的结果
+ *  2. 过期后在后台刷新，刷新完成之前继续使用旧的结果，同一时间只有一个刷新
+ *  3. 刷新失败时继续使用旧的结果，没有旧的结果时抛出错误
+ */
+export class CachedResolver implements GatewayResolver {
+  private value: GatewayList | null = null;
+  private expiresAt = 0;
+  private pending: Promise<GatewayList> | null = null;
+
+  constructor(
+    private load: () => Promise<GatewayList>,
+    private ttl: number = DEFAULT_TTL,
+    private onClose?: () => void
+  ) {}
+
+  private refresh(): Promise<GatewayList> {
+    if (!this.pending) {
+      this.pending = this.load()
+        .then(
+          (list) => {
+            this.value = list;
+            return list;
+          },
+          (err) => {
+            if (this.value) return this.value;
+            throw err;
+          }
+        )
+        .finally(() => {
+          this.expiresAt = Date.now() + this.ttl;
+          this.pending = null;
+        });
+    }
+    return this.pending;
+  }
+
+  public resolve(): Promise<GatewayList> {
+    if (this.value === null) return this.refresh();
+    if (Date.now() >= this.expiresAt) {
+      this.refresh().catch(() => undefined);
+    }
+    return Promise.resolve(this.value);
+  }
+
+  // 让下一次 resolve 重新加载
+  public invalidate() {
+    this.expiresAt = 0;
+  }
+
+  public close() {
+    this.onClose?.();
+  }
+}
+
+/**
+ * 通过 DNS SRV 记录获取 grpc-gateway 地址，只使用优先级最高（priority 最小）的记录，weight 作为权重
+ * @param name {string} - 如：_http._tcp.grpc-gateway.example.com
+ * @param [opts] {DnsSrvResolverOptions}
+ * @return {GatewayResolver}
+ */
+export function dnsSrvResolver(
+  name: string,
+  opts: DnsSrvResolverOptions = {}
+): GatewayResolver {
+  const scheme = opts.scheme || "http";
+  return new CachedResolver(async () => {
+    const records = await dns.resolveSrv(name);
+    if (records.length === 0) {
+      throw new Error(`no SRV record found for ${name}`);
+    }
+    const priority = Math.min(...records.map((item) => item.priority));
+    return records
+      .filter((item) => item.priority === priority)
+      .map((item) => ({
+        url: `${scheme}://${item.name}:${item.port}`,
+        weight: item.weight || 1,
+      }));
+  }, opts.ttl);
+}
+
+/**
+ * 从 JSON 文件读取 grpc-gateway 地址列表，文件内容和 getaway 的数组形式相同
+ * 文件变化时会立即重新读取
+ *  1. 监听的是文件所在的目录，编辑器或者配置工具通过 rename 原子替换文件之后仍然能收到变化
+ *  2. 监听失败（如目录还不存在）时每隔 ttl 重试，期间依赖 ttl 刷新
+ * @param path {string}
+ * @param [opts] {ResolverOptions}
+ * @return {GatewayResolver}
+ */
+export function fileResolver(
+  path: string,
+  opts: ResolverOptions = {}
+): GatewayResolver {
+  let watcher: FSWatcher | undefined;
+  let timer: NodeJS.Timeout | undefined;
+  let closed = false;
+  const ttl = opts.ttl ?? DEFAULT_TTL;
+  const resolver = new CachedResolver(
+    async () => {
+      const list = JSON.parse(await readFile(path, "utf8"));
+      if (!Array.isArray(list)) {
+        throw new Error(`${path} should contain an array of endpoints`);
+      }
+      return checkEndpoints(list, path);
+    },
+    ttl,
+    () => {
+      closed = true;
+      clearTimeout(timer);
+      watcher?.close();
+    }
+  );
+  const name = basename(path);
+  function rewatch() {
+    watcher?.close();
+    watcher = undefined;
+    if (closed) return;
+    timer = setTimeout(arm, ttl);
+    // 重试不应该阻止进程退出
+    timer.unref();
+  }
+  function arm() {
+    let current: FSWatcher;
+    try {
+      current = watch(dirname(path), (event, filename) => {
+        // 部分平台不提供 filename，这时总是重新读取
+        if (filename && filename.toString() !== name) return;
+        resolver.invalidate();
+        resolver.resolve().catch(() => undefined);
+      });
+    } catch (err) {
+      rewatch();
+      return;
+    }
+    watcher = current;
+    // 监听文件不应该阻止进程退出
+    current.unref();
+    current.on("error", () => {
+      if (watcher === current) rewatch();
+    });
+    // 重新监听之前的变化没有收到
+    resolver.invalidate();
+  }
+  arm();
+  return resolver;
+}
+
+/**
+ * 从环境变量读取 grpc-gateway 地址列表，多个地址用逗号或空白分隔
+ * @param [name] {string} - 默认 GRPC_GATEWAY_ENDPOINTS
+ * @param [opts] {ResolverOptions}
+ * @return {GatewayResolver}
+ */
+export function envResolver(
+  name = "GRPC_GATEWAY_ENDPOINTS",
+  opts: ResolverOptions = {}
+): GatewayResolver {
+  return new CachedResolver(async () => {
+    const value = process.env[name];
+    if (!value) {
+      throw new Error(`environment variable ${name} is not set`);
+    }
+    return checkEndpoints(value.split(/[\s,]+/).filter(Boolean), name);
+  }, opts.ttl);
+}
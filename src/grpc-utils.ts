@@ -1,10 +1,22 @@
 import { RequestID } from "./openapi-v2-parser";
+import { ProxyError, RouteNotFound } from "./proxy-error";
 import { Metadata, MetadataValue, StatusObject } from "@grpc/grpc-js";
 
 export interface CallResult<Response> {
   response: Response;
   metadata: Metadata;
   status: StatusObject;
+  // 收到的 http 响应，没有收到响应时为空
+  http?: {
+    status: number;
+    headers: Record<string, string>;
+    // 发送请求的 grpc-gateway 地址
+    gateway: string;
+    // 原始的响应体，失败的调用也会保留，用于排查问题
+    data?: unknown;
+  };
+  // 代理自身产生的错误，status 由它转换而来，cause 中是原始的错误
+  error?: ProxyError;
 }
 
 /**
@@ -30,7 +42,9 @@ export function parseCallPath(callPath: string): RequestID {
   const match = callPath.match(
     /\/(?<pkg>.+)\.(?<service>.+)(?=\/)\/(?<method>.+)/
   );
-  if (!match?.groups) throw new Error("rpc path parse error!");
+  if (!match?.groups) {
+    throw new RouteNotFound(`invalid rpc path: ${callPath}`);
+  }
   const { pkg, service, method } = match.groups;
   return {
     package: pkg,
@@ -38,3 +52,10 @@ export function parseCallPath(callPath: string): RequestID {
     service: service,
   };
 }
+
+// 获取 callPath 中的 service 部分
+// 输入：/example.greeter.v1.services.Greeter/SayHello
+// 输出：/example.greeter.v1.services.Greeter
+export function getServicePath(callPath: string): string {
+  return callPath.slice(0, callPath.lastIndexOf("/"));
+}
@@ -1,30 +1,113 @@
-import axios from "axios";
+import { EventEmitter } from "node:events";
+import type { Tracer } from "@opentelemetry/api";
+import type { ProxyMetrics } from "./metrics";
+import type { ProxyLogger } from "./logger";
 import { isValidUrl } from "./helper";
-import { CallResult } from "./grpc-utils";
-import { InterceptingListener } from "@grpc/grpc-js/build/src/call-stream";
-import { InterceptingCall, Interceptor, Metadata, status } from "@grpc/grpc-js";
+import { HttpTransport } from "./http-transport";
+import { toMetadataHeader } from "./openapi-utils";
+import { settleCall } from "./proxy-error";
+import { Idempotent, isIdempotent } from "./idempotency";
+import { CircuitBreakerOptions } from "./circuit-breaker";
 import {
-  getMetadataFromHeader,
-  getTrailersMetadata,
-  httpStatus2GrpcStatus,
-  toMetadataHeader,
-} from "./openapi-utils";
-
-const proxy = axios.create();
+  GatewayResolver,
+  isGatewayResolver,
+  unresolvedResult,
+} from "./gateway-resolver";
+import { TlsOptions } from "./tls-options";
+import { ProxyConfig } from "./http-proxy";
+import {
+  CredentialsOption,
+  getServiceUrl,
+  toCallCredentials,
+  withCallCredentials,
+} from "./call-credentials";
+import { ClientCredentialsProvider, OAuth2Options } from "./oauth2";
+import { RequestSigner } from "./request-signer";
+import { HeaderMatchingOptions, HeaderRule } from "./header-matcher";
+import { createDefaultClient, HttpClient } from "./http-client";
+import {
+  GatewayList,
+  isValidGatewayList,
+  LoadBalancingOptions,
+} from "./load-balancer";
+import { CallHandler, ProxyChannel } from "./proxy-channel";
+import {
+  CallPipeline,
+  createObservers,
+  createTransport,
+} from "./call-pipeline";
+import {
+  ChannelOptions,
+  InterceptingCall,
+  Interceptor,
+  InterceptorOptions,
+  NextCall,
+} from "@grpc/grpc-js";
+import {
+  createTransportHealth,
+  downstreamInterceptorCall,
+  FallbackOptions,
+  hasDownstreamInterceptor,
+  hybridCall,
+  proxyCall,
+  ProxyInterceptor,
+  withEvents,
+} from "./interceptor-call";
 
 export interface InterceptorOption {
   // 是否开启拦截器
   enable?: boolean;
-  // 提供服务的服务器地址如：http://127.0.0.1:9090
-  getaway: string | ((callPath: string) => string);
+  // 提供服务的服务器地址如：http://127.0.0.1:9090，配置多个地址时按 loadBalancing 分配
+  getaway:
+    | string
+    | ((callPath: string) => string | Promise<string>)
+    | GatewayList
+    | GatewayResolver;
+  // 在直连和 grpc-gateway 之间自动切换，不配置时始终使用 grpc-gateway
+  fallback?: FallbackOptions;
+  // 幂等的方法，只有幂等的方法才会在切换传输方式时重发
+  idempotent?: Idempotent;
+  // 在通过 http 接管调用之前需要经过的拦截器（client 中不能有排在该拦截器之后的拦截器）
+  interceptors?: Interceptor[];
+  // 和 client 相同的 channel options，会读取 grpc.service_config 中的 retryPolicy
+  channelOptions?: ChannelOptions;
+  // 按 grpc-gateway 地址熔断，不配置时不熔断
+  circuitBreaker?: CircuitBreakerOptions;
+  // getaway 配置了多个地址时的负载均衡策略和健康检查
+  loadBalancing?: LoadBalancingOptions;
+  // 发送请求使用的 http 客户端：axios 实例、兼容 fetch 的函数或者 undiciClient()
+  httpClient?: HttpClient;
+  // 访问 https 的 grpc-gateway 时使用的 CA、客户端证书等，只作用于默认的 http 客户端和健康检查
+  tls?: TlsOptions;
+  // 访问 grpc-gateway 使用的代理，只作用于默认的 http 客户端和健康检查，不配置时读取 HTTPS_PROXY/HTTP_PROXY/NO_PROXY
+  proxy?: ProxyConfig;
+  // 调用凭证，如 credentials.createFromMetadataGenerator，生成的 authorization 会作为 Authorization 头发送
+  // 和单次调用的 credentials 选项组合使用
+  credentials?: CredentialsOption;
+  // grpc-gateway 前的 ingress 要求的 OAuth2 client credentials 鉴权，和 gRPC 的调用凭证无关
+  oauth2?: OAuth2Options | ClientCredentialsProvider;
+  // 发送前对最终的地址、头和请求体签名，返回需要添加的头，可以使用 hmacSigner、sigV4Signer
+  signRequest?: RequestSigner;
+  // metadata 和 http 头之间的转换规则，需要和 grpc-gateway 的 WithIncomingHeaderMatcher/WithOutgoingHeaderMatcher 对应
+  headers?: HeaderMatchingOptions;
+  // @opentelemetry/api 的 Tracer，如 trace.getTracer("grpc-proxy-interceptor")，配置后为每个调用创建 client span，
+  // 并通过 traceparent/tracestate 头传播链路，grpc-web 模式还会发送 grpc-trace-bin
+  tracer?: Tracer;
+  // 调用和 http 请求的统计，可以使用 prometheusMetrics、openTelemetryMetrics
+  metrics?: ProxyMetrics;
+  // 日志的接收方，如 consoleLogger("debug")，默认只输出 warn 和 error 到 console
+  logger?: ProxyLogger;
+  // 日志中隐藏值的头，默认隐藏 authorization、proxy-authorization、cookie 和 set-cookie
+  redactedHeaders?: string[];
 }
 
-function getRequestUrl(
+async function getBaseUrl(
   getaway: InterceptorOption["getaway"],
   callPath: string
 ) {
-  const baseUrl = typeof getaway === "function" ? getaway(callPath) : getaway;
-  return baseUrl + callPath;
+  // 多个地址时由 LoadBalancer 填充 baseUrl
+  if (typeof getaway === "object") return "";
+  return typeof getaway === "function" ? getaway(callPath) : getaway;
 }
 
 function checkInterceptorOption(opt: InterceptorOption): InterceptorOption {
@@ -34,101 +117,144 @@ function checkInterceptorOption(opt: InterceptorOption): InterceptorOption {
   if (typeof opt.getaway === "string" && !isValidUrl(opt.getaway)) {
     throw new Error("Invalid opt.getaway ！");
   }
+  if (Array.isArray(opt.getaway) && !isValidGatewayList(opt.getaway)) {
+    throw new Error("Invalid opt.getaway ！");
+  }
+  if (
+    typeof opt.getaway === "object" &&
+    !Array.isArray(opt.getaway) &&
+    !isGatewayResolver(opt.getaway)
+  ) {
+    throw new Error("Invalid opt.getaway ！");
+  }
   return opt;
 }
 
 // TODO 对 grpc.status 和 metadata 进行核对
-async function proxyTo(
-  path: string,
-  message: any,
-  metadata: Metadata
-): Promise<CallResult<any>> {
-  try {
-    const result = await proxy.post(path, message, {
-      headers: toMetadataHeader(metadata),
-    });
-    const resultMetadata = getMetadataFromHeader(result.headers);
-    return {
-      response: result.data,
-      metadata: resultMetadata,
-      status: {
-        code: httpStatus2GrpcStatus(result.status),
-        details: "",
-        metadata: getTrailersMetadata(result.request.res.rawTrailers),
-      },
-    };
-  } catch (err: any) {
-    if (err.response) {
-      return {
-        response: err.response.data as Response,
-        metadata: getMetadataFromHeader(err.response.headers),
-        status: {
-          metadata: getTrailersMetadata(err.response.request.res.rawTrailers),
-          details: err.response.data.message,
-          code: httpStatus2GrpcStatus(err.response.status),
-        },
-      };
+// grpc-web+json 的调用方式：POST {baseUrl}{callPath}
+function proxyTo(
+  transport: HttpTransport,
+  getaway: InterceptorOption["getaway"],
+  headerRule?: HeaderRule
+): CallHandler {
+  const send: CallHandler = async (callPath, message, metadata, signal) => {
+    let baseUrl: string;
+    try {
+      baseUrl = await getBaseUrl(getaway, callPath);
+    } catch (err) {
+      return unresolvedResult(callPath, err as Error);
     }
-    return {
-      response: null as unknown as Response,
-      metadata: new Metadata(),
-      status: {
-        metadata: new Metadata(),
-        details: (err as Error).message,
-        code: status.UNKNOWN,
+    return transport.send({
+      callPath,
+      metadata,
+      baseUrl,
+      config: {
+        signal,
+        method: "post",
+        url: callPath,
+        data: message,
+        headers: toMetadataHeader(metadata, headerRule),
       },
-    };
-  }
+    });
+  };
+  // middleware 抛出的错误也转换成 grpc 状态
+  return (callPath, message, metadata, signal) =>
+    settleCall(() => send(callPath, message, metadata, signal));
 }
 
 /**
  * grpc client interceptor 代理 grpc 请求到 grpc-getaway 的拦截器
- *  1. 使用时确保该拦截器在最后一个（此拦截器不会调用后续的拦截器）
+ *  1. 此拦截器会接管调用，client 中排在它之后的拦截器会导致调用返回 FAILED_PRECONDITION，需要通过 opts.interceptors 传入
+ *     或者使用 Channel 的方式，这样所有的拦截器都会在 http 调用之前执行
  *  2. 注意调用该拦截器是会异步读取并解析 openapi 文件，期间拦截器将不工作
- * @return {Promise<Interceptor>}
+ * @return {ProxyInterceptor}
  */
-export function interceptor(opt: InterceptorOption): Interceptor {
-  return function interceptorImpl(options, nextCall) {
+export function interceptor(opt: InterceptorOption): ProxyInterceptor {
+  const emitter = new EventEmitter();
+  const health = createTransportHealth(opt.fallback, emitter);
+  const observers = createObservers(opt, "grpc-web");
+  const transport = createTransport(opt, emitter, observers);
+  const pipeline = new CallPipeline(
+    proxyTo(transport, opt.getaway, opt.headers?.request),
+    opt,
+    emitter,
+    observers
+  );
+  const target = typeof opt.getaway === "string" ? opt.getaway : "";
+  function interceptorImpl(
+    options: InterceptorOptions,
+    nextCall: NextCall
+  ): InterceptingCall {
     const callPath = options.method_definition.path;
-    const { enable, getaway } = checkInterceptorOption(opt);
+    const { enable } = checkInterceptorOption(opt);
     if (!enable) {
       return new InterceptingCall(nextCall(options));
     }
     // grpc-web 是支持 responseStream 的
     if (options.method_definition.requestStream) {
-      console.warn(`${callPath}: 不支持流式调用!`);
+      observers.logging?.log(
+        "warn",
+        `${callPath}: streaming calls are sent to the gRPC port directly`,
+        { callPath }
+      );
       return new InterceptingCall(nextCall(options));
     }
-
-    const ref = {
-      message: null as unknown,
-      metadata: new Metadata(),
-      listener: {} as InterceptingListener,
-    };
-    return new InterceptingCall(nextCall(options), {
-      start: function (metadata, listener, next) {
-        ref.metadata = metadata;
-        ref.listener = listener;
-      },
-      sendMessage: async function (message, next) {
-        ref.message = message;
-      },
-      halfClose: async function (next) {
-        // 这里的 message 是还没有被 protobuf 序列化的。
-        // 注意此刻的 metadata 的 key 会被全部转换为小写，但是通过 get 方法取值时，是大小写不敏感的。
-        // 此刻的 value 类型是 [MedataValue]
-        // call 方法保证即使是内部错误，也会返回一个正确的结构
-        const { metadata, message, listener } = ref;
-        const result = await proxyTo(
-          getRequestUrl(getaway, callPath),
-          message,
-          metadata
-        );
-        // 下面方法的顺序是有要求的。
-        listener.onReceiveMessage(result.response);
-        listener.onReceiveMetadata(result.metadata);
-        listener.onReceiveStatus(result.status);
+    if (hasDownstreamInterceptor(nextCall)) {
+      return downstreamInterceptorCall(options, observers.logging);
+    }
+    // 这里的 message 是还没有被 protobuf 序列化的。
+    // 注意此刻的 metadata 的 key 会被全部转换为小写，但是通过 get 方法取值时，是大小写不敏感的。
+    // proxyTo 保证即使是内部错误，也会返回一个正确的结构
+    const handler = withCallCredentials(
+      pipeline.handler(callPath),
+      toCallCredentials(opt.credentials, options.credentials),
+      getServiceUrl(options.host || target, callPath)
+    );
+    if (!health) {
+      return proxyCall(options, handler, opt.interceptors);
+    }
+    return hybridCall(
+      options,
+      nextCall,
+      handler,
+      {
+        health,
+        target: options.host || target,
+        idempotent: isIdempotent(opt.idempotent, callPath),
       },
-    });
-  };
+      opt.interceptors
+    );
+  }
+  return withEvents(interceptorImpl, emitter, transport);
+}
+
+/**
+ * 创建完全通过 grpc-gateway 调用的 Channel，不会打开到 gRPC 端口的连接
+ * 使用方式：new Client(address, credentials, { channelOverride: grpcWebChannel(opt) })
+ * @param opt {InterceptorOption & {definition: PackageDefinition}} - definition 是 @grpc/proto-loader 加载的结果
+ * @return {ProxyChannel}
+ */
+export function grpcWebChannel(
+  opt: InterceptorOption & { definition: Record<string, any> }
+): ProxyChannel {
+  const { getaway } = checkInterceptorOption(opt);
+  const emitter = new EventEmitter();
+  const observers = createObservers(opt, "grpc-web");
+  const transport = createTransport(opt, emitter, observers);
+  const pipeline = new CallPipeline(
+    proxyTo(transport, getaway, opt.headers?.request),
+    opt,
+    emitter,
+    observers
+  );
+  return new ProxyChannel({
+    emitter,
+    client: createDefaultClient(opt),
+    target: typeof getaway === "string" ? getaway : "",
+    credentials: toCallCredentials(opt.credentials),
+    definition: opt.definition,
+    close: () => transport.close(),
+    handler: (callPath, message, metadata, signal) =>
+      pipeline.handler(callPath)(message, metadata, signal),
+  });
 }
@@ -0,0 +1,143 @@
+// 和 grpc-gateway 的 HeaderMatcherFunc 相同：返回转换后的名称和是否需要转换
+export type HeaderMatcher = (key: string) => [string, boolean];
+
+// 字符串忽略大小写完全匹配，以 * 结尾时匹配前缀
+export type HeaderPattern = string | RegExp;
+
+export interface HeaderRule {
+  // 请求方向参数是 metadata key，响应方向参数是小写的 http 头名称
+  matcher?: HeaderMatcher;
+  // 配置后只有匹配的 key（请求方向）或者头（响应方向）才会被转换
+  allow?: HeaderPattern[];
+  // 匹配的不会被转换，优先于 allow
+  deny?: HeaderPattern[];
+}
+
+export interface HeaderMatchingOptions {
+  // 请求方向：metadata → http 请求头，对应 grpc-gateway 的 WithIncomingHeaderMatcher
+  request?: HeaderRule;
+  // 响应方向：http 响应头 → metadata，对应 grpc-gateway 的 WithOutgoingHeaderMatcher
+  response?: HeaderRule;
+}
+
+export const METADATA_HEADER_PREFIX = "Grpc-Metadata-";
+
+// grpc-gateway 会把这些 key 加上 grpcgateway- 前缀
+const GATEWAY_METADATA_PREFIX = "grpcgateway-";
+
+// grpc-gateway 的 isPermanentHTTPHeader，这些头会被 DefaultHeaderMatcher 转换成 grpcgateway-{key}
+export const PERMANENT_HTTP_HEADERS = [
+  "Accept",
+  "Accept-Charset",
+  "Accept-Language",
+  "Accept-Ranges",
+  "Authorization",
+  "Cache-Control",
+  "Content-Type",
+  "Cookie",
+  "Date",
+  "Expect",
+  "From",
+  "Host",
+  "If-Match",
+  "If-Modified-Since",
+  "If-None-Match",
+  "If-Schedule-Tag-Match",
+  "If-Unmodified-Since",
+  "Max-Forwards",
+  "Origin",
+  "Pragma",
+  "Referer",
+  "User-Agent",
+  "Via",
+  "Warning",
+];
+
+const permanentHeaders = new Set(
+  PERMANENT_HTTP_HEADERS.map((item) => item.toLowerCase())
+);
+
+// 由 http 客户端决定的头，metadata 中的同名 key 不能覆盖它们
+const reservedHeaders = new Set(["content-type", "host"]);
+
+/**
+ * 和 Go 的 textproto.CanonicalMIMEHeaderKey 相同：x-request-id → X-Request-Id
+ * @param key {string}
+ * @return {string}
+ */
+export function canonicalHeaderKey(key: string): string {
+  return key
+    .toLowerCase()
+    .replace(/(^|-)([a-z])/g, (_, dash, c) => `${dash}${c.toUpperCase()}`);
+}
+
+/**
+ * 判断是否是 grpc-gateway 的 permanent http 头
+ * @param key {string}
+ * @return {boolean}
+ */
+export function isPermanentHttpHeader(key: string): boolean {
+  return permanentHeaders.has(key.toLowerCase());
+}
+
+/**
+ * 默认的请求方向转换，是 grpc-gateway 的 DefaultHeaderMatcher 的逆向
+ *  1. permanent http 头（和 grpcgateway- 前缀的 key）作为真实的头发送，authorization 会被 grpc-gateway 原样转换回来
+ *  2. 其他的 key 加上 Grpc-Metadata- 前缀
+ *  3. content-type 和 host 由 http 客户端决定，仍然加上前缀
+ * @param key {string} - metadata key
+ * @return {[string, boolean]}
+ */
+export function defaultRequestHeaderMatcher(key: string): [string, boolean] {
+  const name = key.startsWith(GATEWAY_METADATA_PREFIX)
+    ? key.slice(GATEWAY_METADATA_PREFIX.length)
+    : key;
+  if (isPermanentHttpHeader(name) && !reservedHeaders.has(name)) {
+    return [canonicalHeaderKey(name), true];
+  }
+  return [`${METADATA_HEADER_PREFIX}${key}`, true];
+}
+
+/**
+ * 默认的响应方向转换，对应 grpc-gateway 默认的 outgoing matcher：只接收 Grpc-Metadata- 前缀的头
+ * @param header {string} - 小写的 http 头名称
+ * @return {[string, boolean]}
+ */
+export function defaultResponseHeaderMatcher(
+  header: string
+): [string, boolean] {
+  const prefix = METADATA_HEADER_PREFIX.toLowerCase();
+  if (!header.startsWith(prefix)) return ["", false];
+  return [header.slice(prefix.length), true];
+}
+
+function matchPattern(key: string, pattern: HeaderPattern): boolean {
+  if (pattern instanceof RegExp) return pattern.test(key);
+  const expected = pattern.toLowerCase();
+  if (expected.endsWith("*")) return key.startsWith(expected.slice(0, -1));
+  return key === expected;
+}
+
+/**
+ * 根据 allow/deny 和 matcher 创建转换函数，不转换时返回 null
+ * @param rule {HeaderRule | undefined}
+ * @param defaultMatcher {HeaderMatcher}
+ * @return {(key: string) => string | null}
+ */
+export function createHeaderMapper(
+  rule: HeaderRule | undefined,
+  defaultMatcher: HeaderMatcher
+): (key: string) => string | null {
+  const matcher = rule?.matcher || defaultMatcher;
+  return (key) => {
+    const name = key.toLowerCase();
+    if (rule?.deny?.some((pattern) => matchPattern(name, pattern))) {
+      return null;
+    }
+    if (rule?.allow && !rule.allow.some((p) => matchPattern(name, p))) {
+      return null;
+    }
+    const [mapped, ok] = matcher(name);
+    return ok && mapped ? mapped : null;
+  };
+}
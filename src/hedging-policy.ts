@@ -0,0 +1,89 @@
+import { CallResult } from "./grpc-utils";
+import { ProxyHandler } from "./interceptor-call";
+import {
+  HedgingPolicy,
+  MAX_ATTEMPTS,
+  parseDuration,
+  toStatusCode,
+} from "./retry-policy";
+
+// 对冲请求结束时触发的 hedge 事件的参数
+export interface HedgeEvent {
+  callPath: string;
+  // 实际发送的请求数
+  attempts: number;
+  // 被采用的请求的序号，0 是第一个请求，大于 0 说明对冲请求胜出
+  winner: number;
+}
+
+/**
+ * 给 http 调用加上对冲请求，语义和 grpc-js 的 hedgingPolicy 相同
+ *  1. 第一个请求发出后，每隔 hedgingDelay 发送一个相同的请求，直到达到 maxAttempts
+ *  2. 收到 nonFatalStatusCodes 中的状态码时立即发送下一个请求
+ *  3. 第一个成功或者致命错误的结果会被采用，其他未完成的请求会被取消
+ *  4. 只有幂等的方法才会发送对冲请求
+ * @param handler {ProxyHandler}
+ * @param policy {HedgingPolicy | null}
+ * @param idempotent {boolean}
+ * @param callPath {string}
+ * @param onHedge {(event: HedgeEvent) => void} - 发送过对冲请求的调用结束时触发
+ * @return {ProxyHandler}
+ */
+export function withHedging(
+  handler: ProxyHandler,
+  policy: HedgingPolicy | null,
+  idempotent: boolean,
+  callPath: string,
+  onHedge: (event: HedgeEvent) => void
+): ProxyHandler {
+  if (!policy || !idempotent || policy.maxAttempts < 2) return handler;
+  const maxAttempts = Math.min(policy.maxAttempts, MAX_ATTEMPTS);
+  const delay = parseDuration(policy.hedgingDelay || "0s");
+  const nonFatalCodes = (policy.nonFatalStatusCodes || []).map(toStatusCode);
+  return function (message, metadata, signal) {
+    return new Promise<CallResult<any>>((resolve) => {
+      const controllers: AbortController[] = [];
+      let timer: NodeJS.Timeout | undefined;
+      let finished = false;
+      let pending = 0;
+
+      const commit = (result: CallResult<any>, winner: number) => {
+        finished = true;
+        clearTimeout(timer);
+        controllers.forEach((controller, index) => {
+          if (index !== winner) controller.abort();
+        });
+        if (controllers.length > 1) {
+          onHedge({ callPath, attempts: controllers.length, winner });
+        }
+        resolve(result);
+      };
+
+      const send = () => {
+        if (finished || signal?.aborted) return;
+        const index = controllers.length;
+        const controller = new AbortController();
+        signal?.addEventListener("abort", () => controller.abort());
+        controllers.push(controller);
+        pending += 1;
+        handler(message, metadata, controller.signal).then((result) => {
+          pending -= 1;
+          if (finished) return;
+          if (!nonFatalCodes.includes(result.status.code)) {
+            commit(result, index);
+          } else if (controllers.length < maxAttempts && !signal?.aborted) {
+            clearTimeout(timer);
+            send();
+          } else if (pending === 0) {
+            commit(result, index);
+          }
+        });
+        if (controllers.length < maxAttempts) {
+          timer = setTimeout(send, delay);
+        }
+      };
+
+      send();
+    });
+  };
+}
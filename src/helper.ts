@@ -96,9 +96,9 @@ export async function checkPathIsExist(url: string): Promise<boolean> {
 /**
  * 同步检查路径是否存在
  * @param url {string} - 绝对路径
- * @return {Promise<boolean>}
+ * @return {boolean}
  */
-export async function checkPathIsExistSync(url: string): Promise<boolean> {
+export function checkPathIsExistSync(url: string): boolean {
   try {
     statSync(url);
     return true;
@@ -113,5 +113,10 @@ export async function checkPathIsExistSync(url: string): Promise<boolean> {
  * @return {boolean}
  */
 export function isValidUrl(url: string): boolean {
-  return /^https?:\/\/.+?/.test(url);
+  if (!/^https?:\/\/.+?/.test(url)) return false;
+  try {
+    return Boolean(new URL(url).hostname);
+  } catch (err) {
+    return false;
+  }
 }
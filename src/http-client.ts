@@ -0,0 +1,326 @@
+import type { Dispatcher } from "undici";
+import axios, { AxiosInstance } from "axios";
+import { ChannelOptions } from "@grpc/grpc-js";
+import type { ProxyRequest } from "./http-transport";
+import { TlsOptions, toConnectionOptions } from "./tls-options";
+import {
+  ProxyConfig,
+  proxyAuthorization,
+  resolveProxy,
+  TunnelAgent,
+} from "./http-proxy";
+
+// http 客户端收到的响应，没有收到响应时客户端应该抛出错误
+export interface HttpResponse {
+  status: number;
+  headers: Record<string, string>;
+  data: any;
+  // 原始的 http trailers：[key, value, key, value, ...]
+  rawTrailers?: string[];
+}
+
+// 发送给非 axios 客户端的请求，url 已经拼接了 baseUrl 和 query
+export interface HttpRequest {
+  method: string;
+  url: string;
+  headers: Record<string, string>;
+  body?: string | Buffer;
+  signal?: AbortSignal;
+}
+
+// 兼容 fetch 的函数，如 globalThis.fetch、node-fetch
+export type FetchLike = (
+  url: string,
+  init: {
+    method: string;
+    headers: Record<string, string>;
+    body?: string | Buffer;
+    signal?: AbortSignal;
+  }
+) => Promise<{
+  status: number;
+  headers: { forEach(callback: (value: string, key: string) => void): void };
+  text(): Promise<string>;
+}>;
+
+// 自定义的 http 客户端，如 undiciClient
+export interface HttpClientAdapter {
+  send(request: ProxyRequest): Promise<HttpResponse>;
+  close?(): void | Promise<void>;
+}
+
+export type HttpClient = AxiosInstance | FetchLike | HttpClientAdapter;
+
+function isAxiosInstance(client: HttpClient): client is AxiosInstance {
+  return (
+    typeof client === "function" &&
+    typeof (client as AxiosInstance).request === "function" &&
+    "interceptors" in client
+  );
+}
+
+// 和 axios 一样拼接 baseURL 和 url
+function combineUrl(baseUrl: string, url = ""): string {
+  if (/^https?:\/\//.test(url)) return url;
+  if (!url) return baseUrl;
+  return `${baseUrl.replace(/\/+$/, "")}/${url.replace(/^\/+/, "")}`;
+}
+
+// 和 axios 一样，响应是 JSON 时解析，否则保留原始字符串
+function parseBody(text: string): any {
+  if (text === "") return text;
+  try {
+    return JSON.parse(text);
+  } catch (err) {
+    return text;
+  }
+}
+
+/**
+ * 把给 axios 使用的请求配置转换成普通的 http 请求
+ * @param request {ProxyRequest}
+ * @return {HttpRequest}
+ */
+export function toHttpRequest(request: ProxyRequest): HttpRequest {
+  const { config } = request;
+  let url = combineUrl(request.baseUrl, config.url);
+  if (config.params) {
+    const query = config.paramsSerializer
+      ? config.paramsSerializer(config.params)
+      : new URLSearchParams(config.params).toString();
+    if (query) url += `${url.includes("?") ? "&" : "?"}${query}`;
+  }
+  const headers: Record<string, string> = {};
+  Object.entries(config.headers || {}).forEach(([key, value]) => {
+    if (value !== undefined && value !== null) headers[key] = String(value);
+  });
+  let body: string | Buffer | undefined;
+  if (typeof config.data === "string" || Buffer.isBuffer(config.data)) {
+    body = config.data;
+  } else if (config.data !== undefined) {
+    body = JSON.stringify(config.data);
+    const hasType = Object.keys(headers).some(
+      (key) => key.toLowerCase() === "content-type"
+    );
+    if (!hasType) headers["Content-Type"] = "application/json";
+  }
+  return {
+    url,
+    body,
+    headers,
+    signal: config.signal as AbortSignal | undefined,
+    method: (config.method || "get").toUpperCase(),
+  };
+}
+
+function axiosAdapter(client: AxiosInstance): HttpClientAdapter {
+  return {
+    async send(request) {
+      try {
+        const result = await client.request({
+          ...request.config,
+          baseURL: request.baseUrl,
+        });
+        return {
+          status: result.status,
+          headers: result.headers,
+          data: result.data,
+          rawTrailers: result.request?.res?.rawTrailers,
+        };
+      } catch (err: any) {
+        if (!err.response) throw err;
+        return {
+          status: err.response.status,
+          headers: err.response.headers,
+          data: err.response.data,
+          rawTrailers: err.response.request?.res?.rawTrailers,
+        };
+      }
+    },
+  };
+}
+
+// fetch 拿不到 http trailers，通过 trailers 返回的 metadata 会丢失
+function fetchAdapter(fetch: FetchLike): HttpClientAdapter {
+  return {
+    async send(request) {
+      const { url, ...init } = toHttpRequest(request);
+      const result = await fetch(url, init);
+      const headers: Record<string, string> = {};
+      result.headers.forEach((value, key) => {
+        headers[key.toLowerCase()] = value;
+      });
+      return {
+        headers,
+        status: result.status,
+        data: parseBody(await result.text()),
+      };
+    },
+  };
+}
+
+// 默认 http 客户端的配置
+export interface DefaultClientOptions {
+  tls?: TlsOptions;
+  proxy?: ProxyConfig;
+  channelOptions?: ChannelOptions;
+}
+
+/**
+ * 创建默认的 axios 实例
+ *  1. https 的地址通过 TunnelAgent 使用 CONNECT 隧道访问代理，同时应用 tls 配置
+ *  2. http 的地址使用 axios 的 proxy 配置，每个请求都会按 NO_PROXY 判断是否需要代理
+ * @param opts {DefaultClientOptions}
+ * @return {AxiosInstance}
+ */
+export function createDefaultClient(
+  opts: DefaultClientOptions = {}
+): AxiosInstance {
+  const client = axios.create({
+    httpsAgent: new TunnelAgent({
+      keepAlive: true,
+      proxy: opts.proxy,
+      ...(opts.tls ? toConnectionOptions(opts.tls, opts.channelOptions) : {}),
+    }),
+  });
+  client.interceptors.request.use((config) => {
+    const url = combineUrl(config.baseURL || "", config.url);
+    const proxy = url.startsWith("http:")
+      ? resolveProxy(url, opts.proxy)
+      : null;
+    // axios 自己读取环境变量时不支持 https 的代理，所以总是显式配置
+    config.proxy = proxy
+      ? {
+          protocol: proxy.protocol,
+          host: proxy.hostname,
+          port: Number(proxy.port) || 80,
+          auth: proxy.username
+            ? {
+                username: decodeURIComponent(proxy.username),
+                password: decodeURIComponent(proxy.password),
+              }
+            : undefined,
+        }
+      : false;
+    return config;
+  });
+  return client;
+}
+
+/**
+ * 把 httpClient 选项统一转换成 HttpClientAdapter
+ * @param client {HttpClient}
+ * @return {HttpClientAdapter}
+ */
+export function toHttpClientAdapter(client: HttpClient): HttpClientAdapter {
+  if (isAxiosInstance(client)) return axiosAdapter(client);
+  if (typeof client === "function") return fetchAdapter(client);
+  return client;
+}
+
+export interface UndiciClientOptions {
+  // 每个 grpc-gateway 的最大连接数，默认不限制
+  connections?: number;
+  // 空闲连接保持的时间，单位毫秒，默认 4000
+  keepAliveTimeout?: number;
+  // 服务端通过 keep-alive 头指定的保持时间的上限，单位毫秒，默认 600000
+  keepAliveMaxTimeout?: number;
+  // 每个连接上同时发送的请求数，默认 1
+  pipelining?: number;
+  // 建立连接的超时时间，单位毫秒，默认 10000
+  connectTimeout?: number;
+  // 访问 https 的 grpc-gateway 时使用的 TLS 配置
+  tls?: TlsOptions;
+  // 访问 grpc-gateway 使用的代理，不配置时读取 HTTPS_PROXY/HTTP_PROXY/NO_PROXY
+  proxy?: ProxyConfig;
+  // 其他传给 undici Pool 的选项
+  poolOptions?: Record<string, unknown>;
+}
+
+/**
+ * 基于 undici 的 http 客户端，每个 grpc-gateway 使用一个连接池
+ * 需要代理时使用 undici 的 ProxyAgent，通过 CONNECT 隧道访问 grpc-gateway
+ * undici 是可选的依赖，第一次发送请求时才会加载
+ */
+export class UndiciClient implements HttpClientAdapter {
+  private readonly pools = new Map<string, Promise<Dispatcher>>();
+
+  constructor(private opts: UndiciClientOptions = {}) {}
+
+  private getPool(origin: string): Promise<Dispatcher> {
+    let pool = this.pools.get(origin);
+    if (!pool) {
+      const { poolOptions, connectTimeout, tls, proxy, ...opts } = this.opts;
+      const tlsOptions = tls ? toConnectionOptions(tls) : {};
+      const timeout = connectTimeout ? { timeout: connectTimeout } : {};
+      const proxyUrl = resolveProxy(origin, proxy);
+      pool = import("undici").then(({ Pool, ProxyAgent }) => {
+        if (!proxyUrl) {
+          const connect = { ...tlsOptions, ...timeout } as any;
+          return new Pool(origin, { ...opts, ...poolOptions, connect });
+        }
+        const authorization = proxyAuthorization(proxyUrl);
+        proxyUrl.username = "";
+        proxyUrl.password = "";
+        return new ProxyAgent({
+          ...opts,
+          ...poolOptions,
+          uri: proxyUrl.toString(),
+          token: authorization,
+          requestTls: { ...tlsOptions, ...timeout } as any,
+        });
+      });
+      this.pools.set(origin, pool);
+    }
+    return pool;
+  }
+
+  public async send(request: ProxyRequest): Promise<HttpResponse> {
+    const { url, method, headers, body, signal } = toHttpRequest(request);
+    const target = new URL(url);
+    const pool = await this.getPool(target.origin);
+    const result = await pool.request({
+      origin: target.origin,
+      method: method as any,
+      headers,
+      body,
+      signal,
+      path: `${target.pathname}${target.search}`,
+    });
+    const data = parseBody(await result.body.text());
+    const responseHeaders: Record<string, string> = {};
+    Object.entries(result.headers).forEach(([key, value]) => {
+      if (value === undefined) return;
+      responseHeaders[key] = Array.isArray(value) ? value.join(", ") : value;
+    });
+    // undici 的 trailers 的 key 是小写的，转换成和 node 的 rawTrailers 相同的格式
+    const rawTrailers = Object.entries(result.trailers || {}).flatMap(
+      ([key, value]) => [
+        key.replace(/^grpc-trailer-/, "Grpc-Trailer-"),
+        String(value),
+      ]
+    );
+    return {
+      data,
+      rawTrailers,
+      status: result.statusCode,
+      headers: responseHeaders,
+    };
+  }
+
+  // 关闭所有连接池
+  public async close() {
+    const pools = await Promise.all(this.pools.values());
+    this.pools.clear();
+    await Promise.all(pools.map((pool) => pool.close()));
+  }
+}
+
+/**
+ * 创建基于 undici 的 http 客户端
+ * @param [opts] {UndiciClientOptions}
+ * @return {UndiciClient}
+ */
+export function undiciClient(opts?: UndiciClientOptions): UndiciClient {
+  return new UndiciClient(opts);
+}
@@ -0,0 +1,142 @@
+import { Metadata } from "@grpc/grpc-js";
+import { CallResult } from "./grpc-utils";
+import axios, { AxiosRequestConfig } from "axios";
+import { HeaderRule } from "./header-matcher";
+import { DecodeError, errorResult, TransportError } from "./proxy-error";
+import {
+  HttpClient,
+  HttpClientAdapter,
+  HttpResponse,
+  toHttpClientAdapter,
+} from "./http-client";
+import {
+  getErrorDetails,
+  getMetadataFromHeader,
+  getTrailersMetadata,
+  httpStatus2GrpcStatus,
+} from "./openapi-utils";
+
+// 一次需要发送到 grpc-gateway 的 http 请求
+export interface ProxyRequest {
+  callPath: string;
+  // 调用的 metadata，负载均衡等 middleware 会读取它
+  metadata?: Metadata;
+  // grpc-gateway 服务地址，如：http://127.0.0.1:4501
+  baseUrl: string;
+  // 最终交给 http 客户端的请求配置，baseURL 会使用上面的 baseUrl
+  config: AxiosRequestConfig;
+}
+
+export type SendRequest = (request: ProxyRequest) => Promise<CallResult<any>>;
+
+// 发送请求前后的处理，如熔断，调用 next 继续发送请求
+export type TransportMiddleware = (
+  request: ProxyRequest,
+  next: SendRequest
+) => Promise<CallResult<any>>;
+
+/**
+ * 把 http 请求的结果转换成 CallResult，保证即使是内部错误，也会返回一个正确的结构
+ * @param client {HttpClientAdapter}
+ * @param request {ProxyRequest}
+ * @param [headerRule] {HeaderRule} - 响应头转换成 metadata 的规则
+ * @return {Promise<CallResult<any>>}
+ */
+async function sendRequest(
+  client: HttpClientAdapter,
+  request: ProxyRequest,
+  headerRule?: HeaderRule
+): Promise<CallResult<any>> {
+  let result: HttpResponse;
+  try {
+    result = await client.send(request);
+  } catch (err) {
+    // 没有收到响应说明 grpc-gateway 无法连接
+    return errorResult(
+      new TransportError(
+        `failed to send ${request.callPath} to ` +
+          `${request.baseUrl || "grpc-gateway"}: ${(err as Error).message}`,
+        err
+      )
+    );
+  }
+  const ok = result.status >= 200 && result.status < 300;
+  // 成功的响应必须是 JSON 对象才能作为响应消息
+  if (ok && (typeof result.data !== "object" || result.data === null)) {
+    return {
+      ...errorResult(
+        new DecodeError(
+          `${request.callPath}: grpc-gateway responded ${result.status} ` +
+            `with a non-JSON body`
+        )
+      ),
+      http: {
+        status: result.status,
+        headers: result.headers,
+        gateway: request.baseUrl,
+        data: result.data,
+      },
+    };
+  }
+  // 失败的调用只有状态，没有响应消息
+  return {
+    response: ok ? result.data : null,
+    metadata: getMetadataFromHeader(result.headers, headerRule),
+    status: {
+      code: httpStatus2GrpcStatus(result.status),
+      details: ok ? "" : getErrorDetails(result.status, result.data),
+      metadata: getTrailersMetadata(result.rawTrailers || []),
+    },
+    http: {
+      status: result.status,
+      headers: result.headers,
+      gateway: request.baseUrl,
+      data: result.data,
+    },
+  };
+}
+
+/**
+ * 两种拦截器共用的 http 发送层，按添加的顺序执行 middleware 后发送请求
+ */
+export class HttpTransport {
+  private readonly middlewares: TransportMiddleware[] = [];
+
+  // close 时需要一起释放的资源，如 LoadBalancer 的健康检查和 resolver
+  private readonly closers: (() => void)[] = [];
+
+  private readonly client: HttpClientAdapter;
+
+  constructor(
+    client: HttpClient = axios.create(),
+    private headerRule?: HeaderRule
+  ) {
+    this.client = toHttpClientAdapter(client);
+  }
+
+  public use(middleware: TransportMiddleware): this {
+    this.middlewares.push(middleware);
+    return this;
+  }
+
+  public send(request: ProxyRequest): Promise<CallResult<any>> {
+    const dispatch = (index: number, req: ProxyRequest) => {
+      if (index >= this.middlewares.length) {
+        return sendRequest(this.client, req, this.headerRule);
+      }
+      return this.middlewares[index](req, (next) => dispatch(index + 1, next));
+    };
+    return dispatch(0, request);
+  }
+
+  public onClose(closer: () => void): this {
+    this.closers.push(closer);
+    return this;
+  }
+
+  // 释放 middleware 的资源并关闭 http 客户端的连接，之后不应该再发送请求
+  public async close(): Promise<void> {
+    this.closers.splice(0).forEach((closer) => closer());
+    await this.client.close?.();
+  }
+}
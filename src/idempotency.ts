@@ -0,0 +1,48 @@
+// 幂等方法的声明方式：
+//  1. callPath 列表，如：["/example.greeter.v1.services.Greeter/SayHello"]
+//  2. 判断函数，接收 callPath 返回是否幂等
+export type Idempotent = string[] | ((callPath: string) => boolean);
+
+// proto 中 idempotency_level 的取值，NO_SIDE_EFFECTS 和 IDEMPOTENT 都认为是幂等的
+// See: https://github.com/protocolbuffers/protobuf/blob/main/src/google/protobuf/descriptor.proto
+const idempotencyLevels = ["NO_SIDE_EFFECTS", "IDEMPOTENT", 1, 2];
+
+/**
+ * 判断一个方法是否是幂等的，未声明的方法都认为是非幂等的
+ * @param idempotent {Idempotent | undefined}
+ * @param callPath {string}
+ * @return {boolean}
+ */
+export function isIdempotent(
+  idempotent: Idempotent | undefined,
+  callPath: string
+): boolean {
+  if (!idempotent) return false;
+  if (typeof idempotent === "function") return idempotent(callPath);
+  return idempotent.includes(callPath);
+}
+
+/**
+ * 从 @grpc/proto-loader 加载的 PackageDefinition 中读取声明了 idempotency_level 的方法
+ * 注意 proto-loader 需要保留 method 的 options 才能读取到
+ * @param packageDefinition {Record<string, any>}
+ * @return {string[]} - callPath 列表
+ */
+export function idempotentMethodsFromDefinition(
+  packageDefinition: Record<string, any>
+): string[] {
+  const paths: string[] = [];
+  for (const service of Object.values(packageDefinition)) {
+    // 只有 service 定义中的方法才会带有 path
+    if (!service || typeof service !== "object" || "format" in service) {
+      continue;
+    }
+    for (const method of Object.values(service as Record<string, any>)) {
+      const level = method?.options?.idempotency_level;
+      if (method?.path && idempotencyLevels.includes(level)) {
+        paths.push(method.path);
+      }
+    }
+  }
+  return paths;
+}
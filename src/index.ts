@@ -1,2 +1,127 @@
 export * from "./openapi-interceptor";
 export * from "./grpc-web-interceptor";
+export * from "./idempotency";
+export { ProxyChannel } from "./proxy-channel";
+export type { CallHandler, ProxyChannelOptions } from "./proxy-channel";
+export type { Transport, TransportSwitchEvent } from "./transport-health";
+export type {
+  FallbackOptions,
+  ProxyEvents,
+  ProxyInterceptor,
+} from "./interceptor-call";
+export type {
+  BalancingPolicy,
+  EndpointHealthEvent,
+  GatewayEndpoint,
+  GatewayList,
+  HealthCheckOptions,
+  LoadBalancingOptions,
+} from "./load-balancer";
+export {
+  CachedResolver,
+  dnsSrvResolver,
+  envResolver,
+  fileResolver,
+} from "./gateway-resolver";
+export type {
+  DnsSrvResolverOptions,
+  GatewayResolver,
+  ResolverOptions,
+} from "./gateway-resolver";
+export { UndiciClient, undiciClient } from "./http-client";
+export type {
+  FetchLike,
+  HttpClient,
+  HttpClientAdapter,
+  HttpRequest,
+  HttpResponse,
+  UndiciClientOptions,
+} from "./http-client";
+export type { TlsOptions } from "./tls-options";
+export { TunnelAgent } from "./http-proxy";
+export { createDefaultClient } from "./http-client";
+export type { ProxyConfig, ProxyOptions } from "./http-proxy";
+export type { DefaultClientOptions } from "./http-client";
+export type { CredentialsOption } from "./call-credentials";
+export { ClientCredentialsProvider } from "./oauth2";
+export type { OAuth2Options } from "./oauth2";
+export { hmacSigner, hmacStringToSign, sigV4Signer } from "./request-signer";
+export type {
+  HmacSignerOptions,
+  RequestSigner,
+  SignableRequest,
+  SigV4SignerOptions,
+} from "./request-signer";
+export {
+  canonicalHeaderKey,
+  defaultRequestHeaderMatcher,
+  defaultResponseHeaderMatcher,
+  isPermanentHttpHeader,
+  PERMANENT_HTTP_HEADERS,
+} from "./header-matcher";
+export type {
+  HeaderMatcher,
+  HeaderMatchingOptions,
+  HeaderPattern,
+  HeaderRule,
+} from "./header-matcher";
+export {
+  DecodeError,
+  ProxyError,
+  RouteNotFound,
+  TranscodeError,
+  TransportError,
+  ValidationError,
+} from "./proxy-error";
+export { encodeGrpcTraceBin, formatTraceparent } from "./tracing";
+export type { ProxyTransport } from "./tracing";
+export { openTelemetryMetrics, prometheusMetrics } from "./metrics";
+export type {
+  CallMetric,
+  LoadMetric,
+  PromClientLike,
+  PrometheusMetricsOptions,
+  ProxyMetrics,
+  RequestMetric,
+} from "./metrics";
+export { consoleLogger, DEFAULT_REDACTED_HEADERS } from "./logger";
+export type { LogFields, LogLevel, ProxyLogger } from "./logger";
+export {
+  fixtureKey,
+  recordClient,
+  RecordClient,
+  replayClient,
+  ReplayClient,
+} from "./record-replay";
+export type {
+  Fixture,
+  MatchMode,
+  RecordClientOptions,
+  ReplayClientOptions,
+  ReplayReport,
+  UnmatchedCall,
+} from "./record-replay";
+export { createMockGateway, MockGateway } from "./mock-gateway";
+export type {
+  MockGatewayOptions,
+  MockHandler,
+  MockRequest,
+  MockResponse,
+} from "./mock-gateway";
+export type { ValidationOptions } from "./schema-validator";
+export type { SchemaViolation } from "./openapi-schema";
+export {
+  collectRoutes,
+  diffRoutes,
+  formatDriftReport,
+  verifyGateway,
+} from "./contract-drift";
+export type {
+  DriftReport,
+  DriftSource,
+  ParameterSignature,
+  RouteChange,
+  RouteDrift,
+  RouteSignature,
+  VerifyOptions,
+} from "./contract-drift";
@@ -0,0 +1,606 @@
+import { EventEmitter } from "node:events";
+import { CallResult } from "./grpc-utils";
+import type { HttpTransport } from "./http-transport";
+import { Logging } from "./logger";
+import { errorResult, ProxyError, settleCall } from "./proxy-error";
+import { InterceptingCallInterface } from "@grpc/grpc-js/build/src/client-interceptors";
+import {
+  InterceptingListener,
+  MessageContext,
+} from "@grpc/grpc-js/build/src/call-stream";
+import {
+  BackoffOptions,
+  Transport,
+  TransportHealth,
+} from "./transport-health";
+import {
+  CallCredentials,
+  InterceptingCall,
+  Interceptor,
+  InterceptorOptions,
+  Metadata,
+  NextCall,
+  status,
+  StatusObject,
+} from "@grpc/grpc-js";
+
+// 通过 http 完成一次调用，保证即使是内部错误，也会返回一个正确的结构
+// signal 用于取消请求（如对冲请求中失败的一方）
+export type ProxyHandler = (
+  message: any,
+  metadata: Metadata,
+  signal?: AbortSignal
+) => Promise<CallResult<any>>;
+
+// 直连和 grpc-gateway 之间自动切换的配置
+export interface FallbackOptions {
+  // 优先使用的传输方式，默认为 direct
+  prefer?: Transport;
+  // 传输方式不可用后的退避配置
+  backoff?: BackoffOptions;
+}
+
+// 拦截器对外暴露的事件接口
+export interface ProxyEvents {
+  on(event: string, listener: (...args: any[]) => void): void;
+  once(event: string, listener: (...args: any[]) => void): void;
+  off(event: string, listener: (...args: any[]) => void): void;
+  // openapi 文件加载完成时 resolve，加载失败时 reject
+  ready(): Promise<void>;
+  // 停止健康检查和 resolver，关闭 http 客户端的连接，之后不应该再发起调用
+  close(): Promise<void>;
+}
+
+export type ProxyInterceptor = Interceptor & ProxyEvents;
+
+// 自动切换时单次调用需要的上下文
+export interface HybridContext {
+  health: TransportHealth;
+  // 健康状态按 target 记录，target 是调用的 host 或者 grpc-gateway 地址
+  target: string;
+  // 只有幂等的方法才允许在切换传输方式时重发
+  idempotent: boolean;
+}
+
+/**
+ * 给拦截器挂载事件监听方法
+ * @param interceptor {Interceptor}
+ * @param emitter {EventEmitter}
+ * @param transport {HttpTransport} - 拦截器关闭时一起关闭
+ * @param [ready] {() => Promise<void>} - 不需要加载文件时总是 resolve
+ * @return {ProxyInterceptor}
+ */
+export function withEvents(
+  interceptor: Interceptor,
+  emitter: EventEmitter,
+  transport: HttpTransport,
+  ready: () => Promise<void> = () => Promise.resolve()
+): ProxyInterceptor {
+  return Object.assign(interceptor, {
+    ready,
+    close: () => transport.close(),
+    on: (event: string, listener: (...args: any[]) => void) => {
+      emitter.on(event, listener);
+    },
+    once: (event: string, listener: (...args: any[]) => void) => {
+      emitter.once(event, listener);
+    },
+    off: (event: string, listener: (...args: any[]) => void) => {
+      emitter.off(event, listener);
+    },
+  });
+}
+
+/**
+ * 根据配置创建健康状态记录，未开启自动切换时返回 undefined
+ * 切换事件会转发到 emitter 的 switch 事件上
+ * @param fallback {FallbackOptions | undefined}
+ * @param emitter {EventEmitter}
+ * @return {TransportHealth | undefined}
+ */
+export function createTransportHealth(
+  fallback: FallbackOptions | undefined,
+  emitter: EventEmitter
+): TransportHealth | undefined {
+  if (!fallback) return undefined;
+  const health = new TransportHealth(
+    fallback.prefer || "direct",
+    fallback.backoff
+  );
+  health.on("switch", (event) => emitter.emit("switch", event));
+  return health;
+}
+
+// 和 grpc-js 相同的顺序：metadata、message、status，失败的调用不会收到响应消息
+function deliverResult(
+  listener: Partial<InterceptingListener>,
+  result: CallResult<any>
+) {
+  listener.onReceiveMetadata?.(result.metadata);
+  if (result.status.code === status.OK) {
+    listener.onReceiveMessage?.(result.response);
+  }
+  listener.onReceiveStatus?.(result.status);
+}
+
+// 没有收到 http 响应时才认为 grpc-gateway 不可用，和直连时要求没有收到 metadata 一样
+// grpc-gateway 返回的 503 或者后端的 UNAVAILABLE 说明 grpc-gateway 本身是可用的
+function isTransportFailure(result: CallResult<any>): boolean {
+  return result.status.code === status.UNAVAILABLE && !result.http;
+}
+
+// 代替 channel 创建的调用，收到 halfClose 后通过 http 完成调用，不会连接 gRPC 端口
+class ProxyTerminalCall implements InterceptingCallInterface {
+  private message: unknown = null;
+  private metadata = new Metadata();
+  private listener: Partial<InterceptingListener> = {};
+  private finished = false;
+  private timer?: NodeJS.Timeout;
+  // 取消和超时时中止 http 请求，包括重试和对冲发送的请求
+  private readonly controller = new AbortController();
+
+  constructor(
+    private handler: ProxyHandler,
+    private deadline: InterceptorOptions["deadline"]
+  ) {}
+
+  public cancelWithStatus(code: status, details: string): void {
+    this.finish({ code, details, metadata: new Metadata() });
+    this.controller.abort();
+  }
+
+  public getPeer(): string {
+    return "";
+  }
+
+  public start(
+    metadata: Metadata,
+    listener?: Partial<InterceptingListener>
+  ): void {
+    this.metadata = metadata;
+    this.listener = listener || {};
+    const deadline = new Date(this.deadline ?? Infinity).getTime();
+    const timeout = deadline - Date.now();
+    if (Number.isFinite(timeout)) {
+      this.timer = setTimeout(() => {
+        this.cancelWithStatus(status.DEADLINE_EXCEEDED, "Deadline exceeded");
+      }, Math.max(timeout, 0));
+    }
+  }
+
+  public sendMessageWithContext(context: MessageContext, message: any): void {
+    this.message = message;
+    context.callback?.();
+  }
+
+  public sendMessage(message: any): void {
+    this.message = message;
+  }
+
+  public startRead(): void {}
+
+  public halfClose(): void {
+    // 这里的 message 是还没有被 protobuf 序列化的。
+    // 注意此刻的 metadata 的 key 会被全部转换为小写，但是通过 get 方法取值时，是大小写不敏感的。
+    // 此刻的 value 类型是 [MedataValue]
+    // handler 抛出的错误也会转换成 grpc 状态，调用总能结束
+    const { message, metadata, controller } = this;
+    const call = () => this.handler(message, metadata, controller.signal);
+    settleCall(call).then((result) => {
+      if (this.finished) return;
+      this.listener.onReceiveMetadata?.(result.metadata);
+      if (result.status.code === status.OK) {
+        this.listener.onReceiveMessage?.(result.response);
+      }
+      this.finish(result.status);
+    });
+  }
+
+  public setCredentials(credentials: CallCredentials): void {}
+
+  private finish(st: StatusObject) {
+    if (this.finished) return;
+    this.finished = true;
+    if (this.timer) clearTimeout(this.timer);
+    this.listener.onReceiveStatus?.(st);
+  }
+}
+
+/**
+ * 完全通过 http 完成调用，不会调用 nextCall，也就不会连接 gRPC 端口
+ * interceptors 是在接管调用之前需要经过的拦截器，会按顺序执行
+ * @param options {InterceptorOptions}
+ * @param handler {ProxyHandler}
+ * @param [interceptors] {Interceptor[]}
+ * @return {InterceptingCall}
+ */
+export function proxyCall(
+  options: InterceptorOptions,
+  handler: ProxyHandler,
+  interceptors: Interceptor[] = []
+): InterceptingCall {
+  const getNextCall =
+    (index: number): NextCall =>
+    (opts) => {
+      if (index < interceptors.length) {
+        return interceptors[index](opts, getNextCall(index + 1));
+      }
+      const terminal = new ProxyTerminalCall(handler, opts.deadline);
+      return new InterceptingCall(terminal);
+    };
+  return getNextCall(0)(options) as InterceptingCall;
+}
+
+// 转发所有的方法，取消时先通知 onCancel
+class CancelForwardingCall implements InterceptingCallInterface {
+  constructor(
+    private call: InterceptingCallInterface,
+    private onCancel: (code: status, details: string) => void
+  ) {}
+
+  public cancelWithStatus(code: status, details: string): void {
+    this.onCancel(code, details);
+    this.call.cancelWithStatus(code, details);
+  }
+
+  public getPeer(): string {
+    return this.call.getPeer();
+  }
+
+  public start(
+    metadata: Metadata,
+    listener?: Partial<InterceptingListener>
+  ): void {
+    this.call.start(metadata, listener);
+  }
+
+  public sendMessageWithContext(context: MessageContext, message: any): void {
+    this.call.sendMessageWithContext(context, message);
+  }
+
+  public sendMessage(message: any): void {
+    this.call.sendMessage(message);
+  }
+
+  public startRead(): void {
+    this.call.startRead();
+  }
+
+  public halfClose(): void {
+    this.call.halfClose();
+  }
+
+  public setCredentials(credentials: CallCredentials): void {
+    this.call.setCredentials(credentials);
+  }
+}
+
+// grpc-js 把排在后面的拦截器包装成 (currentOptions) => nextInterceptor(currentOptions, nextCall)，
+// 最后一个拦截器收到的 nextCall 直接创建 channel 的调用
+const DOWNSTREAM_INTERCEPTOR = /\bnextInterceptor\s*\(/;
+
+/**
+ * client 的 interceptors 中是否有排在代理拦截器之后的拦截器
+ * 接管调用后这些拦截器不会被执行，需要通过 opts.interceptors 传入或者使用 Channel
+ * @param nextCall {NextCall}
+ * @return {boolean}
+ */
+export function hasDownstreamInterceptor(nextCall: NextCall): boolean {
+  const source = Function.prototype.toString.call(nextCall);
+  return DOWNSTREAM_INTERCEPTOR.test(source);
+}
+
+/**
+ * 代理拦截器之后还有拦截器时返回 FAILED_PRECONDITION，而不是跳过这些拦截器
+ * @param options {InterceptorOptions}
+ * @param logging {Logging | null}
+ * @return {InterceptingCall}
+ */
+export function downstreamInterceptorCall(
+  options: InterceptorOptions,
+  logging: Logging | null
+): InterceptingCall {
+  const callPath = options.method_definition.path;
+  const message =
+    `${callPath}: interceptors after the proxy interceptor would be ` +
+    "skipped, pass them with opts.interceptors or use a proxy channel";
+  logging?.log("error", message, { callPath });
+  return proxyCall(options, async () =>
+    errorResult(new ProxyError(status.FAILED_PRECONDITION, message))
+  );
+}
+
+// 通过 proxyCall 完成的一次调用，结果中的 metadata、响应消息和状态来自 listener
+interface StartedProxyCall {
+  call: InterceptingCall;
+  done: Promise<CallResult<any>>;
+}
+
+/**
+ * 和只使用 grpc-gateway 时一样通过 proxyCall 发送，interceptors 会被执行
+ * @param options {InterceptorOptions}
+ * @param handler {ProxyHandler}
+ * @param interceptors {Interceptor[]}
+ * @param message {unknown}
+ * @param metadata {Metadata}
+ * @return {StartedProxyCall}
+ */
+function startProxyCall(
+  options: InterceptorOptions,
+  handler: ProxyHandler,
+  interceptors: Interceptor[],
+  message: unknown,
+  metadata: Metadata
+): StartedProxyCall {
+  // interceptors 只能看到 grpc 的状态，http 响应从 handler 的结果中取得
+  let raw: CallResult<any> | null = null;
+  const call = proxyCall(
+    options,
+    (message, metadata, signal) =>
+      handler(message, metadata, signal).then((result) => (raw = result)),
+    interceptors
+  );
+  const done = new Promise<CallResult<any>>((resolve) => {
+    let received = new Metadata();
+    let response: unknown = null;
+    call.start(metadata, {
+      onReceiveMetadata: (value: Metadata) => {
+        received = value;
+      },
+      onReceiveMessage: (value: unknown) => {
+        response = value;
+      },
+      onReceiveStatus: (st: StatusObject) => {
+        resolve({
+          response,
+          metadata: received,
+          status: st,
+          http: raw?.http,
+          error: raw?.error,
+        });
+      },
+    });
+  });
+  call.sendMessage(message);
+  call.halfClose();
+  return { call, done };
+}
+
+/**
+ * 在直连和 grpc-gateway 之间自动切换
+ *  1. 优先使用当前健康的传输方式，失败后记录到 health 中，后续的调用会切换到另一种方式
+ *  2. 只有幂等的方法才会在本次调用中使用另一种方式重发，否则直接返回失败的状态
+ *  3. 通过 grpc-gateway 发送时和 proxyCall 一样会先经过 interceptors，取消调用时会中止 http 请求
+ * @param options {InterceptorOptions}
+ * @param nextCall {NextCall}
+ * @param handler {ProxyHandler}
+ * @param ctx {HybridContext}
+ * @param [interceptors] {Interceptor[]}
+ * @return {InterceptingCall}
+ */
+export function hybridCall(
+  options: InterceptorOptions,
+  nextCall: NextCall,
+  handler: ProxyHandler,
+  ctx: HybridContext,
+  interceptors: Interceptor[] = []
+): InterceptingCall {
+  // 调用被取消时同时取消通过 grpc-gateway 发送的调用，中止 http 请求
+  let proxied: InterceptingCall | null = null;
+  const sendProxy = (message: unknown, metadata: Metadata) => {
+    const started = startProxyCall(
+      options,
+      handler,
+      interceptors,
+      message,
+      metadata
+    );
+    proxied = started.call;
+    return started.done;
+  };
+  const cancelProxy = (code: status, details: string) =>
+    proxied?.cancelWithStatus(code, details);
+  if (ctx.health.current(ctx.target) === "direct") {
+    const next: NextCall = (opts) =>
+      new CancelForwardingCall(nextCall(opts), cancelProxy);
+    return directFirstCall(options, next, sendProxy, ctx);
+  }
+  const call = new ProxyFirstCall(
+    options,
+    nextCall,
+    sendProxy,
+    cancelProxy,
+    ctx
+  );
+  return new InterceptingCall(call);
+}
+
+type SendProxy = (
+  message: unknown,
+  metadata: Metadata
+) => Promise<CallResult<any>>;
+// 监听直连调用的结果并记录健康状态，连接失败时交给 onFailure 处理
+function watchDirectCall(
+  ctx: HybridContext,
+  onFailure: (st: StatusObject, next: (st: StatusObject) => void) => void
+) {
+  let receivedMetadata = false;
+  return {
+    onReceiveMetadata: function (
+      metadata: Metadata,
+      next: (metadata: Metadata) => void
+    ) {
+      receivedMetadata = true;
+      next(metadata);
+    },
+    onReceiveStatus: function (
+      st: StatusObject,
+      next: (st: StatusObject) => void
+    ) {
+      // 没有收到服务端的 metadata 说明连接没有建立成功
+      if (st.code !== status.UNAVAILABLE || receivedMetadata) {
+        ctx.health.reportSuccess(ctx.target, "direct");
+        next(st);
+        return;
+      }
+      ctx.health.reportFailure(ctx.target, "direct");
+      onFailure(st, next);
+    },
+  };
+}
+
+function directFirstCall(
+  options: InterceptorOptions,
+  nextCall: NextCall,
+  sendProxy: SendProxy,
+  ctx: HybridContext
+): InterceptingCall {
+  const { health, target, idempotent } = ctx;
+  const ref = {
+    message: null as unknown,
+    metadata: new Metadata(),
+    halfClosed: false,
+  };
+  return new InterceptingCall(nextCall(options), {
+    start: function (metadata, listener, next) {
+      ref.metadata = metadata;
+      const watcher = watchDirectCall(ctx, (st, nextStatus) => {
+        // 非幂等的方法无法确认请求是否已经到达服务端，不能重发
+        if (!idempotent || !ref.halfClosed) {
+          nextStatus(st);
+          return;
+        }
+        sendProxy(ref.message, ref.metadata).then((result) => {
+          if (isTransportFailure(result)) {
+            health.reportFailure(target, "proxy");
+          } else {
+            health.reportSuccess(target, "proxy");
+          }
+          deliverResult(listener, result);
+        });
+      });
+      next(metadata, watcher);
+    },
+    sendMessage: function (message, next) {
+      ref.message = message;
+      next(message);
+    },
+    halfClose: function (next) {
+      ref.halfClosed = true;
+      next();
+    },
+  });
+}
+
+// 先通过 grpc-gateway 发送，只有 grpc-gateway 不可用并且方法幂等时才会创建直连的调用
+class ProxyFirstCall implements InterceptingCallInterface {
+  private metadata = new Metadata();
+  private message: unknown = null;
+  private listener: Partial<InterceptingListener> = {};
+  private credentials: CallCredentials | null = null;
+  private reading = false;
+  private direct: InterceptingCallInterface | null = null;
+  private finished = false;
+
+  constructor(
+    private options: InterceptorOptions,
+    private nextCall: NextCall,
+    private sendProxy: SendProxy,
+    private cancelProxy: (code: status, details: string) => void,
+    private ctx: HybridContext
+  ) {}
+
+  public cancelWithStatus(code: status, details: string): void {
+    if (this.direct) {
+      this.direct.cancelWithStatus(code, details);
+      return;
+    }
+    // 中止正在发送的 http 请求，之后 grpc-gateway 返回的结果会被忽略
+    this.cancelProxy(code, details);
+    if (!this.finished) {
+      this.finished = true;
+      this.listener.onReceiveStatus?.({
+        code,
+        details,
+        metadata: new Metadata(),
+      });
+    }
+  }
+
+  public getPeer(): string {
+    return this.direct?.getPeer() ?? "";
+  }
+
+  public start(
+    metadata: Metadata,
+    listener?: Partial<InterceptingListener>
+  ): void {
+    this.metadata = metadata;
+    this.listener = listener || {};
+  }
+
+  public sendMessageWithContext(context: MessageContext, message: any): void {
+    this.message = message;
+    context.callback?.();
+  }
+
+  public sendMessage(message: any): void {
+    this.message = message;
+  }
+
+  public startRead(): void {
+    this.reading = true;
+    this.direct?.startRead();
+  }
+
+  public async halfClose(): Promise<void> {
+    const { health, target, idempotent } = this.ctx;
+    const result = await this.sendProxy(this.message, this.metadata);
+    if (this.finished) return;
+    if (!isTransportFailure(result)) {
+      health.reportSuccess(target, "proxy");
+      this.deliver(result);
+      return;
+    }
+    health.reportFailure(target, "proxy");
+    // 非幂等的方法无法确认请求是否已经到达服务端，不能重发
+    if (!idempotent) {
+      this.deliver(result);
+      return;
+    }
+    this.startDirect();
+  }
+
+  public setCredentials(credentials: CallCredentials): void {
+    this.credentials = credentials;
+  }
+
+  private deliver(result: CallResult<any>) {
+    this.finished = true;
+    deliverResult(this.listener, result);
+  }
+
+  private startDirect() {
+    const { listener } = this;
+    const direct = this.nextCall(this.options);
+    this.direct = direct;
+    if (this.credentials) direct.setCredentials(this.credentials);
+    const watcher = watchDirectCall(this.ctx, (st, next) => next(st));
+    direct.start(this.metadata, {
+      onReceiveMetadata: (metadata: Metadata) =>
+        watcher.onReceiveMetadata(metadata, (value) =>
+          listener.onReceiveMetadata?.(value)
+        ),
+      onReceiveMessage: (message: any) => listener.onReceiveMessage?.(message),
+      onReceiveStatus: (st: StatusObject) => {
+        this.finished = true;
+        watcher.onReceiveStatus(st, (value) =>
+          listener.onReceiveStatus?.(value)
+        );
+      },
+    });
+    direct.sendMessage(this.message);
+    direct.halfClose();
+    if (this.reading) direct.startRead();
+  }
+}
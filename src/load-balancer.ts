@@ -0,0 +1,343 @@
+import axios, { AxiosInstance } from "axios";
+import { isValidUrl } from "./helper";
+import { Metadata } from "@grpc/grpc-js";
+import { CallResult } from "./grpc-utils";
+import { errorResult, TransportError } from "./proxy-error";
+import { ProxyRequest, TransportMiddleware } from "./http-transport";
+import { GatewayResolver, isGatewayResolver } from "./gateway-resolver";
+
+// grpc-gateway 地址，weight 只在 weighted 策略下生效，默认 1
+export interface GatewayEndpoint {
+  url: string;
+  weight?: number;
+}
+
+export type GatewayList = Array<string | GatewayEndpoint>;
+
+// 负载均衡策略，consistent_hash 按 metadata 中指定 key 的值选择 grpc-gateway
+export type BalancingPolicy =
+  | "round_robin"
+  | "least_outstanding"
+  | "weighted"
+  | { consistent_hash: string };
+
+export interface HealthCheckOptions {
+  // 探测间隔，单位毫秒，默认 5000
+  interval?: number;
+  // 探测超时时间，单位毫秒，默认 1000
+  timeout?: number;
+  // 探测地址，默认为 grpc-gateway WithHealthzEndpoint 暴露的 /healthz
+  path?: string;
+  // grpc.health.v1.Health/Check 中的 service，会作为 ?service= 传给 /healthz
+  service?: string;
+  // 连续失败多少次后摘除，默认 1
+  unhealthyThreshold?: number;
+  // 摘除后连续成功多少次恢复，默认 1
+  healthyThreshold?: number;
+}
+
+export interface LoadBalancingOptions {
+  // 默认 round_robin
+  policy?: BalancingPolicy;
+  // 主动健康检查，不配置时不检查
+  healthCheck?: HealthCheckOptions;
+}
+
+// grpc-gateway 被摘除或者恢复时触发的 endpoint 事件的参数
+export interface EndpointHealthEvent {
+  url: string;
+  healthy: boolean;
+}
+
+interface Endpoint {
+  url: string;
+  weight: number;
+  healthy: boolean;
+  // 正在进行的请求数
+  outstanding: number;
+  // 连续探测失败/成功的次数
+  failures: number;
+  successes: number;
+  // 平滑加权轮询的当前权重
+  currentWeight: number;
+}
+
+const defaultHealthCheck: Required<Omit<HealthCheckOptions, "service">> = {
+  interval: 5000,
+  timeout: 1000,
+  path: "/healthz",
+  unhealthyThreshold: 1,
+  healthyThreshold: 1,
+};
+
+type ResolvedHealthCheck = typeof defaultHealthCheck &
+  Pick<HealthCheckOptions, "service">;
+
+// 一致性哈希中每个 grpc-gateway 的虚拟节点数
+const VIRTUAL_NODES = 100;
+
+/**
+ * 32 位 FNV-1a 哈希
+ * @param value {string}
+ * @return {number}
+ */
+function hash(value: string): number {
+  let result = 0x811c9dc5;
+  for (let i = 0; i < value.length; i++) {
+    result ^= value.charCodeAt(i);
+    result = Math.imul(result, 0x01000193) >>> 0;
+  }
+  return result;
+}
+
+/**
+ * 校验 grpc-gateway 地址列表
+ * @param list {GatewayList}
+ * @return {boolean}
+ */
+export function isValidGatewayList(list: GatewayList): boolean {
+  return (
+    list.length > 0 &&
+    list.every((item) => isValidUrl(typeof item === "string" ? item : item.url))
+  );
+}
+
+/**
+ * 在多个 grpc-gateway 之间分配请求，作为 HttpTransport 的 middleware 改写请求的 baseUrl
+ *  1. 配置 healthCheck 时会定期探测每个 grpc-gateway，探测失败的会被摘除，恢复后重新加入
+ *  2. 所有 grpc-gateway 都被摘除时直接返回 UNAVAILABLE
+ *  3. 地址来自 GatewayResolver 时，每次请求前获取最新的地址列表，已有地址的状态会被保留
+ */
+export class LoadBalancer {
+  private endpoints: Endpoint[] = [];
+  private readonly policy: BalancingPolicy;
+  private ring: Array<{ point: number; endpoint: Endpoint }> = [];
+  private cursor = 0;
+  private timer?: NodeJS.Timeout;
+  private resolver?: GatewayResolver;
+  // resolver 上一次返回的列表，没有变化时不需要更新
+  private resolved?: GatewayList;
+
+  constructor(
+    source: GatewayList | GatewayResolver,
+    opts: LoadBalancingOptions,
+    private onHealthChange: (event: EndpointHealthEvent) => void,
+    // 健康检查使用的 http 客户端，需要和调用使用相同的 TLS 和代理配置
+    private client: AxiosInstance = axios
+  ) {
+    this.policy = opts.policy || "round_robin";
+    if (isGatewayResolver(source)) {
+      this.resolver = source;
+    } else {
+      this.setEndpoints(source);
+    }
+    if (opts.healthCheck) {
+      this.startHealthCheck({ ...defaultHealthCheck, ...opts.healthCheck });
+    }
+  }
+
+  /**
+   * 更新地址列表，已有地址的健康状态和正在进行的请求数会被保留
+   * @param list {GatewayList}
+   */
+  public setEndpoints(list: GatewayList) {
+    const current = new Map(this.endpoints.map((item) => [item.url, item]));
+    this.endpoints = list.map((item) => {
+      const { url, weight = 1 } =
+        typeof item === "string" ? { url: item } : item;
+      const endpoint = current.get(url);
+      if (endpoint) return Object.assign(endpoint, { weight });
+      return {
+        url,
+        weight,
+        healthy: true,
+        outstanding: 0,
+        failures: 0,
+        successes: 0,
+        currentWeight: 0,
+      };
+    });
+    if (typeof this.policy === "object") {
+      this.buildRing();
+    }
+  }
+
+  // 哈希环包含所有的 grpc-gateway，摘除时顺延到下一个，这样其他 key 的映射不会变化
+  private buildRing() {
+    this.ring = [];
+    for (const endpoint of this.endpoints) {
+      for (let i = 0; i < VIRTUAL_NODES; i++) {
+        this.ring.push({ point: hash(`${endpoint.url}#${i}`), endpoint });
+      }
+    }
+    this.ring.sort((a, b) => a.point - b.point);
+  }
+
+  private startHealthCheck(opts: ResolvedHealthCheck) {
+    const check = () => {
+      this.endpoints.forEach((endpoint) => this.probe(endpoint, opts));
+    };
+    check();
+    this.timer = setInterval(check, opts.interval);
+    // 健康检查不应该阻止进程退出
+    this.timer.unref();
+  }
+
+  private async probe(endpoint: Endpoint, opts: ResolvedHealthCheck) {
+    let ok: boolean;
+    try {
+      const result = await this.client.get(`${endpoint.url}${opts.path}`, {
+        timeout: opts.timeout,
+        params: opts.service ? { service: opts.service } : undefined,
+      });
+      ok = result.status === 200;
+    } catch (err) {
+      ok = false;
+    }
+    if (ok) {
+      endpoint.failures = 0;
+      endpoint.successes += 1;
+      if (!endpoint.healthy && endpoint.successes >= opts.healthyThreshold) {
+        this.setHealthy(endpoint, true);
+      }
+    } else {
+      endpoint.successes = 0;
+      endpoint.failures += 1;
+      if (endpoint.healthy && endpoint.failures >= opts.unhealthyThreshold) {
+        this.setHealthy(endpoint, false);
+      }
+    }
+  }
+
+  private setHealthy(endpoint: Endpoint, healthy: boolean) {
+    endpoint.healthy = healthy;
+    endpoint.currentWeight = 0;
+    this.onHealthChange({ url: endpoint.url, healthy });
+  }
+
+  /**
+   * 获取当前未被摘除的 grpc-gateway 地址
+   * @return {string[]}
+   */
+  public getHealthy(): string[] {
+    return this.endpoints.filter((item) => item.healthy).map((item) => item.url);
+  }
+
+  private roundRobin(healthy: Endpoint[]): Endpoint {
+    const endpoint = healthy[this.cursor % healthy.length];
+    this.cursor = (this.cursor + 1) % healthy.length;
+    return endpoint;
+  }
+
+  // 正在进行的请求数相同时按顺序轮询
+  private leastOutstanding(healthy: Endpoint[]): Endpoint {
+    const start = this.cursor % healthy.length;
+    this.cursor = (this.cursor + 1) % healthy.length;
+    let result = healthy[start];
+    for (let i = 1; i < healthy.length; i++) {
+      const endpoint = healthy[(start + i) % healthy.length];
+      if (endpoint.outstanding < result.outstanding) result = endpoint;
+    }
+    return result;
+  }
+
+  // nginx 的平滑加权轮询
+  private weighted(healthy: Endpoint[]): Endpoint {
+    let total = 0;
+    let result = healthy[0];
+    for (const endpoint of healthy) {
+      endpoint.currentWeight += endpoint.weight;
+      total += endpoint.weight;
+      if (endpoint.currentWeight > result.currentWeight) result = endpoint;
+    }
+    result.currentWeight -= total;
+    return result;
+  }
+
+  private consistentHash(healthy: Endpoint[], key: string): Endpoint {
+    const point = hash(key);
+    let index = this.ring.findIndex((item) => item.point >= point);
+    if (index === -1) index = 0;
+    for (let i = 0; i < this.ring.length; i++) {
+      const { endpoint } = this.ring[(index + i) % this.ring.length];
+      if (endpoint.healthy) return endpoint;
+    }
+    return healthy[0];
+  }
+
+  /**
+   * 为请求选择一个 grpc-gateway，全部被摘除时返回 null
+   * @param metadata {Metadata} - consistent_hash 策略从中读取 key
+   * @return {string | null}
+   */
+  public pick(metadata?: Metadata): string | null {
+    return this.pickEndpoint(metadata)?.url ?? null;
+  }
+
+  private pickEndpoint(metadata?: Metadata): Endpoint | null {
+    const healthy = this.endpoints.filter((item) => item.healthy);
+    if (healthy.length === 0) return null;
+    const { policy } = this;
+    if (typeof policy === "object") {
+      // metadata 中没有对应的 key 时退化为轮询
+      const [key] = metadata?.get(policy.consistent_hash) || [];
+      if (key === undefined) return this.roundRobin(healthy);
+      return this.consistentHash(healthy, key.toString());
+    }
+    switch (policy) {
+      case "least_outstanding":
+        return this.leastOutstanding(healthy);
+      case "weighted":
+        return this.weighted(healthy);
+      default:
+        return this.roundRobin(healthy);
+    }
+  }
+
+  private reject(request: ProxyRequest, reason: string): CallResult<any> {
+    return errorResult(
+      new TransportError(`${reason}, ${request.callPath} was not sent`)
+    );
+  }
+
+  private noEndpoint(request: ProxyRequest): CallResult<any> {
+    if (this.endpoints.length === 0) {
+      return this.reject(request, "no grpc-gateway endpoint");
+    }
+    const urls = this.endpoints.map((item) => item.url).join(", ");
+    return this.reject(request, `no healthy grpc-gateway among ${urls}`);
+  }
+
+  public middleware(): TransportMiddleware {
+    return async (request, next) => {
+      if (this.resolver) {
+        try {
+          const list = await this.resolver.resolve();
+          if (list !== this.resolved) {
+            this.resolved = list;
+            this.setEndpoints(list);
+          }
+        } catch (err) {
+          return this.reject(
+            request,
+            `failed to resolve grpc-gateway: ${(err as Error).message}`
+          );
+        }
+      }
+      const endpoint = this.pickEndpoint(request.metadata);
+      if (!endpoint) return this.noEndpoint(request);
+      endpoint.outstanding += 1;
+      try {
+        return await next({ ...request, baseUrl: endpoint.url });
+      } finally {
+        endpoint.outstanding -= 1;
+      }
+    };
+  }
+
+  // 停止健康检查和 resolver
+  public close() {
+    clearInterval(this.timer);
+    this.resolver?.close?.();
+  }
+}
@@ -0,0 +1,214 @@
+import { status } from "@grpc/grpc-js";
+import { settleCall } from "./proxy-error";
+import { ProxyHandler } from "./interceptor-call";
+import { TransportMiddleware } from "./http-transport";
+import { ProxyTransport } from "./tracing";
+import { toHttpRequest } from "./http-client";
+import { HeaderMatcher, METADATA_HEADER_PREFIX } from "./header-matcher";
+
+export type LogLevel = "debug" | "info" | "warn" | "error";
+
+// 日志的结构化字段
+export interface LogFields {
+  callPath?: string;
+  transport?: ProxyTransport;
+  // grpc-gateway 的地址，请求的 dump 中是完整的地址
+  url?: string;
+  // http 状态码，没有收到响应时为 null
+  status?: number | null;
+  // grpc 状态的名称，如 UNAVAILABLE
+  grpcCode?: string;
+  // 单位毫秒
+  duration?: number;
+  // ProxyError，cause 中是原始的错误
+  error?: unknown;
+  [field: string]: unknown;
+}
+
+// 日志的接收方，没有实现的级别不会输出，debug 级别会输出每一次 http 请求和响应的内容
+export interface ProxyLogger {
+  debug?(message: string, fields: LogFields): void;
+  info?(message: string, fields: LogFields): void;
+  warn?(message: string, fields: LogFields): void;
+  error?(message: string, fields: LogFields): void;
+}
+
+// 日志中默认隐藏值的头，比较时忽略大小写
+export const DEFAULT_REDACTED_HEADERS = [
+  "authorization",
+  "proxy-authorization",
+  "cookie",
+  "set-cookie",
+];
+
+const REDACTED = "[REDACTED]";
+
+const LEVELS: LogLevel[] = ["debug", "info", "warn", "error"];
+
+/**
+ * 输出到 console 的日志，低于 level 的日志不会输出
+ * @param [level] {LogLevel} - 默认 warn
+ * @return {ProxyLogger}
+ */
+export function consoleLogger(level: LogLevel = "warn"): ProxyLogger {
+  const logger: ProxyLogger = {};
+  for (const name of LEVELS.slice(LEVELS.indexOf(level))) {
+    logger[name] = (message, fields) =>
+      console[name](`grpc-proxy-interceptor: ${message}`, fields);
+  }
+  return logger;
+}
+
+const METADATA_PREFIX = METADATA_HEADER_PREFIX.toLowerCase();
+
+/**
+ * 需要隐藏的头的小写名称
+ * metadata 会通过 headers.request 的 matcher 转换成其他的头，转换后的名称也需要隐藏
+ * @param redact {string[]}
+ * @param [matcher] {HeaderMatcher}
+ * @return {Set<string>}
+ */
+function redactedNames(redact: string[], matcher?: HeaderMatcher): Set<string> {
+  const names = new Set(redact.map((name) => name.toLowerCase()));
+  if (!matcher) return names;
+  for (const name of [...names]) {
+    try {
+      const [header, ok] = matcher(name);
+      if (ok && header) names.add(header.toLowerCase());
+    } catch (err) {
+      // ignore
+    }
+  }
+  return names;
+}
+
+/**
+ * 隐藏头中的敏感信息
+ * Grpc-Metadata- 前缀的头按去掉前缀后的名称比较，如 Grpc-Metadata-authorization
+ * @param headers {unknown} - 普通对象或者 AxiosHeaders
+ * @param redact {string[]} - 需要隐藏的头
+ * @param [matcher] {HeaderMatcher} - headers.request 的 matcher
+ * @return {Record<string, unknown>}
+ */
+export function redactHeaders(
+  headers: unknown,
+  redact: string[],
+  matcher?: HeaderMatcher
+): Record<string, unknown> {
+  if (!headers || typeof headers !== "object") return {};
+  const source =
+    typeof (headers as any).toJSON === "function"
+      ? (headers as any).toJSON()
+      : headers;
+  const names = redactedNames(redact, matcher);
+  const result: Record<string, unknown> = {};
+  for (const [name, value] of Object.entries(source)) {
+    const lower = name.toLowerCase();
+    const key = lower.startsWith(METADATA_PREFIX)
+      ? lower.slice(METADATA_PREFIX.length)
+      : lower;
+    result[name] = names.has(lower) || names.has(key) ? REDACTED : value;
+  }
+  return result;
+}
+
+function milliseconds(start: bigint): number {
+  return Number(process.hrtime.bigint() - start) / 1e6;
+}
+
+/**
+ * 按调用输出日志
+ *  - error：openapi 文件加载失败（没有监听 error 事件时）
+ *  - warn：代理内部的错误，如 grpc-gateway 无法连接、请求无法转换；不支持的流式调用
+ *  - info：grpc-gateway 返回的错误状态
+ *  - debug：成功的调用，每一次 http 请求和响应的内容
+ */
+export class Logging {
+  private readonly redact: string[];
+
+  constructor(
+    private logger: ProxyLogger,
+    private transport: ProxyTransport,
+    redact: string[] = DEFAULT_REDACTED_HEADERS,
+    // headers.request 的 matcher，metadata 转换后的头也会被隐藏
+    private matcher?: HeaderMatcher
+  ) {
+    this.redact = redact;
+  }
+
+  public log(level: LogLevel, message: string, fields: LogFields = {}) {
+    // 日志不能影响调用
+    try {
+      this.logger[level]?.(message, { transport: this.transport, ...fields });
+    } catch (err) {
+      // ignore
+    }
+  }
+
+  /**
+   * 调用结束时按结果输出日志
+   * @param handler {ProxyHandler}
+   * @param callPath {string}
+   * @return {ProxyHandler}
+   */
+  public wrap(handler: ProxyHandler, callPath: string): ProxyHandler {
+    return async (message, metadata, signal) => {
+      const start = process.hrtime.bigint();
+      const result = await settleCall(() => handler(message, metadata, signal));
+      const { code, details } = result.status;
+      const fields: LogFields = {
+        callPath,
+        url: result.http?.gateway ?? "",
+        status: result.http?.status ?? null,
+        grpcCode: status[code],
+        duration: milliseconds(start),
+      };
+      if (result.error) {
+        this.log("warn", `${callPath} failed: ${details}`, {
+          ...fields,
+          error: result.error,
+        });
+      } else if (code !== status.OK) {
+        this.log("info", `${callPath} returned ${status[code]}`, fields);
+      } else {
+        this.log("debug", `${callPath} succeeded`, fields);
+      }
+      return result;
+    };
+  }
+
+  // 输出最终发送的 http 请求和收到的响应，没有 debug 级别时返回 null
+  public middleware(): TransportMiddleware | null {
+    if (!this.logger.debug) return null;
+    return async (request, next) => {
+      const { callPath, config } = request;
+      // 和 http 客户端一样拼接地址，签名之后的 url 是完整的地址
+      const { url } = toHttpRequest(request);
+      this.log("debug", `http request ${callPath}`, {
+        callPath,
+        url,
+        method: String(config.method || "get").toUpperCase(),
+        headers: redactHeaders(config.headers, this.redact, this.matcher),
+        body: config.data,
+      });
+      const start = process.hrtime.bigint();
+      const result = await next(request);
+      this.log("debug", `http response ${callPath}`, {
+        callPath,
+        url,
+        status: result.http?.status ?? null,
+        grpcCode: status[result.status.code],
+        duration: milliseconds(start),
+        headers: redactHeaders(
+          result.http?.headers,
+          this.redact,
+          this.matcher
+        ),
+        // 记录原始的响应体，失败的调用没有响应消息
+        body: result.http ? result.http.data : result.response,
+        details: result.status.details,
+      });
+      return result;
+    };
+  }
+}
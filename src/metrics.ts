@@ -0,0 +1,401 @@
+import type { Attributes, Meter } from "@opentelemetry/api";
+import { status } from "@grpc/grpc-js";
+import { getServicePath } from "./grpc-utils";
+import { settleCall } from "./proxy-error";
+import { ProxyHandler } from "./interceptor-call";
+import { TransportMiddleware } from "./http-transport";
+import { ProxyTransport } from "./tracing";
+import { HedgeEvent } from "./hedging-policy";
+
+// 一次调用结束时的统计，包含重试和对冲的所有请求
+export interface CallMetric {
+  // callPath，如 /example.greeter.v1.services.Greeter/SayHello
+  method: string;
+  transport: ProxyTransport;
+  // 最后一次收到响应的 grpc-gateway 地址，没有收到响应时为空字符串
+  gateway: string;
+  // 最后一次收到的 http 状态码，没有收到响应时为 null
+  httpStatus: number | null;
+  grpcCode: status;
+  // 单位秒
+  duration: number;
+}
+
+// 一次 http 请求结束时的统计
+export interface RequestMetric {
+  method: string;
+  transport: ProxyTransport;
+  gateway: string;
+  httpStatus: number | null;
+  grpcCode: status;
+  duration: number;
+}
+
+// openapi 文件加载结束时的统计
+export interface LoadMetric {
+  // 单位秒
+  duration: number;
+  // 加载的 operation 数量，加载失败时为 0
+  routes: number;
+  error: Error | null;
+}
+
+// 发送过对冲请求的调用结束时的统计
+export interface HedgeMetric {
+  method: string;
+  transport: ProxyTransport;
+  // 实际发送的请求数
+  attempts: number;
+  // 被采用的请求的序号，0 是第一个请求，大于 0 说明对冲请求胜出
+  winner: number;
+}
+
+// 统计数据的接收方，可以使用 prometheusMetrics、openTelemetryMetrics 或者自己实现
+export interface ProxyMetrics {
+  recordCall(metric: CallMetric): void;
+  recordRequest?(metric: RequestMetric): void;
+  recordLoad?(metric: LoadMetric): void;
+  recordHedge?(metric: HedgeMetric): void;
+}
+
+function seconds(start: bigint): number {
+  return Number(process.hrtime.bigint() - start) / 1e9;
+}
+
+// 统计不能影响调用，接收方抛出的错误直接忽略
+function safely(record: () => void) {
+  try {
+    record();
+  } catch (err) {
+    // ignore
+  }
+}
+
+/**
+ * 统计调用和 http 请求
+ */
+export class Metrics {
+  constructor(
+    private sink: ProxyMetrics,
+    private transport: ProxyTransport
+  ) {}
+
+  /**
+   * 调用结束时记录，最后一次收到的响应决定 gateway 和 httpStatus
+   * @param handler {ProxyHandler}
+   * @param callPath {string}
+   * @return {ProxyHandler}
+   */
+  public wrap(handler: ProxyHandler, callPath: string): ProxyHandler {
+    return async (message, metadata, signal) => {
+      const start = process.hrtime.bigint();
+      const result = await settleCall(() => handler(message, metadata, signal));
+      safely(() =>
+        this.sink.recordCall({
+          method: callPath,
+          transport: this.transport,
+          gateway: result.http?.gateway ?? "",
+          httpStatus: result.http?.status ?? null,
+          grpcCode: result.status.code,
+          duration: seconds(start),
+        })
+      );
+      return result;
+    };
+  }
+
+  /**
+   * 发送过对冲请求的调用结束时记录
+   * @param event {HedgeEvent}
+   */
+  public recordHedge(event: HedgeEvent) {
+    const { sink } = this;
+    if (!sink.recordHedge) return;
+    safely(() =>
+      sink.recordHedge?.({
+        method: event.callPath,
+        transport: this.transport,
+        attempts: event.attempts,
+        winner: event.winner,
+      })
+    );
+  }
+
+  // 每一次 http 请求结束时记录，没有配置 recordRequest 时返回 null
+  public middleware(): TransportMiddleware | null {
+    const { sink } = this;
+    if (!sink.recordRequest) return null;
+    return async (request, next) => {
+      const start = process.hrtime.bigint();
+      const result = await next(request);
+      safely(() =>
+        sink.recordRequest?.({
+          method: request.callPath,
+          transport: this.transport,
+          gateway: request.baseUrl,
+          httpStatus: result.http?.status ?? null,
+          grpcCode: result.status.code,
+          duration: seconds(start),
+        })
+      );
+      return result;
+    };
+  }
+}
+
+/**
+ * 记录 openapi 文件的加载时间和 operation 数量
+ * @param load {() => void | Promise<void>}
+ * @param routes {() => number}
+ * @param [sink] {ProxyMetrics}
+ * @return {() => void | Promise<void>}
+ */
+export function withLoadMetrics(
+  load: () => void | Promise<void>,
+  routes: () => number,
+  sink?: ProxyMetrics
+): () => void | Promise<void> {
+  if (!sink?.recordLoad) return load;
+  const record = (start: bigint, error: Error | null) =>
+    safely(() =>
+      sink.recordLoad?.({
+        error,
+        duration: seconds(start),
+        routes: error ? 0 : routes(),
+      })
+    );
+  return () => {
+    const start = process.hrtime.bigint();
+    let loading: void | Promise<void>;
+    try {
+      loading = load();
+    } catch (err) {
+      record(start, err as Error);
+      throw err;
+    }
+    if (!loading) {
+      record(start, null);
+      return;
+    }
+    return loading.then(
+      () => record(start, null),
+      (err) => {
+        record(start, err);
+        throw err;
+      }
+    );
+  };
+}
+
+function httpStatusLabel(httpStatus: number | null): string {
+  return httpStatus === null ? "none" : String(httpStatus);
+}
+
+type Labels = Record<string, string>;
+
+// prom-client 中用到的部分，避免依赖 prom-client
+export interface PromClientLike {
+  Counter: new (config: PromMetricConfig) => {
+    inc(labels: Labels, value?: number): void;
+  };
+  Histogram: new (config: PromMetricConfig) => {
+    observe(labels: Labels, value: number): void;
+  };
+  Gauge: new (config: PromMetricConfig) => { set(value: number): void };
+}
+
+export interface PromMetricConfig {
+  name: string;
+  help: string;
+  labelNames?: string[];
+  buckets?: number[];
+  registers?: unknown[];
+}
+
+export interface PrometheusMetricsOptions {
+  // 指标名称的前缀，默认 grpc_proxy_
+  prefix?: string;
+  // 注册到的 Registry，默认是 prom-client 的全局 register
+  registry?: unknown;
+  // 耗时 histogram 的 buckets，单位秒
+  buckets?: number[];
+}
+
+/**
+ * 使用 prom-client 记录的指标，同名的指标只能注册一次，多个拦截器需要共用同一个返回值
+ *  - {prefix}calls_total、{prefix}call_duration_seconds：method、transport、gateway、http_status、grpc_code
+ *  - {prefix}http_requests_total、{prefix}http_request_duration_seconds：同上
+ *  - {prefix}openapi_load_duration_seconds、{prefix}openapi_routes
+ *  - {prefix}hedged_calls_total：method、transport、hedge_won；{prefix}hedge_attempts_total：method、transport
+ * @param client {PromClientLike} - import * as client from "prom-client"
+ * @param [opts] {PrometheusMetricsOptions}
+ * @return {ProxyMetrics}
+ */
+export function prometheusMetrics(
+  client: PromClientLike,
+  opts: PrometheusMetricsOptions = {}
+): ProxyMetrics {
+  const prefix = opts.prefix ?? "grpc_proxy_";
+  const labelNames = [
+    "method",
+    "transport",
+    "gateway",
+    "http_status",
+    "grpc_code",
+  ];
+  // prom-client 会用 undefined 覆盖默认值，没有配置的字段不能传
+  const config = (name: string, help: string): PromMetricConfig => ({
+    name: prefix + name,
+    help,
+    ...(opts.registry ? { registers: [opts.registry] } : {}),
+  });
+  const counter = (name: string, help: string) =>
+    new client.Counter({ ...config(name, help), labelNames });
+  const histogram = (name: string, help: string) =>
+    new client.Histogram({
+      ...config(name, help),
+      labelNames,
+      ...(opts.buckets ? { buckets: opts.buckets } : {}),
+    });
+  const calls = counter("calls_total", "Proxied gRPC calls");
+  const callDuration = histogram(
+    "call_duration_seconds",
+    "Duration of proxied gRPC calls including retries"
+  );
+  const requests = counter("http_requests_total", "HTTP requests to gateways");
+  const requestDuration = histogram(
+    "http_request_duration_seconds",
+    "Duration of HTTP requests to gateways"
+  );
+  const loadDuration = new client.Gauge(
+    config("openapi_load_duration_seconds", "Time spent loading openapi files")
+  );
+  const routes = new client.Gauge(
+    config("openapi_routes", "Number of operations loaded from openapi files")
+  );
+  const hedgedCalls = new client.Counter({
+    ...config("hedged_calls_total", "Proxied gRPC calls that sent hedges"),
+    labelNames: ["method", "transport", "hedge_won"],
+  });
+  const hedgeAttempts = new client.Counter({
+    ...config("hedge_attempts_total", "HTTP requests sent by hedged calls"),
+    labelNames: ["method", "transport"],
+  });
+  const toLabels = (metric: CallMetric | RequestMetric): Labels => ({
+    method: metric.method,
+    transport: metric.transport,
+    gateway: metric.gateway,
+    http_status: httpStatusLabel(metric.httpStatus),
+    grpc_code: status[metric.grpcCode],
+  });
+  return {
+    recordCall(metric) {
+      const labels = toLabels(metric);
+      calls.inc(labels);
+      callDuration.observe(labels, metric.duration);
+    },
+    recordRequest(metric) {
+      const labels = toLabels(metric);
+      requests.inc(labels);
+      requestDuration.observe(labels, metric.duration);
+    },
+    recordLoad(metric) {
+      loadDuration.set(metric.duration);
+      routes.set(metric.routes);
+    },
+    recordHedge(metric) {
+      const labels = { method: metric.method, transport: metric.transport };
+      hedgedCalls.inc({ ...labels, hedge_won: String(metric.winner > 0) });
+      hedgeAttempts.inc(labels, metric.attempts);
+    },
+  };
+}
+
+/**
+ * 使用 OpenTelemetry 的 Meter 记录的指标，属性和链路追踪的 span 相同
+ *  - grpc_proxy.calls、grpc_proxy.call.duration
+ *  - grpc_proxy.http.requests、grpc_proxy.http.request.duration
+ *  - grpc_proxy.openapi.load.duration、grpc_proxy.openapi.routes
+ *  - grpc_proxy.hedged_calls、grpc_proxy.hedge.attempts（计数器，累加每次调用发送的请求数）
+ * @param meter {Meter} - 如 metrics.getMeter("grpc-proxy-interceptor")
+ * @return {ProxyMetrics}
+ */
+export function openTelemetryMetrics(meter: Meter): ProxyMetrics {
+  const calls = meter.createCounter("grpc_proxy.calls", {
+    description: "Proxied gRPC calls",
+  });
+  const callDuration = meter.createHistogram("grpc_proxy.call.duration", {
+    unit: "s",
+    description: "Duration of proxied gRPC calls including retries",
+  });
+  const requests = meter.createCounter("grpc_proxy.http.requests", {
+    description: "HTTP requests to gateways",
+  });
+  const requestDuration = meter.createHistogram(
+    "grpc_proxy.http.request.duration",
+    { unit: "s", description: "Duration of HTTP requests to gateways" }
+  );
+  const hedgedCalls = meter.createCounter("grpc_proxy.hedged_calls", {
+    description: "Proxied gRPC calls that sent hedges",
+  });
+  const hedgeAttempts = meter.createCounter("grpc_proxy.hedge.attempts", {
+    description: "HTTP requests sent by hedged calls",
+  });
+  // 最后一次加载的结果，通过 observable gauge 上报
+  let lastLoad: LoadMetric | null = null;
+  meter
+    .createObservableGauge("grpc_proxy.openapi.load.duration", {
+      unit: "s",
+      description: "Time spent loading openapi files",
+    })
+    .addCallback((result) => {
+      if (lastLoad) result.observe(lastLoad.duration);
+    });
+  meter
+    .createObservableGauge("grpc_proxy.openapi.routes", {
+      description: "Number of operations loaded from openapi files",
+    })
+    .addCallback((result) => {
+      if (lastLoad) result.observe(lastLoad.routes);
+    });
+  const rpcAttributes = (metric: HedgeMetric | CallMetric): Attributes => ({
+    "rpc.system": "grpc",
+    "rpc.service": getServicePath(metric.method).slice(1),
+    "rpc.method": metric.method.slice(metric.method.lastIndexOf("/") + 1),
+    "grpc_proxy.transport": metric.transport,
+  });
+  const toAttributes = (metric: CallMetric | RequestMetric): Attributes => {
+    const attributes: Attributes = {
+      ...rpcAttributes(metric),
+      "rpc.grpc.status_code": metric.grpcCode,
+      "grpc_proxy.gateway.url": metric.gateway,
+    };
+    if (metric.httpStatus !== null) {
+      attributes["http.response.status_code"] = metric.httpStatus;
+    }
+    return attributes;
+  };
+  return {
+    recordCall(metric) {
+      const attributes = toAttributes(metric);
+      calls.add(1, attributes);
+      callDuration.record(metric.duration, attributes);
+    },
+    recordRequest(metric) {
+      const attributes = toAttributes(metric);
+      requests.add(1, attributes);
+      requestDuration.record(metric.duration, attributes);
+    },
+    recordLoad(metric) {
+      lastLoad = metric;
+    },
+    recordHedge(metric) {
+      const attributes = rpcAttributes(metric);
+      hedgedCalls.add(1, {
+        ...attributes,
+        "grpc_proxy.hedge.won": metric.winner > 0,
+      });
+      hedgeAttempts.add(metric.attempts, attributes);
+    },
+  };
+}
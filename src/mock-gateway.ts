@@ -0,0 +1,336 @@
+import bath from "bath-es5";
+import { AddressInfo } from "node:net";
+import {
+  createServer,
+  IncomingHttpHeaders,
+  IncomingMessage,
+  Server,
+  ServerResponse,
+} from "node:http";
+import { status } from "@grpc/grpc-js";
+import { grpcStatus2HttpStatus } from "./openapi-utils";
+import { sampleFromSchema } from "./openapi-schema";
+import { isPermanentHttpHeader } from "./header-matcher";
+import { OpenapiV2Parser, OperationEntry } from "./openapi-v2-parser";
+
+// mock 收到的调用，按 grpc-gateway 的规则转换成了 gRPC 的请求
+export interface MockRequest {
+  callPath: string;
+  // 请求消息：请求体、query 和 path 参数合并后的结果
+  message: Record<string, unknown>;
+  // 和 grpc-gateway 的 DefaultHeaderMatcher 相同，key 是小写的
+  metadata: Record<string, string>;
+  method: string;
+  url: string;
+  headers: IncomingHttpHeaders;
+}
+
+export interface MockResponse {
+  // 响应消息，不返回时使用 openapi 中 200 响应的示例数据
+  message?: unknown;
+  // 默认 OK，其他状态按 grpc-gateway 的格式返回错误
+  code?: status;
+  details?: string;
+  // 作为 Grpc-Metadata-* 头返回
+  metadata?: Record<string, string>;
+  // 作为 Grpc-Trailer-* trailers 返回，请求没有 TE: trailers 头时不会返回
+  trailers?: Record<string, string>;
+}
+
+export type MockHandler = (
+  request: MockRequest
+) => MockResponse | void | Promise<MockResponse | void>;
+
+export interface MockGatewayOptions {
+  // openapi 目录
+  openapiDir: string;
+  // 监听的端口，默认 0（随机端口）
+  port?: number;
+  // 监听的地址，默认 127.0.0.1
+  host?: string;
+  // 按 callPath 注册的 handler，没有注册的方法返回示例数据
+  handlers?: Record<string, MockHandler>;
+}
+
+interface Route {
+  entry: OperationEntry;
+  params: (pathname: string) => Record<string, string> | null;
+}
+
+const METADATA_PREFIX = "grpc-metadata-";
+
+/**
+ * 请求头转换成 metadata，和 grpc-gateway 的 DefaultHeaderMatcher 相同
+ *  1. Grpc-Metadata- 前缀的头去掉前缀
+ *  2. permanent http 头加上 grpcgateway- 前缀，authorization 同时原样保留
+ * @param headers {IncomingHttpHeaders}
+ * @return {Record<string, string>}
+ */
+function headersToMetadata(
+  headers: IncomingHttpHeaders
+): Record<string, string> {
+  const metadata: Record<string, string> = {};
+  for (const [name, value] of Object.entries(headers)) {
+    if (value === undefined) continue;
+    const text = Array.isArray(value) ? value.join(", ") : value;
+    if (name.startsWith(METADATA_PREFIX)) {
+      metadata[name.slice(METADATA_PREFIX.length)] = text;
+    } else if (isPermanentHttpHeader(name)) {
+      metadata[`grpcgateway-${name}`] = text;
+      if (name === "authorization") metadata.authorization = text;
+    }
+  }
+  return metadata;
+}
+
+function readBody(req: IncomingMessage): Promise<string> {
+  return new Promise((resolve, reject) => {
+    const chunks: Buffer[] = [];
+    req.on("data", (chunk) => chunks.push(chunk));
+    req.on("end", () => resolve(Buffer.concat(chunks).toString("utf8")));
+    req.on("error", reject);
+  });
+}
+
+// grpc-gateway 的 query 参数：a.b 是嵌套的字段，a[key] 是 map 中的 key
+function setField(
+  message: Record<string, any>,
+  name: string,
+  value: string | string[]
+) {
+  const path = name.replace(/\[([^\]]*)\]/g, ".$1").split(".");
+  let node = message;
+  for (const key of path.slice(0, -1)) {
+    if (typeof node[key] !== "object" || node[key] === null) node[key] = {};
+    node = node[key];
+  }
+  node[path[path.length - 1]] = value;
+}
+
+/**
+ * 按 operation 的参数把 http 请求合并成请求消息
+ * @param entry {OperationEntry}
+ * @param body {unknown}
+ * @param query {URLSearchParams}
+ * @param pathParams {Record<string, string>}
+ * @return {Record<string, unknown>}
+ */
+function toMessage(
+  entry: OperationEntry,
+  body: unknown,
+  query: URLSearchParams,
+  pathParams: Record<string, string>
+): Record<string, unknown> {
+  const message: Record<string, unknown> = {};
+  const bodyParam = entry.operation.parameters.find((p) => p.in === "body");
+  if (bodyParam && body !== undefined) {
+    // body: "*" 时参数名是 body，否则是请求消息中的字段
+    if (bodyParam.name === "body") Object.assign(message, body);
+    else message[bodyParam.name] = body;
+  }
+  for (const name of new Set(query.keys())) {
+    const values = query.getAll(name);
+    setField(message, name, values.length > 1 ? values : values[0]);
+  }
+  return Object.assign(message, pathParams);
+}
+
+/**
+ * 根据 openapi 目录模拟的 grpc-gateway，不需要启动 gRPC 服务
+ */
+export class MockGateway {
+  // 收到的所有调用
+  public readonly calls: MockRequest[] = [];
+  private readonly handlers = new Map<string, MockHandler>();
+  private readonly routes: Route[];
+  private readonly server: Server;
+
+  constructor(
+    entries: OperationEntry[],
+    handlers: Record<string, MockHandler> = {}
+  ) {
+    this.routes = entries.map((entry) => ({
+      entry,
+      params: bath(entry.operation.path).params,
+    }));
+    for (const [callPath, handler] of Object.entries(handlers)) {
+      this.handle(callPath, handler);
+    }
+    this.server = createServer((req, res) => {
+      this.dispatch(req, res).catch((err) => {
+        if (!res.headersSent) {
+          this.sendError(res, status.UNKNOWN, (err as Error).message);
+        }
+      });
+    });
+  }
+
+  // 监听之后的地址，如 http://127.0.0.1:53011
+  public get url(): string {
+    const { address, port } = this.server.address() as AddressInfo;
+    return `http://${address}:${port}`;
+  }
+
+  // 所有模拟的方法
+  public get callPaths(): string[] {
+    return this.routes.map(({ entry }) => entry.callPath);
+  }
+
+  /**
+   * 注册 callPath 的 handler，会替换之前注册的
+   * @param callPath {string}
+   * @param handler {MockHandler}
+   * @return {this}
+   */
+  public handle(callPath: string, handler: MockHandler): this {
+    this.handlers.set(callPath, handler);
+    return this;
+  }
+
+  public listen(port = 0, host = "127.0.0.1"): Promise<this> {
+    return new Promise((resolve, reject) => {
+      this.server.once("error", reject);
+      this.server.listen(port, host, () => {
+        this.server.off("error", reject);
+        resolve(this);
+      });
+    });
+  }
+
+  public close(): Promise<void> {
+    return new Promise((resolve, reject) => {
+      this.server.close((err) => (err ? reject(err) : resolve()));
+    });
+  }
+
+  private match(method: string, pathname: string) {
+    let pathMatched = false;
+    for (const route of this.routes) {
+      const params = route.params(pathname);
+      if (!params) continue;
+      pathMatched = true;
+      if (route.entry.operation.method === method) {
+        return { route, params };
+      }
+    }
+    return pathMatched ? "method not allowed" : null;
+  }
+
+  private async dispatch(req: IncomingMessage, res: ServerResponse) {
+    const url = new URL(req.url || "/", "http://localhost");
+    const method = (req.method || "GET").toLowerCase();
+    const matched = this.match(method, url.pathname);
+    // 和 grpc-gateway 的 routingErrorHandler 相同
+    if (matched === null) {
+      this.sendError(res, status.NOT_FOUND, "Not Found");
+      return;
+    }
+    if (matched === "method not allowed") {
+      this.sendError(res, status.UNIMPLEMENTED, "Method Not Allowed", 405);
+      return;
+    }
+    const { entry } = matched.route;
+    const text = await readBody(req);
+    let body: unknown;
+    try {
+      body = text ? JSON.parse(text) : undefined;
+    } catch (err) {
+      this.sendError(res, status.INVALID_ARGUMENT, (err as Error).message);
+      return;
+    }
+    const request: MockRequest = {
+      callPath: entry.callPath,
+      message: toMessage(entry, body, url.searchParams, matched.params),
+      metadata: headersToMetadata(req.headers),
+      method: method.toUpperCase(),
+      url: req.url || "/",
+      headers: req.headers,
+    };
+    this.calls.push(request);
+    const handler = this.handlers.get(entry.callPath);
+    const response = (handler && (await handler(request))) || {};
+    this.send(req, res, entry, response);
+  }
+
+  private send(
+    req: IncomingMessage,
+    res: ServerResponse,
+    entry: OperationEntry,
+    response: MockResponse
+  ) {
+    // grpc-gateway 总是会返回 gRPC 响应的 content-type
+    res.setHeader(`${METADATA_PREFIX}content-type`, "application/grpc");
+    for (const [key, value] of Object.entries(response.metadata || {})) {
+      res.setHeader(`${METADATA_PREFIX}${key}`, value);
+    }
+    const trailers = Object.entries(response.trailers || {}).map(
+      ([key, value]) => [`Grpc-Trailer-${key}`, value]
+    );
+    // grpc-gateway 只在请求有 TE: trailers 头时返回 trailers
+    const accepted = /trailers/i.test(String(req.headers.te || ""));
+    const sent = accepted ? trailers : [];
+    if (sent.length > 0) {
+      res.setHeader("Trailer", sent.map(([name]) => name).join(", "));
+    }
+    const code = response.code ?? status.OK;
+    if (code !== status.OK) {
+      const body = { code, message: response.details || "", details: [] };
+      res.statusCode = grpcStatus2HttpStatus(code);
+      this.sendJson(res, body, sent);
+      return;
+    }
+    res.statusCode = 200;
+    this.sendJson(res, this.getMessage(entry, response), sent);
+  }
+
+  // 没有返回响应消息时使用 200 响应的示例数据
+  private getMessage(entry: OperationEntry, response: MockResponse) {
+    if (response.message !== undefined) return response.message;
+    const ok = entry.definition.responses?.["200"];
+    if (!ok || !("schema" in ok) || !ok.schema) return {};
+    return sampleFromSchema(ok.schema, entry.document);
+  }
+
+  private sendError(
+    res: ServerResponse,
+    code: status,
+    message: string,
+    httpStatus = grpcStatus2HttpStatus(code)
+  ) {
+    res.statusCode = httpStatus;
+    this.sendJson(res, { code, message, details: [] });
+  }
+
+  private sendJson(
+    res: ServerResponse,
+    data: unknown,
+    trailers: string[][] = []
+  ) {
+    res.setHeader("Content-Type", "application/json");
+    if (trailers.length > 0) {
+      // 需要使用 chunked 编码才能发送 trailers
+      res.write(JSON.stringify(data));
+      res.addTrailers(Object.fromEntries(trailers));
+      res.end();
+      return;
+    }
+    res.end(JSON.stringify(data));
+  }
+}
+
+/**
+ * 启动一个模拟的 grpc-gateway，openapi 目录中的每个方法都可以被调用
+ *  1. 没有注册 handler 的方法返回 200 响应 schema 的示例数据
+ *  2. handler 返回的错误状态按 grpc-gateway 的格式返回：{code, message, details}
+ *  3. metadata 和 trailers 作为 Grpc-Metadata-* 头和 Grpc-Trailer-* trailers 返回
+ * @param opts {MockGatewayOptions}
+ * @return {Promise<MockGateway>}
+ */
+export async function createMockGateway(
+  opts: MockGatewayOptions
+): Promise<MockGateway> {
+  const parser = new OpenapiV2Parser(opts.openapiDir);
+  await parser.init(false);
+  const gateway = new MockGateway(parser.getOperations(), opts.handlers);
+  return gateway.listen(opts.port, opts.host);
+}
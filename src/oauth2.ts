@@ -0,0 +1,180 @@
+import axios, { AxiosInstance } from "axios";
+import { CallResult } from "./grpc-utils";
+import { errorResult, TransportError } from "./proxy-error";
+import { ProxyRequest, TransportMiddleware } from "./http-transport";
+
+// OAuth2 client credentials 模式的配置，获取的 token 作为 Authorization: Bearer 发送给 grpc-gateway
+export interface OAuth2Options {
+  // token 接口地址，如：https://auth.example.com/oauth2/token
+  tokenUrl: string;
+  clientId: string;
+  clientSecret: string;
+  // 多个 scope 用空格拼接
+  scope?: string | string[];
+  audience?: string;
+  // client_secret_basic 通过 Authorization 头发送 client 凭证，client_secret_post 放在请求体中，默认 client_secret_basic
+  authMethod?: "client_secret_basic" | "client_secret_post";
+  // 其他发送给 token 接口的参数
+  params?: Record<string, string>;
+  // 提前刷新 token 的时间，单位毫秒，默认 30000
+  refreshMargin?: number;
+}
+
+interface CachedToken {
+  value: string;
+  // token 没有 expires_in 时为 Infinity，直到收到 401 才会刷新
+  expiresAt: number;
+}
+
+const DEFAULT_REFRESH_MARGIN = 30000;
+
+// 通过 Basic 认证发送时 client_id 和 client_secret 需要先 form 编码（RFC 6749 2.3.1）
+function formEncode(value: string): string {
+  return encodeURIComponent(value).replace(/%20/g, "+");
+}
+
+function tokenErrorResult(err: Error): CallResult<any> {
+  return errorResult(
+    new TransportError(`failed to fetch oauth2 token: ${err.message}`, err)
+  );
+}
+
+/**
+ * 给请求加上 Authorization: Bearer 头
+ * 调用凭证生成的 authorization 改为通过 Grpc-Metadata-authorization 发送，避免被覆盖
+ * @param request {ProxyRequest}
+ * @param token {string}
+ * @return {ProxyRequest}
+ */
+function withBearer(request: ProxyRequest, token: string): ProxyRequest {
+  const headers: Record<string, any> = {};
+  Object.entries(request.config.headers || {}).forEach(([key, value]) => {
+    if (key.toLowerCase() === "authorization") {
+      headers["Grpc-Metadata-authorization"] = value;
+      return;
+    }
+    headers[key] = value;
+  });
+  headers.Authorization = `Bearer ${token}`;
+  return { ...request, config: { ...request.config, headers } };
+}
+
+/**
+ * OAuth2 client credentials 模式的 token 提供者
+ *  1. token 缓存到过期前 refreshMargin 毫秒
+ *  2. 同一时间只有一个获取 token 的请求
+ *  3. grpc-gateway 返回 401 时丢弃当前 token，重新获取后重发一次
+ */
+export class ClientCredentialsProvider {
+  private token: CachedToken | null = null;
+  private pending: Promise<string> | null = null;
+
+  constructor(
+    private opts: OAuth2Options,
+    private client: AxiosInstance = axios
+  ) {}
+
+  private async fetchToken(): Promise<CachedToken> {
+    const { opts } = this;
+    const body = new URLSearchParams({
+      ...opts.params,
+      grant_type: "client_credentials",
+    });
+    if (opts.scope) {
+      body.set(
+        "scope",
+        Array.isArray(opts.scope) ? opts.scope.join(" ") : opts.scope
+      );
+    }
+    if (opts.audience) body.set("audience", opts.audience);
+    const headers: Record<string, string> = {
+      "Content-Type": "application/x-www-form-urlencoded",
+      Accept: "application/json",
+    };
+    if (opts.authMethod === "client_secret_post") {
+      body.set("client_id", opts.clientId);
+      body.set("client_secret", opts.clientSecret);
+    } else {
+      const credentials =
+        `${formEncode(opts.clientId)}:` + `${formEncode(opts.clientSecret)}`;
+      headers.Authorization = `Basic ${Buffer.from(credentials).toString(
+        "base64"
+      )}`;
+    }
+    const result = await this.client.post(opts.tokenUrl, body.toString(), {
+      headers,
+      validateStatus: () => true,
+    });
+    const data = result.data || {};
+    if (result.status < 200 || result.status >= 300) {
+      const reason = data.error_description || data.error || "";
+      throw new Error(
+        `token endpoint responded ${result.status}` +
+          (reason ? `: ${reason}` : "")
+      );
+    }
+    if (typeof data.access_token !== "string" || !data.access_token) {
+      throw new Error("token endpoint returned no access_token");
+    }
+    const expiresIn = Number(data.expires_in);
+    const margin = opts.refreshMargin ?? DEFAULT_REFRESH_MARGIN;
+    return {
+      value: data.access_token,
+      expiresAt:
+        expiresIn > 0
+          ? Date.now() + Math.max(expiresIn * 1000 - margin, 0)
+          : Infinity,
+    };
+  }
+
+  /**
+   * 获取当前有效的 token，过期或者没有时重新获取
+   * @return {Promise<string>}
+   */
+  public getToken(): Promise<string> {
+    if (this.token && Date.now() < this.token.expiresAt) {
+      return Promise.resolve(this.token.value);
+    }
+    if (!this.pending) {
+      this.pending = this.fetchToken()
+        .then((token) => {
+          this.token = token;
+          return token.value;
+        })
+        .finally(() => (this.pending = null));
+    }
+    return this.pending;
+  }
+
+  /**
+   * 丢弃被 grpc-gateway 拒绝的 token，token 已经被刷新过时不做处理
+   * @param token {string}
+   */
+  public invalidate(token: string) {
+    if (this.token?.value === token) this.token = null;
+  }
+
+  /**
+   * 给请求加上 token 的 middleware，收到 401 时重新获取 token 并重发一次
+   * @return {TransportMiddleware}
+   */
+  public middleware(): TransportMiddleware {
+    return async (request, next) => {
+      let token: string;
+      try {
+        token = await this.getToken();
+      } catch (err) {
+        return tokenErrorResult(err as Error);
+      }
+      const result = await next(withBearer(request, token));
+      if (result.http?.status !== 401) return result;
+      this.invalidate(token);
+      try {
+        token = await this.getToken();
+      } catch (err) {
+        return result;
+      }
+      return next(withBearer(request, token));
+    };
+  }
+}
@@ -1,14 +1,94 @@
+import { EventEmitter } from "node:events";
+import type { Tracer } from "@opentelemetry/api";
 import { isValidUrl } from "./helper";
+import { CircuitBreakerOptions } from "./circuit-breaker";
+import { isValidGatewayList, LoadBalancingOptions } from "./load-balancer";
+import { isGatewayResolver } from "./gateway-resolver";
+import { TlsOptions } from "./tls-options";
+import { ProxyConfig } from "./http-proxy";
+import {
+  CredentialsOption,
+  getServiceUrl,
+  toCallCredentials,
+  withCallCredentials,
+} from "./call-credentials";
+import { ClientCredentialsProvider, OAuth2Options } from "./oauth2";
+import { RequestSigner } from "./request-signer";
+import { HeaderMatchingOptions } from "./header-matcher";
+import { createDefaultClient, HttpClient } from "./http-client";
+import { HttpTransport } from "./http-transport";
+import {
+  CallPipeline,
+  createObservers,
+  createTransport,
+  Observers,
+} from "./call-pipeline";
+import { ProxyChannel } from "./proxy-channel";
+import { ProxyMetrics, withLoadMetrics } from "./metrics";
+import { ProxyLogger } from "./logger";
+import { createValidator, ValidationOptions } from "./schema-validator";
+import { Readiness } from "./readiness";
+import { Idempotent, isIdempotent } from "./idempotency";
+import { ChannelOptions, InterceptingCall, Interceptor } from "@grpc/grpc-js";
 import { Getaway, OpenapiV2Proxy } from "./openapi-proxy-impl";
-import { InterceptingCall, Interceptor, Metadata } from "@grpc/grpc-js";
-import { InterceptingListener } from "@grpc/grpc-js/build/src/call-stream";
+import {
+  createTransportHealth,
+  downstreamInterceptorCall,
+  FallbackOptions,
+  hasDownstreamInterceptor,
+  hybridCall,
+  proxyCall,
+  ProxyInterceptor,
+  withEvents,
+} from "./interceptor-call";
 
 // 拦截器的配置选项
 interface Options {
-  // grpc-gateway 服务地址
+  // grpc-gateway 服务地址，配置多个地址时按 loadBalancing 分配
   getaway: Getaway;
   // openapi 目录
   openapiDir: string;
+  // 在直连和 grpc-gateway 之间自动切换，不配置时始终使用 grpc-gateway
+  fallback?: FallbackOptions;
+  // 幂等的方法，只有幂等的方法才会在切换传输方式时重发
+  idempotent?: Idempotent;
+  // 在通过 http 接管调用之前需要经过的拦截器（client 中不能有排在该拦截器之后的拦截器）
+  interceptors?: Interceptor[];
+  // 和 client 相同的 channel options，会读取 grpc.service_config 中的 retryPolicy
+  channelOptions?: ChannelOptions;
+  // 按 grpc-gateway 地址熔断，不配置时不熔断
+  circuitBreaker?: CircuitBreakerOptions;
+  // getaway 配置了多个地址时的负载均衡策略和健康检查
+  loadBalancing?: LoadBalancingOptions;
+  // 发送请求使用的 http 客户端：axios 实例、兼容 fetch 的函数或者 undiciClient()
+  httpClient?: HttpClient;
+  // 访问 https 的 grpc-gateway 时使用的 CA、客户端证书等，只作用于默认的 http 客户端和健康检查
+  tls?: TlsOptions;
+  // 访问 grpc-gateway 使用的代理，只作用于默认的 http 客户端和健康检查，不配置时读取 HTTPS_PROXY/HTTP_PROXY/NO_PROXY
+  proxy?: ProxyConfig;
+  // 调用凭证，如 credentials.createFromMetadataGenerator，生成的 authorization 会作为 Authorization 头发送
+  // 和单次调用的 credentials 选项组合使用
+  credentials?: CredentialsOption;
+  // openapi 文件加载完成之前的调用最多等待的时间，单位毫秒，默认 10000
+  readyTimeout?: number;
+  // grpc-gateway 前的 ingress 要求的 OAuth2 client credentials 鉴权，和 gRPC 的调用凭证无关
+  oauth2?: OAuth2Options | ClientCredentialsProvider;
+  // 发送前对最终的地址、头和请求体签名，返回需要添加的头，可以使用 hmacSigner、sigV4Signer
+  signRequest?: RequestSigner;
+  // metadata 和 http 头之间的转换规则，需要和 grpc-gateway 的 WithIncomingHeaderMatcher/WithOutgoingHeaderMatcher 对应
+  headers?: HeaderMatchingOptions;
+  // @opentelemetry/api 的 Tracer，如 trace.getTracer("grpc-proxy-interceptor")，配置后为每个调用创建 client span，
+  // 并通过 traceparent/tracestate 头传播链路，grpc-web 模式还会发送 grpc-trace-bin
+  tracer?: Tracer;
+  // 调用、http 请求和 openapi 加载的统计，可以使用 prometheusMetrics、openTelemetryMetrics
+  metrics?: ProxyMetrics;
+  // 日志的接收方，如 consoleLogger("debug")，默认只输出 warn 和 error 到 console
+  logger?: ProxyLogger;
+  // 日志中隐藏值的头，默认隐藏 authorization、proxy-authorization、cookie 和 set-cookie
+  redactedHeaders?: string[];
+  // 按 openapi 的 schema 校验消息：不符合的请求返回 INVALID_ARGUMENT，不会发送 http 请求；
+  // response 可以检查 grpc-gateway 的响应是否和 openapi 一致，建议只在开发环境开启
+  validation?: ValidationOptions;
 }
 
 // 默认配置
@@ -31,82 +111,170 @@ function handleInterceptorOption(opts?: Options) {
   if (typeof result.getaway === "string" && !isValidUrl(result.getaway)) {
     throw new Error("Invalid opt.getaway ！");
   }
+  if (Array.isArray(result.getaway) && !isValidGatewayList(result.getaway)) {
+    throw new Error("Invalid opt.getaway ！");
+  }
+  if (
+    typeof result.getaway === "object" &&
+    !Array.isArray(result.getaway) &&
+    !isGatewayResolver(result.getaway)
+  ) {
+    throw new Error("Invalid opt.getaway ！");
+  }
   if (typeof result.openapiDir !== "string" || result.openapiDir === "") {
     throw new Error("Opt.openapiDir is a required parameter！");
   }
   return result;
 }
 
+// 拦截器和 Channel 共用的部分，openapi 文件在创建时开始加载
+interface ProxyRuntime {
+  opt: Options;
+  emitter: EventEmitter;
+  observers: Observers;
+  readiness: Readiness;
+  pipeline: CallPipeline;
+  transport: HttpTransport;
+}
+
+/**
+ * 校验配置，创建加载 openapi 文件的 OpenapiV2Proxy 和发送调用的 CallPipeline
+ * @param opts {Options | undefined}
+ * @param sync {boolean} - 是否同步读取 openapi 文件
+ * @return {ProxyRuntime}
+ */
+function createProxyRuntime(
+  opts: Options | undefined,
+  sync: boolean
+): ProxyRuntime {
+  const opt = handleInterceptorOption(opts);
+  const emitter = new EventEmitter();
+  const observers = createObservers(opt, "grpc-gateway");
+  const transport = createTransport(opt, emitter, observers);
+  const apiProxy = new OpenapiV2Proxy(
+    opt.openapiDir,
+    opt.getaway,
+    transport,
+    opt.headers?.request,
+    createValidator(opt.validation, observers.logging)
+  );
+  const load = withLoadMetrics(
+    () => apiProxy.load(sync),
+    () => apiProxy.getRouteCount(),
+    opt.metrics
+  );
+  const readiness = new Readiness(load, emitter, observers.logging);
+  const pipeline = new CallPipeline(
+    (callPath, message, metadata, signal) =>
+      apiProxy.call(callPath, message, metadata, signal),
+    opt,
+    emitter,
+    observers
+  );
+  return { opt, emitter, observers, readiness, pipeline, transport };
+}
+
 /**
  * 拦截器的内部实现
- * @return {Interceptor}
- * @param apiProxy
+ * @param runtime {ProxyRuntime}
+ * @return {ProxyInterceptor}
  */
-function interceptorImpl(apiProxy: OpenapiV2Proxy): Interceptor {
-  return function (options, nextCall) {
-    if (!apiProxy || !apiProxy?.getLoadStatus()) {
-      return new InterceptingCall(nextCall(options));
-    }
+function interceptorImpl(runtime: ProxyRuntime): ProxyInterceptor {
+  const { opt, emitter, observers, readiness, pipeline, transport } = runtime;
+  const health = createTransportHealth(opt.fallback, emitter);
+  const target = typeof opt.getaway === "string" ? opt.getaway : "";
+  const interceptor: Interceptor = (options, nextCall) => {
     const callPath = options.method_definition.path;
     // grpc-web 是支持 responseStream 的
     if (
       options.method_definition.requestStream ||
       options.method_definition.responseStream
     ) {
-      console.warn(`${callPath}: 不支持流式调用!`);
+      observers.logging?.log(
+        "warn",
+        `${callPath}: streaming calls are sent to the gRPC port directly`,
+        { callPath }
+      );
       return new InterceptingCall(nextCall(options));
     }
-    const ref = {
-      message: null as unknown,
-      metadata: new Metadata(),
-      listener: {} as InterceptingListener,
-    };
-    return new InterceptingCall(nextCall(options), {
-      start: function (metadata, listener, next) {
-        ref.metadata = metadata;
-        ref.listener = listener;
-      },
-      sendMessage: async function (message, next) {
-        ref.message = message;
-      },
-      halfClose: async function (next) {
-        const { metadata, message, listener } = ref;
-        // 这里的 message 是还没有被 protobuf 序列化的。
-        // 注意此刻的 metadata 的 key 会被全部转换为小写，但是通过 get 方法取值时，是大小写不敏感的。
-        // 此刻的 value 类型是 [MedataValue]
-        // call 方法保证即使是内部错误，也会返回一个正确的结构
-        const result = await apiProxy.call(callPath, message, metadata);
-        // 下面方法的顺序是有要求的。
-        listener.onReceiveMessage(result.response);
-        listener.onReceiveMetadata(result.metadata);
-        listener.onReceiveStatus(result.status);
+    if (hasDownstreamInterceptor(nextCall)) {
+      return downstreamInterceptorCall(options, observers.logging);
+    }
+    // openapi 文件加载完成之前的调用会等待，而不是直接连接 gRPC 端口
+    const handler = withCallCredentials(
+      readiness.wrap(pipeline.handler(callPath), opt.readyTimeout),
+      toCallCredentials(opt.credentials, options.credentials),
+      getServiceUrl(options.host || target, callPath)
+    );
+    if (!health) {
+      return proxyCall(options, handler, opt.interceptors);
+    }
+    return hybridCall(
+      options,
+      nextCall,
+      handler,
+      {
+        health,
+        target: options.host || target,
+        idempotent: isIdempotent(opt.idempotent, callPath),
       },
-    });
+      opt.interceptors
+    );
   };
+  return withEvents(interceptor, emitter, transport, () => readiness.ready());
 }
 
 /**
  * grpc client interceptor 代理 grpc 请求到 grpc-getaway 的拦截器
- *  1. 使用时确保该拦截器在最后一个（此拦截器不会调用后续的拦截器）
- *  2. 注意调用该拦截器是会异步读取并解析 openapi 文件，期间拦截器将不工作
+ *  1. 此拦截器会接管调用，client 中排在它之后的拦截器会导致调用返回 FAILED_PRECONDITION，需要通过 opts.interceptors 传入
+ *     或者使用 Channel 的方式，这样所有的拦截器都会在 http 调用之前执行
+ *  2. 等待 openapi 文件加载结束后返回，加载失败时调用会返回 FAILED_PRECONDITION，可以通过 ready() 获取错误
  * @param [opts] {Options}
- * @return {Promise<Interceptor>}
+ * @return {Promise<ProxyInterceptor>}
  */
-export async function openapiInterceptor(opts?: Options): Promise<Interceptor> {
-  const opt = handleInterceptorOption(opts);
-  const apiProxy = new OpenapiV2Proxy(opt.openapiDir, opt.getaway);
-  await apiProxy.load(false);
-  return interceptorImpl(apiProxy);
+export async function openapiInterceptor(
+  opts?: Options
+): Promise<ProxyInterceptor> {
+  const runtime = createProxyRuntime(opts, false);
+  await runtime.readiness.whenSettled();
+  return interceptorImpl(runtime);
 }
 
 /**
- * 同步初始化版本, 会有一段短暂的不可用时间。
+ * 同步初始化版本，加载完成之前的调用会等待，最多等待 opts.readyTimeout 毫秒
+ * 加载完成时触发 ready 事件，失败时触发 error 事件
  * @param {Options} opts
- * @return {Interceptor}
+ * @return {ProxyInterceptor}
  */
-export function openapiInterceptorSync(opts: Options): Interceptor {
-  const opt = handleInterceptorOption(opts);
-  const apiProxy = new OpenapiV2Proxy(opt.openapiDir, opt.getaway);
-  apiProxy.load(true);
-  return interceptorImpl(apiProxy);
+export function openapiInterceptorSync(opts: Options): ProxyInterceptor {
+  return interceptorImpl(createProxyRuntime(opts, true));
+}
+
+/**
+ * 创建完全通过 grpc-gateway 调用的 Channel，不会打开到 gRPC 端口的连接
+ * 使用方式：new Client(address, credentials, { channelOverride: await openapiChannel(opts) })
+ * @param opts {Options & {definition: PackageDefinition}} - definition 是 @grpc/proto-loader 加载的结果
+ * @return {Promise<ProxyChannel>}
+ */
+export async function openapiChannel(
+  opts: Options & { definition: Record<string, any> }
+): Promise<ProxyChannel> {
+  const { opt, emitter, readiness, pipeline, transport } =
+    createProxyRuntime(opts, false);
+  await readiness.whenSettled();
+  return new ProxyChannel({
+    emitter,
+    client: createDefaultClient(opt),
+    target: typeof opt.getaway === "string" ? opt.getaway : "",
+    credentials: toCallCredentials(opt.credentials),
+    definition: opts.definition,
+    ready: () => readiness.ready(),
+    close: () => transport.close(),
+    handler: (callPath, message, metadata, signal) =>
+      readiness.wrap(pipeline.handler(callPath), opt.readyTimeout)(
+        message,
+        metadata,
+        signal
+      ),
+  });
 }
@@ -1,25 +1,41 @@
 import * as qs from "qs";
 import { Metadata, status } from "@grpc/grpc-js";
-import { OpenapiV2Parser } from "./openapi-v2-parser";
-import { CallResult, parseCallPath } from "./grpc-utils";
-import axios, { AxiosInstance, AxiosRequestConfig, Method } from "axios";
+import { toMetadataHeader } from "./openapi-utils";
+import { HttpTransport } from "./http-transport";
+import { HeaderRule } from "./header-matcher";
+import { GatewayList } from "./load-balancer";
+import { GatewayResolver, unresolvedResult } from "./gateway-resolver";
+import { AxiosRequestConfig, Method } from "axios";
+import { Operation, OpenapiV2Parser } from "./openapi-v2-parser";
+import { SchemaValidator } from "./schema-validator";
 import {
-  getMetadataFromHeader,
-  getTrailersMetadata,
-  httpStatus2GrpcStatus,
-  toMetadataHeader,
-} from "./openapi-utils";
+  ProxyError,
+  RouteNotFound,
+  settleCall,
+  TranscodeError,
+} from "./proxy-error";
+import { CallResult, parseCallPath } from "./grpc-utils";
 
-type GetGetawayFn = (value: { filePath: string; callPath: string }) => string;
-export type Getaway = string | GetGetawayFn;
+type GetGetawayFn = (value: {
+  filePath: string;
+  callPath: string;
+}) => string | Promise<string>;
+// 配置多个地址或者 GatewayResolver 时由 LoadBalancer 选择
+export type Getaway = string | GetGetawayFn | GatewayList | GatewayResolver;
 
 // 根据 callPath 查找 openapi 定义然后使用 http 调用它
 export class OpenapiV2Proxy {
   private readonly openapiV2Parser: OpenapiV2Parser;
-  private readonly request: AxiosInstance;
 
-  constructor(private dir: string, private getaway: Getaway) {
-    this.request = axios.create();
+  constructor(
+    private dir: string,
+    private getaway: Getaway,
+    private transport: HttpTransport = new HttpTransport(),
+    // metadata 转换成请求头的规则
+    private headerRule?: HeaderRule,
+    // 按 openapi 的 schema 校验请求和响应，不配置时不校验
+    private validator: SchemaValidator | null = null
+  ) {
     this.openapiV2Parser = new OpenapiV2Parser(this.dir);
   }
 
@@ -31,29 +47,84 @@ export class OpenapiV2Proxy {
     return this.openapiV2Parser.init(sync);
   }
 
-  private getBaseUrl(callPath: string, filePath: string): string {
+  public getRouteCount(): number {
+    return this.openapiV2Parser.getRouteCount();
+  }
+
+  private async getBaseUrl(callPath: string, filePath: string) {
+    // 多个地址时由 LoadBalancer 填充 baseUrl
+    if (typeof this.getaway === "object") return "";
     return typeof this.getaway === "function"
       ? this.getaway({ callPath, filePath })
       : this.getaway;
   }
 
-  public async call<B = any, T = any>(
+  /**
+   * 查找 openapi 定义并通过 http 调用，不会抛出错误
+   *  1. 没有找到定义返回 UNIMPLEMENTED
+   *  2. 请求消息不符合 schema 或者无法转换成 http 请求返回 INVALID_ARGUMENT
+   *  3. grpc-gateway 地址获取失败返回 UNAVAILABLE
+   */
+  public call<B = any, T = any>(
     callPath: string,
     message: B,
-    metadata: Metadata
+    metadata: Metadata,
+    signal?: AbortSignal
   ): Promise<CallResult<T>> {
-    if (!this.openapiV2Parser.loading)
-      throw new Error("openapi 文件未加载完毕！");
-    const requestId = parseCallPath(callPath);
-    const operation = this.openapiV2Parser.getOperation(requestId);
-    if (operation === null) throw new Error("没有找到 openapi 定义！");
-    const baseUrl = this.getBaseUrl(callPath, operation.filePath);
-    const requestConfig = this.openapiV2Parser.getRequestConfigForOperation(
-      operation,
-      [message as any, message]
+    return settleCall(() => this.send(callPath, message, metadata, signal));
+  }
+
+  private getOperation(callPath: string): Operation {
+    if (!this.openapiV2Parser.loading) {
+      throw new ProxyError(
+        status.FAILED_PRECONDITION,
+        "openapi files are not loaded"
+      );
+    }
+    const operation = this.openapiV2Parser.getOperation(
+      parseCallPath(callPath)
     );
-    const axiosConfig: AxiosRequestConfig = {
-      baseURL: baseUrl,
+    if (operation === null) {
+      throw new RouteNotFound(`no openapi operation found for ${callPath}`);
+    }
+    return operation;
+  }
+
+  private getRequestConfig(operation: Operation, message: any) {
+    try {
+      return this.openapiV2Parser.getRequestConfigForOperation(operation, [
+        message,
+        message,
+      ]);
+    } catch (err) {
+      throw new TranscodeError(
+        `failed to transcode request for ${operation.operationId}: ` +
+          `${(err as Error).message}`,
+        err
+      );
+    }
+  }
+
+  private async send<B, T>(
+    callPath: string,
+    message: B,
+    metadata: Metadata,
+    signal?: AbortSignal
+  ): Promise<CallResult<T>> {
+    const operation = this.getOperation(callPath);
+    const document = this.openapiV2Parser.getDocument(operation.filePath);
+    if (this.validator && document) {
+      this.validator.checkRequest(callPath, operation, message, document);
+    }
+    const requestConfig = this.getRequestConfig(operation, message);
+    let baseUrl: string;
+    try {
+      baseUrl = await this.getBaseUrl(callPath, operation.filePath);
+    } catch (err) {
+      return unresolvedResult(callPath, err as Error);
+    }
+    const config: AxiosRequestConfig = {
+      signal,
       url: requestConfig.path,
       data: requestConfig.payload,
       method: requestConfig.method as Method,
@@ -61,46 +132,20 @@ export class OpenapiV2Proxy {
       headers: Object.assign(
         {},
         requestConfig.headers,
-        toMetadataHeader(metadata)
+        toMetadataHeader(metadata, this.headerRule)
       ),
       params:
         requestConfig.method === "get"
           ? qs.stringify(requestConfig.query)
           : undefined,
     };
-    try {
-      const result = await this.request.request(axiosConfig);
-      const resultMetadata = getMetadataFromHeader(result.headers);
-      return {
-        response: result.data,
-        metadata: resultMetadata,
-        status: {
-          code: httpStatus2GrpcStatus(result.status),
-          details: "",
-          metadata: getTrailersMetadata(result.request.res.rawTrailers),
-        },
-      };
-    } catch (err: any) {
-      if (err.response) {
-        return {
-          response: err.response.data as T,
-          metadata: getMetadataFromHeader(err.response.headers),
-          status: {
-            metadata: getTrailersMetadata(err.response.request.res.rawTrailers),
-            details: err.response.data.message,
-            code: httpStatus2GrpcStatus(err.response.status),
-          },
-        };
-      }
-      return {
-        response: null as unknown as T,
-        metadata: new Metadata(),
-        status: {
-          metadata: new Metadata(),
-          details: (err as Error).message,
-          code: status.UNKNOWN,
-        },
-      };
-    }
+    const result = await this.transport.send({
+      callPath,
+      baseUrl,
+      config,
+      metadata,
+    });
+    if (!this.validator || !document) return result;
+    return this.validator.checkResponse(callPath, operation, result, document);
   }
 }
@@ -0,0 +1,244 @@
+import { OpenAPIV2 } from "openapi-types";
+
+export type Schema = OpenAPIV2.SchemaObject | OpenAPIV2.ReferenceObject;
+
+function isReference(schema: Schema): schema is OpenAPIV2.ReferenceObject {
+  return typeof (schema as OpenAPIV2.ReferenceObject).$ref === "string";
+}
+
+/**
+ * 解析文件内的 $ref，如 #/definitions/google.rpc.Status
+ * @param document {OpenAPIV2.Document}
+ * @param ref {string}
+ * @return {OpenAPIV2.SchemaObject}
+ */
+export function resolveRef(
+  document: OpenAPIV2.Document,
+  ref: string
+): OpenAPIV2.SchemaObject {
+  if (!ref.startsWith("#/")) {
+    throw new Error(`unsupported $ref ${ref}`);
+  }
+  // JSON Pointer 的转义：~1 是 /，~0 是 ~
+  const target = ref
+    .slice(2)
+    .split("/")
+    .map((part) => part.replace(/~1/g, "/").replace(/~0/g, "~"))
+    .reduce<any>((node, part) => node?.[part], document);
+  if (!target || typeof target !== "object") {
+    throw new Error(`cannot resolve $ref ${ref}`);
+  }
+  return target;
+}
+
+/**
+ * 生成符合 schema 的示例数据，有 example 或 default 时直接使用
+ *  1. int64、uint64 和 grpc-gateway 一样是字符串
+ *  2. 循环引用的 message 在第二次出现时为空对象
+ * @param schema {Schema}
+ * @param document {OpenAPIV2.Document}
+ * @param [refs] {Set<string>} - 正在生成的 $ref
+ * @return {unknown}
+ */
+export function sampleFromSchema(
+  schema: Schema,
+  document: OpenAPIV2.Document,
+  refs: Set<string> = new Set()
+): unknown {
+  if (isReference(schema)) {
+    if (refs.has(schema.$ref)) return {};
+    const next = new Set(refs).add(schema.$ref);
+    return sampleFromSchema(resolveRef(document, schema.$ref), document, next);
+  }
+  if (schema.example !== undefined) return schema.example;
+  if (schema.default !== undefined) return schema.default;
+  if (schema.enum?.length) return schema.enum[0];
+  const type = Array.isArray(schema.type) ? schema.type[0] : schema.type;
+  switch (type) {
+    case "string":
+      if (schema.format === "date-time") return "1970-01-01T00:00:00Z";
+      if (schema.format === "byte") return "";
+      if (schema.format === "int64" || schema.format === "uint64") return "0";
+      return "string";
+    case "integer":
+    case "number":
+      return 0;
+    case "boolean":
+      return false;
+    case "array":
+      if (!schema.items) return [];
+      return [sampleFromSchema(schema.items as Schema, document, refs)];
+  }
+  // 没有 type 的 schema 按 object 处理，如 google.protobuf.Empty
+  const result: Record<string, unknown> = {};
+  for (const [name, property] of Object.entries(schema.properties || {})) {
+    result[name] = sampleFromSchema(property, document, refs);
+  }
+  return result;
+}
+
+// 不符合 schema 的字段
+export interface SchemaViolation {
+  // 字段的路径，如 metadata.code、items[0]，消息本身为空字符串
+  path: string;
+  message: string;
+}
+
+export interface ValidateOptions {
+  // 是否允许 schema 中没有定义的字段，grpc-gateway 会忽略请求中未知的字段，默认 true
+  allowUnknown?: boolean;
+}
+
+const INTEGER_STRING = /^-?\d+$/;
+const LONG_FORMATS = ["int64", "uint64", "fixed64", "sfixed64", "sint64"];
+
+function join(path: string, key: string): string {
+  return path ? `${path}.${key}` : key;
+}
+
+function isPlainObject(value: unknown): value is Record<string, unknown> {
+  return (
+    typeof value === "object" &&
+    value !== null &&
+    !Array.isArray(value) &&
+    !Buffer.isBuffer(value)
+  );
+}
+
+// int64 可以是数字、数字字符串或者 proto-loader 的 Long
+function isLong(value: unknown): boolean {
+  if (typeof value === "number") return Number.isInteger(value);
+  if (typeof value === "string") return INTEGER_STRING.test(value);
+  return isPlainObject(value) && "low" in value && "high" in value;
+}
+
+function checkType(
+  value: unknown,
+  schema: OpenAPIV2.SchemaObject
+): string | null {
+  const type = Array.isArray(schema.type) ? schema.type[0] : schema.type;
+  switch (type) {
+    case "string":
+      if (LONG_FORMATS.includes(schema.format || "")) {
+        return isLong(value) ? null : "must be an int64 string";
+      }
+      if (schema.format === "byte") {
+        return typeof value === "string" || Buffer.isBuffer(value)
+          ? null
+          : "must be a base64 string";
+      }
+      return typeof value === "string" ? null : "must be a string";
+    case "integer":
+      // protojson 也接受字符串形式的整数
+      if (typeof value === "string" && INTEGER_STRING.test(value)) return null;
+      return Number.isInteger(value) ? null : "must be an integer";
+    case "number":
+      if (typeof value === "string" && !Number.isNaN(Number(value))) {
+        return null;
+      }
+      return typeof value === "number" ? null : "must be a number";
+    case "boolean":
+      return typeof value === "boolean" ? null : "must be a boolean";
+    case "array":
+      return Array.isArray(value) ? null : "must be an array";
+  }
+  // 空的 schema（如 google.protobuf.Any 的 additionalProperties）可以是任意值
+  if (type !== "object" && !schema.properties && !schema.additionalProperties) {
+    return null;
+  }
+  return isPlainObject(value) ? null : "must be an object";
+}
+
+/**
+ * 按 schema 校验 JSON 格式的消息，$ref 会被解析
+ *  1. null 是字段的默认值，总是有效的
+ *  2. enum 字段也可以是数字，和 grpc-gateway 的 protojson 相同
+ * @param value {unknown}
+ * @param schema {Schema}
+ * @param document {OpenAPIV2.Document}
+ * @param [opts] {ValidateOptions}
+ * @param [path] {string}
+ * @return {SchemaViolation[]}
+ */
+export function validateSchema(
+  value: unknown,
+  schema: Schema,
+  document: OpenAPIV2.Document,
+  opts: ValidateOptions = {},
+  path = ""
+): SchemaViolation[] {
+  if (value === null || value === undefined) return [];
+  if (isReference(schema)) {
+    const target = resolveRef(document, schema.$ref);
+    return validateSchema(value, target, document, opts, path);
+  }
+  if (schema.enum?.length) {
+    if (schema.enum.includes(value) || Number.isInteger(value)) return [];
+    return [{ path, message: `must be one of ${schema.enum.join(", ")}` }];
+  }
+  const message = checkType(value, schema);
+  if (message) return [{ path, message }];
+  if (Array.isArray(value)) {
+    if (!schema.items) return [];
+    return value.flatMap((item, index) =>
+      validateSchema(
+        item,
+        schema.items as Schema,
+        document,
+        opts,
+        `${path}[${index}]`
+      )
+    );
+  }
+  if (!isPlainObject(value)) return [];
+  const violations: SchemaViolation[] = [];
+  const properties = schema.properties || {};
+  for (const name of schema.required || []) {
+    if (value[name] === undefined) {
+      violations.push({ path: join(path, name), message: "is required" });
+    }
+  }
+  const { additionalProperties } = schema;
+  for (const [name, field] of Object.entries(value)) {
+    const fieldPath = join(path, name);
+    if (properties[name]) {
+      violations.push(
+        ...validateSchema(field, properties[name], document, opts, fieldPath)
+      );
+    } else if (typeof additionalProperties === "object") {
+      violations.push(
+        ...validateSchema(
+          field,
+          additionalProperties as Schema,
+          document,
+          opts,
+          fieldPath
+        )
+      );
+    } else if (
+      opts.allowUnknown === false &&
+      additionalProperties === undefined &&
+      schema.properties
+    ) {
+      violations.push({ path: fieldPath, message: "is not defined" });
+    }
+  }
+  return violations;
+}
+
+/**
+ * 校验结果的描述，最多列出 limit 个字段
+ * @param violations {SchemaViolation[]}
+ * @param [limit] {number}
+ * @return {string}
+ */
+export function formatViolations(
+  violations: SchemaViolation[],
+  limit = 5
+): string {
+  const items = violations
+    .slice(0, limit)
+    .map(({ path, message }) => (path ? `${path} ${message}` : message));
+  const more = violations.length - items.length;
+  return items.join("; ") + (more > 0 ? `; and ${more} more` : "");
+}
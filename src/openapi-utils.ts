@@ -2,6 +2,12 @@ import { toMetadata } from "./grpc-utils";
 import { Metadata, status } from "@grpc/grpc-js";
 import { Status } from "@grpc/grpc-js/src/constants";
 import { StatusCodes as httpStatus } from "http-status-codes";
+import {
+  createHeaderMapper,
+  defaultRequestHeaderMatcher,
+  defaultResponseHeaderMatcher,
+  HeaderRule,
+} from "./header-matcher";
 
 // 该方式是 grpc-getaway 库的转换方式.
 // 转换 http 状态码到 gRPC 状态码
@@ -51,21 +57,95 @@ export function httpStatus2GrpcStatus(code: httpStatus): Status {
       return status.UNIMPLEMENTED;
     case httpStatus.SERVICE_UNAVAILABLE:
       return status.UNAVAILABLE;
+    // grpc-gateway 不会返回 502，是它前面的负载均衡或者代理无法连接到 grpc-gateway
+    case httpStatus.BAD_GATEWAY:
+      return status.UNAVAILABLE;
   }
   return status.INTERNAL;
 }
 
+/**
+ * gRPC 状态码到 http 状态码，和 grpc-gateway 的 HTTPStatusFromCode 相同
+ * @param code {Status}
+ * @return {number}
+ */
+export function grpcStatus2HttpStatus(code: Status): number {
+  switch (code) {
+    case status.OK:
+      return httpStatus.OK;
+    case status.CANCELLED:
+      return 499;
+    case status.INVALID_ARGUMENT:
+    case status.FAILED_PRECONDITION:
+    case status.OUT_OF_RANGE:
+      return httpStatus.BAD_REQUEST;
+    case status.DEADLINE_EXCEEDED:
+      return httpStatus.GATEWAY_TIMEOUT;
+    case status.NOT_FOUND:
+      return httpStatus.NOT_FOUND;
+    case status.ALREADY_EXISTS:
+    case status.ABORTED:
+      return httpStatus.CONFLICT;
+    case status.PERMISSION_DENIED:
+      return httpStatus.FORBIDDEN;
+    case status.UNAUTHENTICATED:
+      return httpStatus.UNAUTHORIZED;
+    case status.RESOURCE_EXHAUSTED:
+      return httpStatus.TOO_MANY_REQUESTS;
+    case status.UNIMPLEMENTED:
+      return httpStatus.NOT_IMPLEMENTED;
+    case status.UNAVAILABLE:
+      return httpStatus.SERVICE_UNAVAILABLE;
+  }
+  return httpStatus.INTERNAL_SERVER_ERROR;
+}
+
+// 错误信息中响应体最多保留的长度，负载均衡返回的 html 页面可能很长
+export const MAX_ERROR_BODY_LENGTH = 256;
+
+/**
+ * 获取失败的响应中的错误信息
+ *  1. grpc-gateway 返回的是 JSON，使用其中的 message
+ *  2. 负载均衡、代理返回的 html、纯文本或者空的响应体，使用截断后的响应体
+ * @param code {number} - http 状态码
+ * @param data {unknown} - 响应体，JSON 已经被解析
+ * @return {string}
+ */
+export function getErrorDetails(code: number, data: unknown): string {
+  if (typeof data === "object" && data !== null && !Buffer.isBuffer(data)) {
+    const { message } = data as { message?: unknown };
+    if (typeof message === "string") return message;
+  }
+  let body =
+    typeof data === "string" || Buffer.isBuffer(data)
+      ? data.toString().trim()
+      : JSON.stringify(data ?? "");
+  if (body === "" || body === '""') {
+    return `received http ${code} with an empty body`;
+  }
+  if (body.length > MAX_ERROR_BODY_LENGTH) {
+    body = `${body.slice(0, MAX_ERROR_BODY_LENGTH)}...`;
+  }
+  return `received http ${code}: ${body}`;
+}
+
 /**
  * 转换 Metadata 到 grpc-getaway 库支持的形式
  * ! 注意此处不确定 axios node 端是否支持 Buffer 类型的 value
  * @param metadata {Metadata}
+ * @param [rule] {HeaderRule} - 不配置时和 grpc-gateway 的 DefaultHeaderMatcher 对应
  * @return {{[key: string]: string}}
  */
-export function toMetadataHeader(metadata: Metadata): Record<string, string> {
+export function toMetadataHeader(
+  metadata: Metadata,
+  rule?: HeaderRule
+): Record<string, string> {
+  const mapper = createHeaderMapper(rule, defaultRequestHeaderMatcher);
   // buffer 的 header axios 不支持吗？
   return Object.entries(metadata.getMap()).reduce(
     (headers, [key, value]) => {
-      headers[`Grpc-Metadata-${key}`] = value as unknown as string;
+      const name = mapper(key);
+      if (name) headers[name] = value as unknown as string;
       return headers;
     },
     // 必须加这个头才能接收到 trailers headers
@@ -77,17 +157,19 @@ export function toMetadataHeader(metadata: Metadata): Record<string, string> {
  * 从响应 Headers 中获取metadata
  * grpc 的 Header 和 Trailer 两种 metadata 都从这里获取。这里无法区分，所以客户端从这两个地方取的 Metadata 都会是相同的。
  * @param headers {{[key: string]: string}}
+ * @param [rule] {HeaderRule} - 不配置时只接收 grpc-metadata- 前缀的头
  * @return {Metadata}
  */
 export function getMetadataFromHeader(
-  headers: Record<string, string>
+  headers: Record<string, string>,
+  rule?: HeaderRule
 ): Metadata {
-  const kv = Object.entries(headers)
-    .filter(([value]) => value.startsWith("grpc-metadata-"))
-    .reduce((prev, [key, value]) => {
-      prev[key.replace(/^grpc-metadata-/, "")] = value as string;
-      return prev;
-    }, {} as Record<string, string>);
+  const mapper = createHeaderMapper(rule, defaultResponseHeaderMatcher);
+  const kv = Object.entries(headers).reduce((prev, [key, value]) => {
+    const name = mapper(key);
+    if (name) prev[name] = value as string;
+    return prev;
+  }, {} as Record<string, string>);
   return toMetadata(kv);
 }
 
@@ -1,9 +1,9 @@
 import bath from "bath-es5";
-import { readFileSync } from "fs";
+import { readFileSync, statSync } from "fs";
 import { resolve } from "node:path";
 import { OpenAPIV2 } from "openapi-types";
 import { AxiosRequestConfig } from "axios";
-import { readFile } from "node:fs/promises";
+import { readFile, stat } from "node:fs/promises";
 import {
   checkPathIsExist,
   checkPathIsExistSync,
@@ -64,6 +64,8 @@ export interface Operation {
   method: HttpMethod;
   operationId: string;
   parameters: OpenAPIV2.Parameter[];
+  // 响应的 schema，其中的 $ref 需要通过 getDocument(filePath) 解析
+  responses?: OpenAPIV2.ResponsesObject;
 }
 
 /**
@@ -91,6 +93,15 @@ export interface RequestID {
   method: string;
 }
 
+// 加载的 operation 和它所在的 openapi 文件
+export interface OperationEntry {
+  // 如 /example.greeter.v1.services.Greeter/SayHello
+  callPath: string;
+  operation: Operation;
+  definition: OpenAPIV2.OperationObject;
+  document: OpenAPIV2.Document;
+}
+
 export interface DocumentList {
   filePath: string;
   document: OpenAPIV2.Document;
@@ -118,11 +129,23 @@ function parseOpenApiSpecSync(url: string): OpenAPIV2.Document {
   return JSON.parse(str);
 }
 
+// 解析失败时带上文件路径
+function parseError(url: string, err: unknown): Error {
+  return new Error(`failed to parse ${url}: ${(err as Error).message}`);
+}
+
 export class OpenapiV2Parser {
+  // 是否已经加载完毕
   public loading = false;
   private documentLists: DocumentList[] = [];
   constructor(private openapiDir: string) {}
 
+  /**
+   * 加载 openapi 目录下所有的 *.swagger.json
+   * 目录不存在、不是目录、没有 openapi 文件或者文件无法解析时抛出错误
+   * @param sync {boolean}
+   * @return {void | Promise<void>}
+   */
   public init(sync: boolean): void | Promise<void> {
     if (sync) {
       this.initSync();
@@ -135,19 +158,25 @@ export class OpenapiV2Parser {
   private initSync() {
     const dirname = resolve(process.cwd(), this.openapiDir);
     if (!checkPathIsExistSync(dirname)) {
-      this.loading = false;
-      console.warn("openapi 目录不存在，请使用脚本生成！");
-      return;
+      throw new Error(`openapi directory ${dirname} does not exist`);
+    }
+    if (!statSync(dirname).isDirectory()) {
+      throw new Error(`openapi directory ${dirname} is not a directory`);
     }
+    const documentLists: DocumentList[] = [];
     forEachDirectorySync(dirname, (url: string) => {
       if (url.endsWith(".swagger.json")) {
-        this.documentLists.push({
-          filePath: url,
-          document: parseOpenApiSpecSync(url),
-        });
+        try {
+          documentLists.push({
+            filePath: url,
+            document: parseOpenApiSpecSync(url),
+          });
+        } catch (err) {
+          throw parseError(url, err);
+        }
       }
     });
-    this.loading = true;
+    this.setDocuments(dirname, documentLists);
   }
 
   // 异步加载 openapi 文件
@@ -155,19 +184,98 @@ export class OpenapiV2Parser {
     const dirname = resolve(process.cwd(), this.openapiDir);
     const isExist = await checkPathIsExist(dirname);
     if (!isExist) {
-      this.loading = false;
-      console.warn("openapi 目录不存在，请使用脚本生成！");
-      return;
+      throw new Error(`openapi directory ${dirname} does not exist`);
     }
+    if (!(await stat(dirname)).isDirectory()) {
+      throw new Error(`openapi directory ${dirname} is not a directory`);
+    }
+    const documentLists: DocumentList[] = [];
     await forEachDirectory(dirname, async (url: string) => {
       if (url.endsWith(".swagger.json")) {
-        const json = await parseOpenApiSpec(url);
-        this.documentLists.push({ filePath: url, document: json });
+        try {
+          const json = await parseOpenApiSpec(url);
+          documentLists.push({ filePath: url, document: json });
+        } catch (err) {
+          throw parseError(url, err);
+        }
       }
     });
+    this.setDocuments(dirname, documentLists);
+  }
+
+  private setDocuments(dirname: string, documentLists: DocumentList[]) {
+    if (documentLists.length === 0) {
+      throw new Error(`no *.swagger.json found in ${dirname}`);
+    }
+    this.documentLists = documentLists;
     this.loading = true;
   }
 
+  // 加载的 openapi 文件中定义的 operation 数量
+  public getRouteCount(): number {
+    let count = 0;
+    for (const { document } of this.documentLists) {
+      for (const pathItemObject of Object.values(document.paths || {})) {
+        count += Object.values(pathItemObject || {}).filter(
+          (item) => (item as OpenAPIV2.OperationObject)?.operationId
+        ).length;
+      }
+    }
+    return count;
+  }
+
+  // 加载的所有 openapi 文件
+  public getDocuments(): DocumentList[] {
+    return [...this.documentLists];
+  }
+
+  /**
+   * operation 所在的 openapi 文件，用于解析 $ref
+   * @param filePath {string}
+   * @return {OpenAPIV2.Document | null}
+   */
+  public getDocument(filePath: string): OpenAPIV2.Document | null {
+    const found = this.documentLists.find((item) => item.filePath === filePath);
+    return found ? found.document : null;
+  }
+
+  /**
+   * 所有可以代理的 operation，callPath 由 tag 和 operationId（{service}_{method}）得到
+   * @return {OperationEntry[]}
+   */
+  public getOperations(): OperationEntry[] {
+    const entries: OperationEntry[] = [];
+    for (const { filePath, document } of this.documentLists) {
+      for (const [path, pathItemObject] of Object.entries(document.paths)) {
+        for (const [method, operationObject] of Object.entries(
+          pathItemObject || {}
+        )) {
+          const definition = operationObject as OpenAPIV2.OperationObject;
+          const tag = definition?.tags?.[0];
+          if (!tag || !definition.operationId) continue;
+          const service = tag.slice(tag.lastIndexOf(".") + 1);
+          if (!definition.operationId.startsWith(`${service}_`)) continue;
+          const rpc = definition.operationId.slice(service.length + 1);
+          entries.push({
+            document,
+            definition,
+            callPath: `/${tag}/${rpc}`,
+            operation: {
+              path,
+              filePath,
+              operationId: definition.operationId,
+              method: method.toLowerCase() as HttpMethod,
+              parameters: (definition.parameters ||
+                []) as OpenAPIV2.Parameter[],
+              responses: definition.responses,
+            },
+          });
+        }
+      }
+    }
+    return entries;
+  }
+
   public getOperation(requestID: RequestID): Operation | null {
     const tag = `${requestID.package}.${requestID.service}`;
     const operationId = `${requestID.service}_${requestID.method}`;
@@ -191,6 +299,8 @@ export class OpenapiV2Parser {
               method: method.toLowerCase() as HttpMethod,
               parameters: (operationObject as OpenAPIV2.OperationObject)
                 .parameters as OpenAPIV2.Parameter[],
+              responses: (operationObject as OpenAPIV2.OperationObject)
+                .responses,
             };
           }
         }
@@ -292,6 +402,9 @@ export class OpenapiV2Parser {
     // make sure all path parameters are set
     for (const name of pathBuilder.names) {
       const value = pathParams[name];
+      if (value === undefined || value === null) {
+        throw new Error(`missing path parameter ${name}`);
+      }
       pathParams[name] = `${value}`;
     }
     const path = pathBuilder.path(pathParams) as string;
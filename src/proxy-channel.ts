@@ -0,0 +1,399 @@
+import axios, { AxiosInstance } from "axios";
+import { EventEmitter } from "node:events";
+import { isValidUrl } from "./helper";
+import { CallResult } from "./grpc-utils";
+import {
+  DecodeError,
+  errorResult,
+  ProxyError,
+  RouteNotFound,
+  settleCall,
+} from "./proxy-error";
+import {
+  getServiceUrl,
+  toCallCredentials,
+  withCallCredentials,
+} from "./call-credentials";
+import { ChannelRef } from "@grpc/grpc-js/build/src/channelz";
+import { ServerSurfaceCall } from "@grpc/grpc-js/build/src/server-call";
+import {
+  Call,
+  Deadline,
+  InterceptingListener,
+  MessageContext,
+} from "@grpc/grpc-js/build/src/call-stream";
+import {
+  CallCredentials,
+  ChannelInterface,
+  connectivityState,
+  Metadata,
+  status,
+  StatusObject,
+} from "@grpc/grpc-js";
+
+// 通过 http 完成一次调用，保证即使是内部错误，也会返回一个正确的结构
+// signal 用于取消请求（如对冲请求中失败的一方）
+export type CallHandler = (
+  callPath: string,
+  message: any,
+  metadata: Metadata,
+  signal?: AbortSignal
+) => Promise<CallResult<any>>;
+
+// 从 PackageDefinition 中取出的方法定义，只用到了序列化相关的字段
+interface MethodSerializer {
+  requestStream: boolean;
+  responseStream: boolean;
+  requestDeserialize: (value: Buffer) => any;
+  responseSerialize: (value: any) => Buffer;
+}
+
+export interface ProxyChannelOptions {
+  // grpc-gateway 服务地址，用于 getTarget 和探测连接状态
+  target: string;
+  // @grpc/proto-loader 加载的 PackageDefinition，用于消息的序列化和反序列化
+  definition: Record<string, any>;
+  handler: CallHandler;
+  // 调用过程中产生的事件，通过 Channel 的 on 方法监听
+  emitter?: EventEmitter;
+  // 探测连接状态使用的 http 客户端，需要和调用使用相同的 TLS 和代理配置
+  client?: AxiosInstance;
+  // 所有调用都会使用的凭证，和单次调用的 credentials 选项组合使用
+  credentials?: CallCredentials | null;
+  // openapi 文件的加载状态
+  ready?: () => Promise<void>;
+  // Channel 关闭时释放 http 发送层的资源，如健康检查、resolver 和连接池
+  close?: () => Promise<void>;
+}
+
+interface StateWatcher {
+  currentState: connectivityState;
+  callback: (error?: Error) => void;
+  timer?: NodeJS.Timeout;
+}
+
+/**
+ * 从 PackageDefinition 中收集所有方法的序列化方法
+ * @param definition {Record<string, any>}
+ * @return {Map<string, MethodSerializer>}
+ */
+function collectMethods(
+  definition: Record<string, any>
+): Map<string, MethodSerializer> {
+  const methods = new Map<string, MethodSerializer>();
+  for (const service of Object.values(definition)) {
+    // 只有 service 定义中的方法才会带有 path
+    if (!service || typeof service !== "object" || "format" in service) {
+      continue;
+    }
+    for (const method of Object.values(service as Record<string, any>)) {
+      if (method?.path) methods.set(method.path, method);
+    }
+  }
+  return methods;
+}
+
+/**
+ * 完全通过 http 完成调用的 Channel，不会打开到 gRPC 端口的连接
+ * 使用方式：new Client(address, credentials, { channelOverride: channel })
+ *  1. 连接状态反映 grpc-gateway 是否可以连接，调用结果也会更新连接状态
+ *  2. 不支持流式调用，会返回 UNIMPLEMENTED
+ */
+export class ProxyChannel implements ChannelInterface {
+  private state = connectivityState.IDLE;
+  private probing = false;
+  private watchers: StateWatcher[] = [];
+  private readonly methods: Map<string, MethodSerializer>;
+  private readonly channelzRef: ChannelRef;
+  private readonly emitter: EventEmitter;
+
+  constructor(private opts: ProxyChannelOptions) {
+    this.methods = collectMethods(opts.definition);
+    this.channelzRef = { kind: "channel", id: 0, name: opts.target };
+    this.emitter = opts.emitter || new EventEmitter();
+  }
+
+  public on(event: string, listener: (...args: any[]) => void): void {
+    this.emitter.on(event, listener);
+  }
+
+  public once(event: string, listener: (...args: any[]) => void): void {
+    this.emitter.once(event, listener);
+  }
+
+  public off(event: string, listener: (...args: any[]) => void): void {
+    this.emitter.off(event, listener);
+  }
+
+  public close(): void {
+    if (this.state === connectivityState.SHUTDOWN) return;
+    this.setState(connectivityState.SHUTDOWN);
+    // grpc-js 的 close 是同步的，关闭连接池的错误不影响 Channel 的状态
+    this.opts.close?.().catch(() => undefined);
+  }
+
+  // openapi 文件加载完成时 resolve，加载失败时 reject
+  public ready(): Promise<void> {
+    return this.opts.ready ? this.opts.ready() : Promise.resolve();
+  }
+
+  public getTarget(): string {
+    return this.opts.target;
+  }
+
+  public getConnectivityState(tryToConnect: boolean): connectivityState {
+    if (tryToConnect && this.state === connectivityState.IDLE) {
+      this.probe();
+    }
+    return this.state;
+  }
+
+  public watchConnectivityState(
+    currentState: connectivityState,
+    deadline: Date | number,
+    callback: (error?: Error) => void
+  ): void {
+    if (this.state === connectivityState.SHUTDOWN) {
+      throw new Error("Channel has been shut down");
+    }
+    if (currentState !== this.state) {
+      process.nextTick(callback);
+      return;
+    }
+    const watcher: StateWatcher = { currentState, callback };
+    const timeout = new Date(deadline).getTime() - Date.now();
+    if (Number.isFinite(timeout)) {
+      watcher.timer = setTimeout(() => {
+        this.watchers = this.watchers.filter((item) => item !== watcher);
+        callback(
+          new Error("Deadline passed without connectivity state change")
+        );
+      }, Math.max(timeout, 0));
+    }
+    this.watchers.push(watcher);
+  }
+
+  public getChannelzRef(): ChannelRef {
+    return this.channelzRef;
+  }
+
+  public createCall(
+    method: string,
+    deadline: Deadline,
+    host: string | null | undefined,
+    parentCall: ServerSurfaceCall | null,
+    propagateFlags: number | null | undefined
+  ): Call {
+    if (this.state === connectivityState.SHUTDOWN) {
+      throw new Error("Channel has been shut down");
+    }
+    return new ProxyCall(this, method, deadline, host || this.opts.target);
+  }
+
+  /**
+   * 由 ProxyCall 调用，执行 http 请求并根据结果更新连接状态
+   * @param callPath {string}
+   * @param message {Buffer}
+   * @param metadata {Metadata}
+   * @param [credentials] {CallCredentials} - 单次调用的凭证
+   * @param [host] {string}
+   * @param [signal] {AbortSignal} - 调用取消或者超时时中止 http 请求
+   * @return {Promise<CallResult<Buffer>>}
+   */
+  public async invoke(
+    callPath: string,
+    message: Buffer | null,
+    metadata: Metadata,
+    credentials?: CallCredentials,
+    host: string = this.opts.target,
+    signal?: AbortSignal
+  ): Promise<CallResult<Buffer | null>> {
+    const method = this.methods.get(callPath);
+    if (!method) {
+      return errorResult(
+        new RouteNotFound(`no method definition found for ${callPath}`)
+      );
+    }
+    if (method.requestStream || method.responseStream) {
+      return errorResult(
+        new ProxyError(
+          status.UNIMPLEMENTED,
+          `${callPath}: streaming calls are not supported`
+        )
+      );
+    }
+    if (message === null) {
+      return errorResult(
+        new ProxyError(status.INTERNAL, `${callPath}: no request message sent`)
+      );
+    }
+    this.setState(connectivityState.CONNECTING, connectivityState.IDLE);
+    const handler = withCallCredentials(
+      (request, md, sig) => this.opts.handler(callPath, request, md, sig),
+      toCallCredentials(this.opts.credentials, credentials),
+      getServiceUrl(host, callPath)
+    );
+    let request: unknown;
+    try {
+      request = method.requestDeserialize(message);
+    } catch (err) {
+      return errorResult(
+        new DecodeError(`${callPath}: failed to decode request message`, err)
+      );
+    }
+    const result = await settleCall(() => handler(request, metadata, signal));
+    // 没有收到 http 响应或者 grpc-gateway 无法连接到后端时认为 Channel 不可用
+    this.setState(
+      result.status.code === status.UNAVAILABLE
+        ? connectivityState.TRANSIENT_FAILURE
+        : connectivityState.READY
+    );
+    if (result.status.code !== status.OK) {
+      return { ...result, response: null };
+    }
+    try {
+      return {
+        ...result,
+        response: method.responseSerialize(result.response),
+      };
+    } catch (err) {
+      return errorResult(
+        new DecodeError(`${callPath}: failed to encode response message`, err)
+      );
+    }
+  }
+
+  // 只要 grpc-gateway 有 http 响应（包括 404）就认为是可以连接的
+  // target 不是一个有效的 URL 时（如 getaway 是函数）只根据调用结果更新连接状态
+  private probe() {
+    if (!isValidUrl(this.opts.target)) {
+      this.setState(connectivityState.READY);
+      return;
+    }
+    if (this.probing) return;
+    this.probing = true;
+    this.setState(connectivityState.CONNECTING);
+    (this.opts.client || axios)
+      .get(this.opts.target, { validateStatus: () => true })
+      .then(
+        () => this.setState(connectivityState.READY),
+        () => this.setState(connectivityState.TRANSIENT_FAILURE)
+      )
+      .finally(() => (this.probing = false));
+  }
+
+  // from 不为空时只有当前状态是 from 才会更新
+  private setState(next: connectivityState, from?: connectivityState) {
+    if (this.state === connectivityState.SHUTDOWN || this.state === next) {
+      return;
+    }
+    if (from !== undefined && this.state !== from) {
+      return;
+    }
+    this.state = next;
+    const watchers = this.watchers.filter(
+      (watcher) => watcher.currentState !== next
+    );
+    this.watchers = this.watchers.filter(
+      (watcher) => watcher.currentState === next
+    );
+    for (const watcher of watchers) {
+      if (watcher.timer) clearTimeout(watcher.timer);
+      watcher.callback();
+    }
+  }
+}
+
+// ProxyChannel 创建的调用，收到 halfClose 后通过 http 发送请求
+class ProxyCall implements Call {
+  private message: Buffer | null = null;
+  private metadata = new Metadata();
+  private listener: InterceptingListener | null = null;
+  private credentials = CallCredentials.createEmpty();
+  private finished = false;
+  private timer?: NodeJS.Timeout;
+  // 取消和超时时中止 http 请求，包括重试和对冲发送的请求
+  private readonly controller = new AbortController();
+
+  constructor(
+    private channel: ProxyChannel,
+    private method: string,
+    private deadline: Deadline,
+    private host: string
+  ) {}
+
+  public cancelWithStatus(code: status, details: string): void {
+    this.finish({ code, details, metadata: new Metadata() });
+    this.controller.abort();
+  }
+
+  public getPeer(): string {
+    return this.channel.getTarget();
+  }
+
+  public start(metadata: Metadata, listener: InterceptingListener): void {
+    this.metadata = metadata;
+    this.listener = listener;
+    const timeout = new Date(this.deadline).getTime() - Date.now();
+    if (Number.isFinite(timeout)) {
+      this.timer = setTimeout(() => {
+        this.cancelWithStatus(status.DEADLINE_EXCEEDED, "Deadline exceeded");
+      }, Math.max(timeout, 0));
+    }
+  }
+
+  public sendMessageWithContext(context: MessageContext, message: Buffer) {
+    this.message = message;
+    context.callback?.();
+  }
+
+  public startRead(): void {}
+
+  public halfClose(): void {
+    this.channel
+      .invoke(
+        this.method,
+        this.message,
+        this.metadata,
+        this.credentials,
+        this.host,
+        this.controller.signal
+      )
+      .then((result) => {
+        if (this.finished) return;
+        // 调用失败时不会返回消息
+        this.listener?.onReceiveMetadata(result.metadata);
+        if (result.response !== null) {
+          this.listener?.onReceiveMessage(result.response);
+        }
+        this.finish(result.status);
+      });
+  }
+
+  public getDeadline(): Deadline {
+    return this.deadline;
+  }
+
+  public getCredentials(): CallCredentials {
+    return this.credentials;
+  }
+
+  public setCredentials(credentials: CallCredentials): void {
+    this.credentials = credentials;
+  }
+
+  public getMethod(): string {
+    return this.method;
+  }
+
+  public getHost(): string {
+    return this.host;
+  }
+
+  private finish(st: StatusObject) {
+    if (this.finished) return;
+    this.finished = true;
+    if (this.timer) clearTimeout(this.timer);
+    this.listener?.onReceiveStatus(st);
+  }
+}
@@ -0,0 +1,102 @@
+import { Metadata, status } from "@grpc/grpc-js";
+import type { CallResult } from "./grpc-utils";
+import type { SchemaViolation } from "./openapi-schema";
+
+/**
+ * 代理调用过程中产生的错误，总是会被转换成对应 code 的 grpc 状态而不是抛出
+ * cause 是原始的错误，只用于日志，不会发送给调用方
+ */
+export class ProxyError extends Error {
+  constructor(
+    public readonly code: status,
+    message: string,
+    public readonly cause?: unknown
+  ) {
+    super(message);
+    this.name = new.target.name;
+  }
+}
+
+// callPath 无法解析或者 openapi 文件中没有对应的定义
+export class RouteNotFound extends ProxyError {
+  constructor(message: string, cause?: unknown) {
+    super(status.UNIMPLEMENTED, message, cause);
+  }
+}
+
+// 请求消息无法转换成 http 请求，如缺少 path 参数
+export class TranscodeError extends ProxyError {
+  constructor(message: string, cause?: unknown) {
+    super(status.INVALID_ARGUMENT, message, cause);
+  }
+}
+
+// 没有收到 http 响应，如 grpc-gateway 无法连接、地址解析失败
+export class TransportError extends ProxyError {
+  constructor(message: string, cause?: unknown) {
+    super(status.UNAVAILABLE, message, cause);
+  }
+}
+
+// 收到的响应无法转换成 grpc 的响应消息
+export class DecodeError extends ProxyError {
+  constructor(message: string, cause?: unknown) {
+    super(status.INTERNAL, message, cause);
+  }
+}
+
+// 消息不符合 openapi 中的 schema，请求是 INVALID_ARGUMENT，响应是 INTERNAL
+export class ValidationError extends ProxyError {
+  constructor(
+    code: status,
+    message: string,
+    public readonly violations: SchemaViolation[]
+  ) {
+    super(code, message);
+  }
+}
+
+/**
+ * 转换成 ProxyError，未知的错误作为 INTERNAL
+ * @param err {unknown}
+ * @return {ProxyError}
+ */
+export function toProxyError(err: unknown): ProxyError {
+  if (err instanceof ProxyError) return err;
+  const message = err instanceof Error ? err.message : String(err);
+  return new ProxyError(status.INTERNAL, `proxy call failed: ${message}`, err);
+}
+
+/**
+ * 错误对应的调用结果，error 中保留了原始的错误
+ * @param err {unknown}
+ * @return {CallResult<any>}
+ */
+export function errorResult(err: unknown): CallResult<any> {
+  const error = toProxyError(err);
+  return {
+    error,
+    response: null,
+    metadata: new Metadata(),
+    status: {
+      code: error.code,
+      details: error.message,
+      metadata: new Metadata(),
+    },
+  };
+}
+
+/**
+ * 调用 handler，抛出的错误和 rejected 的 Promise 都会转换成调用结果
+ * @param call {() => Promise<CallResult<T>>}
+ * @return {Promise<CallResult<T>>}
+ */
+export async function settleCall<T>(
+  call: () => Promise<CallResult<T>>
+): Promise<CallResult<T>> {
+  try {
+    return await call();
+  } catch (err) {
+    return errorResult(err);
+  }
+}
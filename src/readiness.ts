@@ -0,0 +1,120 @@
+import { EventEmitter } from "node:events";
+import { status } from "@grpc/grpc-js";
+import { errorResult, ProxyError } from "./proxy-error";
+import { ProxyHandler } from "./interceptor-call";
+import { consoleLogger, Logging } from "./logger";
+
+export type ReadyState = "loading" | "ready" | "failed";
+
+// 调用等待 openapi 文件加载完成的默认时间，单位毫秒
+export const DEFAULT_READY_TIMEOUT = 10000;
+
+/**
+ * 记录 openapi 文件的加载状态
+ * 加载完成时触发 ready 事件，失败时触发 error 事件，没有监听 error 事件时输出 error 日志
+ */
+export class Readiness {
+  private state: ReadyState = "loading";
+  private error: Error | null = null;
+  private readonly settled: Promise<void>;
+
+  private readonly logging: Logging;
+
+  constructor(
+    load: () => void | Promise<void>,
+    private emitter: EventEmitter,
+    logging?: Logging | null
+  ) {
+    this.logging = logging || new Logging(consoleLogger(), "grpc-gateway");
+    let loading: void | Promise<void>;
+    try {
+      loading = load();
+    } catch (err) {
+      // 同步加载抛出的错误也按失败处理
+      loading = Promise.reject(err);
+    }
+    this.settled = Promise.resolve(loading).then(
+      () => this.settle(null),
+      (err) => this.settle(err)
+    );
+  }
+
+  private settle(err: Error | null) {
+    this.error = err;
+    this.state = err ? "failed" : "ready";
+    if (!err) {
+      this.emitter.emit("ready");
+    } else if (this.emitter.listenerCount("error") > 0) {
+      this.emitter.emit("error", err);
+    } else {
+      this.logging.log(
+        "error",
+        `openapi files failed to load: ${err.message}`,
+        { error: err }
+      );
+    }
+  }
+
+  public getState(): ReadyState {
+    return this.state;
+  }
+
+  // 加载结束（无论成功失败）时 resolve
+  public whenSettled(): Promise<void> {
+    return this.settled;
+  }
+
+  /**
+   * 加载完成时 resolve，失败时 reject
+   * @return {Promise<void>}
+   */
+  public ready(): Promise<void> {
+    return this.settled.then(() => {
+      if (this.error) throw this.error;
+    });
+  }
+
+  /**
+   * 调用之前等待加载完成
+   *  1. 加载失败返回 FAILED_PRECONDITION
+   *  2. timeout 毫秒之后还没有加载完成返回 UNAVAILABLE
+   * @param handler {ProxyHandler}
+   * @param [timeout] {number}
+   * @return {ProxyHandler}
+   */
+  public wrap(
+    handler: ProxyHandler,
+    timeout: number = DEFAULT_READY_TIMEOUT
+  ): ProxyHandler {
+    return async (message, metadata, signal) => {
+      if (this.state === "loading") {
+        let timer: NodeJS.Timeout | undefined;
+        await Promise.race([
+          this.settled,
+          new Promise<void>((resolve) => {
+            timer = setTimeout(resolve, timeout);
+          }),
+        ]);
+        clearTimeout(timer);
+      }
+      if (this.state === "failed") {
+        return errorResult(
+          new ProxyError(
+            status.FAILED_PRECONDITION,
+            `openapi files failed to load: ${(this.error as Error).message}`,
+            this.error
+          )
+        );
+      }
+      if (this.state === "loading") {
+        return errorResult(
+          new ProxyError(
+            status.UNAVAILABLE,
+            `openapi files are still loading after ${timeout}ms`
+          )
+        );
+      }
+      return handler(message, metadata, signal);
+    };
+  }
+}
@@ -0,0 +1,315 @@
+import { createHash } from "node:crypto";
+import { mkdirSync, readFileSync, writeFileSync } from "fs";
+import { dirname, resolve } from "node:path";
+import axios from "axios";
+import type { ProxyRequest } from "./http-transport";
+import { DEFAULT_REDACTED_HEADERS, redactHeaders } from "./logger";
+import { METADATA_HEADER_PREFIX } from "./header-matcher";
+import { checkPathIsExistSync, forEachDirectorySync } from "./helper";
+import {
+  HttpClient,
+  HttpClientAdapter,
+  HttpResponse,
+  toHttpClientAdapter,
+} from "./http-client";
+
+// 一次录制的 http 请求和响应，保存为 {dir}/{service}/{method}/{key}.json
+export interface Fixture {
+  callPath: string;
+  // 标准化之后的请求的 hash，见 fixtureKey
+  key: string;
+  // 只用于查看，头中的敏感信息已经隐藏
+  request: {
+    method: string;
+    url: string;
+    query: string;
+    headers: Record<string, unknown>;
+    body: unknown;
+  };
+  response: HttpResponse;
+}
+
+// strict：只使用 key 相同的 fixture
+// lenient：没有 key 相同的 fixture 时使用同一个 callPath 下 method 和 path 相同的 fixture
+export type MatchMode = "strict" | "lenient";
+
+// 回放时没有 key 相同的 fixture 的调用
+export interface UnmatchedCall {
+  callPath: string;
+  key: string;
+  method: string;
+  url: string;
+  // lenient 模式下找到了可以代替的 fixture
+  served: boolean;
+}
+
+export interface ReplayReport {
+  // key 相同的调用数量
+  matched: number;
+  unmatched: UnmatchedCall[];
+}
+
+interface NormalizedRequest {
+  method: string;
+  path: string;
+  query: string;
+  body: unknown;
+}
+
+// 参与匹配的头：Grpc-Metadata-* 和 keyHeaders 中的头，token、cookie 等每次可能不同的头除外
+function keyedHeaders(
+  request: ProxyRequest,
+  keyHeaders: string[]
+): Array<[string, string]> {
+  const raw = request.config.headers as any;
+  const headers: Record<string, unknown> =
+    typeof raw?.toJSON === "function" ? raw.toJSON() : raw || {};
+  const prefix = METADATA_HEADER_PREFIX.toLowerCase();
+  const extra = new Set(keyHeaders.map((name) => name.toLowerCase()));
+  const result: Array<[string, string]> = [];
+  for (const [key, value] of Object.entries(headers)) {
+    const name = key.toLowerCase();
+    const metadata = name.startsWith(prefix) ? name.slice(prefix.length) : "";
+    if (!extra.has(name) && !metadata) continue;
+    if (DEFAULT_REDACTED_HEADERS.includes(metadata || name)) continue;
+    if (value === undefined || value === null) continue;
+    result.push([name, Array.isArray(value) ? value.join(",") : String(value)]);
+  }
+  return result.sort(([a], [b]) => (a < b ? -1 : a > b ? 1 : 0));
+}
+
+// 对象的 key 按字典序排列，相同内容的 JSON 总是得到相同的字符串
+function canonicalize(value: unknown): unknown {
+  if (Array.isArray(value)) return value.map(canonicalize);
+  if (value && typeof value === "object" && !Buffer.isBuffer(value)) {
+    return Object.keys(value)
+      .sort()
+      .reduce((result, key) => {
+        result[key] = canonicalize((value as Record<string, unknown>)[key]);
+        return result;
+      }, {} as Record<string, unknown>);
+  }
+  return value;
+}
+
+// 请求体是 JSON 字符串时按对象处理，和直接传入对象得到相同的结果
+function normalizeBody(data: unknown): unknown {
+  if (Buffer.isBuffer(data)) return data.toString("base64");
+  if (typeof data !== "string") return canonicalize(data ?? null);
+  try {
+    return canonicalize(JSON.parse(data));
+  } catch (err) {
+    return data;
+  }
+}
+
+function normalizeRequest(request: ProxyRequest): NormalizedRequest {
+  const { config } = request;
+  const [path, search = ""] = (config.url || "").split("?");
+  const query = new URLSearchParams(search);
+  if (config.params) {
+    new URLSearchParams(config.params).forEach((value, name) =>
+      query.append(name, value)
+    );
+  }
+  query.sort();
+  return {
+    path,
+    query: query.toString(),
+    method: (config.method || "get").toUpperCase(),
+    body: normalizeBody(config.data),
+  };
+}
+
+/**
+ * 请求的 key：method、path、排序后的 query、key 排序后的请求体和 metadata 头的 sha256 的前 16 位
+ *  1. metadata 头是 Grpc-Metadata-* 和 keyHeaders 中的头，没有时 key 和只有请求体时相同
+ *  2. grpc-gateway 的地址和其他头不参与计算，签名、链路头等每次都不同的头不会影响匹配
+ * @param request {ProxyRequest}
+ * @param [keyHeaders] {string[]} - 自定义 HeaderMatcher 转换出的 metadata 头
+ * @return {string}
+ */
+export function fixtureKey(
+  request: ProxyRequest,
+  keyHeaders: string[] = []
+): string {
+  const { method, path, query, body } = normalizeRequest(request);
+  const headers = keyedHeaders(request, keyHeaders);
+  const parts: unknown[] = [method, path, query, body];
+  if (headers.length > 0) parts.push(headers);
+  return createHash("sha256")
+    .update(JSON.stringify(parts))
+    .digest("hex")
+    .slice(0, 16);
+}
+
+/**
+ * fixture 文件的路径，callPath 中不能作为文件名的字符替换成 _
+ * @param dir {string}
+ * @param callPath {string}
+ * @param key {string}
+ * @return {string}
+ */
+export function fixturePath(dir: string, callPath: string, key: string) {
+  const parts = callPath
+    .split("/")
+    .filter(Boolean)
+    .map((part) => part.replace(/[^\w.-]/g, "_").replace(/^\.+$/, "_"));
+  return resolve(dir, ...parts, `${key}.json`);
+}
+
+export interface RecordClientOptions {
+  // fixture 保存的目录
+  dir: string;
+  // 实际发送请求的 http 客户端，默认 axios.create()
+  client?: HttpClient;
+  // fixture 中隐藏值的头，默认 DEFAULT_REDACTED_HEADERS
+  redactedHeaders?: string[];
+  // 除了 Grpc-Metadata-* 之外参与匹配的头，见 fixtureKey
+  keyHeaders?: string[];
+}
+
+/**
+ * 发送请求并把每一次收到的响应保存成 fixture，key 相同的 fixture 会被覆盖
+ * 没有收到响应的请求不会被保存
+ */
+export class RecordClient implements HttpClientAdapter {
+  private readonly client: HttpClientAdapter;
+
+  constructor(private opts: RecordClientOptions) {
+    this.client = toHttpClientAdapter(opts.client || axios.create());
+  }
+
+  public async send(request: ProxyRequest): Promise<HttpResponse> {
+    const response = await this.client.send(request);
+    const { method, path, query, body } = normalizeRequest(request);
+    const redacted = this.opts.redactedHeaders || DEFAULT_REDACTED_HEADERS;
+    const key = fixtureKey(request, this.opts.keyHeaders);
+    const headers = redactHeaders(response.headers, redacted);
+    const fixture: Fixture = {
+      key,
+      callPath: request.callPath,
+      request: {
+        method,
+        query,
+        body,
+        url: path,
+        headers: redactHeaders(request.config.headers, redacted),
+      },
+      response: {
+        status: response.status,
+        headers: headers as Record<string, string>,
+        data: response.data,
+        rawTrailers: response.rawTrailers || [],
+      },
+    };
+    const file = fixturePath(this.opts.dir, request.callPath, key);
+    mkdirSync(dirname(file), { recursive: true });
+    writeFileSync(file, `${JSON.stringify(fixture, null, 2)}\n`);
+    return response;
+  }
+
+  public close() {
+    return this.client.close?.();
+  }
+}
+
+export interface ReplayClientOptions {
+  // fixture 所在的目录
+  dir: string;
+  // 默认 strict
+  match?: MatchMode;
+  // 和录制时相同
+  keyHeaders?: string[];
+}
+
+/**
+ * 只使用 fixture 返回响应，不会发送任何请求
+ * 没有匹配的 fixture 时抛出错误，调用返回 UNAVAILABLE，可以通过 report() 获取这些调用
+ */
+export class ReplayClient implements HttpClientAdapter {
+  private fixtures: Fixture[] | null = null;
+  private matched = 0;
+  private readonly unmatched: UnmatchedCall[] = [];
+
+  constructor(private opts: ReplayClientOptions) {}
+
+  // 第一次发送时加载，按文件路径排序，lenient 模式总是选择同一个 fixture
+  private getFixtures(): Fixture[] {
+    if (this.fixtures) return this.fixtures;
+    const files: string[] = [];
+    if (checkPathIsExistSync(this.opts.dir)) {
+      forEachDirectorySync(this.opts.dir, (file) => {
+        if (file.endsWith(".json")) files.push(file);
+      });
+    }
+    this.fixtures = files
+      .sort()
+      .map((file) => JSON.parse(readFileSync(file, "utf8")) as Fixture);
+    return this.fixtures;
+  }
+
+  private find(request: ProxyRequest, key: string): Fixture | null {
+    const fixtures = this.getFixtures().filter(
+      (fixture) => fixture.callPath === request.callPath
+    );
+    const exact = fixtures.find((fixture) => fixture.key === key);
+    if (exact) {
+      this.matched++;
+      return exact;
+    }
+    const { method, path } = normalizeRequest(request);
+    const similar =
+      this.opts.match === "lenient"
+        ? fixtures.find(
+            (fixture) =>
+              fixture.request.method === method && fixture.request.url === path
+          )
+        : undefined;
+    this.unmatched.push({
+      key,
+      method,
+      url: path,
+      callPath: request.callPath,
+      served: Boolean(similar),
+    });
+    return similar || null;
+  }
+
+  public async send(request: ProxyRequest): Promise<HttpResponse> {
+    const key = fixtureKey(request, this.opts.keyHeaders);
+    const fixture = this.find(request, key);
+    if (!fixture) {
+      throw new Error(`no fixture matches ${request.callPath} (key ${key})`);
+    }
+    const { response } = fixture;
+    // 每次返回新的对象，调用方修改响应不会影响下一次回放
+    return JSON.parse(JSON.stringify(response));
+  }
+
+  /**
+   * 回放的结果，用于检查 fixture 是否需要重新录制
+   * @return {ReplayReport}
+   */
+  public report(): ReplayReport {
+    return { matched: this.matched, unmatched: [...this.unmatched] };
+  }
+}
+
+/**
+ * 录制每一次 http 交互的客户端，作为 httpClient 选项使用
+ * @param opts {RecordClientOptions}
+ * @return {RecordClient}
+ */
+export function recordClient(opts: RecordClientOptions): RecordClient {
+  return new RecordClient(opts);
+}
+
+/**
+ * 从 fixture 回放的客户端，作为 httpClient 选项使用
+ * @param opts {ReplayClientOptions}
+ * @return {ReplayClient}
+ */
+export function replayClient(opts: ReplayClientOptions): ReplayClient {
+  return new ReplayClient(opts);
+}
@@ -0,0 +1,260 @@
+import { createHash, createHmac } from "node:crypto";
+import { status } from "@grpc/grpc-js";
+import { toHttpRequest } from "./http-client";
+import { TransportMiddleware } from "./http-transport";
+import { errorResult, ProxyError } from "./proxy-error";
+
+// 交给签名函数的最终请求，和实际发送的内容完全一致
+export interface SignableRequest {
+  callPath: string;
+  // 大写的 http 方法，如：GET、POST
+  method: string;
+  // 拼接了 baseUrl 和 query 的完整地址
+  url: string;
+  headers: Record<string, string>;
+  // 没有请求体时为空字符串
+  body: string | Buffer;
+}
+
+// 返回需要添加到请求上的头，同名的头会被覆盖
+export type RequestSigner = (
+  request: SignableRequest
+) =>
+  | Record<string, string>
+  | void
+  | Promise<Record<string, string> | void>;
+
+export interface HmacSignerOptions {
+  keyId: string;
+  secret: string | Buffer;
+  // 签名头的名称，默认 X-Signature
+  signatureHeader?: string;
+  // 时间戳（秒）头的名称，默认 X-Timestamp
+  timestampHeader?: string;
+  // 请求体 sha256 头的名称，默认 X-Content-Sha256
+  contentHashHeader?: string;
+  // 当前时间，单位毫秒，测试时可以固定
+  now?: () => number;
+}
+
+export interface SigV4SignerOptions {
+  region: string;
+  // 服务名，API Gateway 为 execute-api
+  service: string;
+  accessKeyId: string;
+  secretAccessKey: string;
+  sessionToken?: string;
+  // 是否发送 X-Amz-Content-Sha256 头，S3 需要，默认 false
+  signContentHash?: boolean;
+  // 路径是否需要再编码一次，除了 S3 都需要，默认 true
+  doubleEncodePath?: boolean;
+  // 当前时间，单位毫秒，测试时可以固定
+  now?: () => number;
+}
+
+function sha256Hex(data: string | Buffer): string {
+  return createHash("sha256").update(data).digest("hex");
+}
+
+function hmac(key: string | Buffer, data: string): Buffer {
+  return createHmac("sha256", key).update(data).digest();
+}
+
+// RFC 3986 的编码，encodeURIComponent 不会编码 !'()*
+function encodeRfc3986(value: string): string {
+  return encodeURIComponent(value).replace(
+    /[!'()*]/g,
+    (c) => `%${c.charCodeAt(0).toString(16).toUpperCase()}`
+  );
+}
+
+// 兼容没有正确编码的 %
+function safeDecode(value: string): string {
+  try {
+    return decodeURIComponent(value);
+  } catch (err) {
+    return value;
+  }
+}
+
+/**
+ * 按 key、value 排序并重新编码的 query
+ * @param search {string} - URL 的 search 部分
+ * @return {string}
+ */
+export function canonicalQuery(search: string): string {
+  return search
+    .replace(/^\?/, "")
+    .split("&")
+    .filter(Boolean)
+    .map((pair) => {
+      const index = pair.indexOf("=");
+      const key = index === -1 ? pair : pair.slice(0, index);
+      const value = index === -1 ? "" : pair.slice(index + 1);
+      return [
+        encodeRfc3986(safeDecode(key.replace(/\+/g, " "))),
+        encodeRfc3986(safeDecode(value.replace(/\+/g, " "))),
+      ];
+    })
+    .sort(([k1, v1], [k2, v2]) =>
+      k1 === k2 ? (v1 < v2 ? -1 : v1 > v2 ? 1 : 0) : k1 < k2 ? -1 : 1
+    )
+    .map(([key, value]) => `${key}=${value}`)
+    .join("&");
+}
+
+/**
+ * HMAC-SHA256 签名的原文：method、path、排序后的 query、请求体的 sha256 和时间戳，用换行拼接
+ * 服务端可以使用相同的方法校验签名
+ * @param request {SignableRequest}
+ * @param timestamp {string}
+ * @return {string}
+ */
+export function hmacStringToSign(
+  request: SignableRequest,
+  timestamp: string
+): string {
+  const url = new URL(request.url);
+  return [
+    request.method.toUpperCase(),
+    url.pathname || "/",
+    canonicalQuery(url.search),
+    sha256Hex(request.body),
+    timestamp,
+  ].join("\n");
+}
+
+/**
+ * HMAC-SHA256 签名，添加的头：
+ *  - X-Timestamp：签名时间，单位秒
+ *  - X-Content-Sha256：请求体的 sha256
+ *  - X-Signature：HMAC-SHA256 KeyId={keyId}, Signature={hex}
+ * @param opts {HmacSignerOptions}
+ * @return {RequestSigner}
+ */
+export function hmacSigner(opts: HmacSignerOptions): RequestSigner {
+  const now = opts.now || Date.now;
+  return (request) => {
+    const timestamp = String(Math.floor(now() / 1000));
+    const signature = createHmac("sha256", opts.secret)
+      .update(hmacStringToSign(request, timestamp))
+      .digest("hex");
+    return {
+      [opts.timestampHeader || "X-Timestamp"]: timestamp,
+      [opts.contentHashHeader || "X-Content-Sha256"]: sha256Hex(request.body),
+      [opts.signatureHeader || "X-Signature"]:
+        `HMAC-SHA256 KeyId=${opts.keyId}, Signature=${signature}`,
+    };
+  };
+}
+
+// 20150830T123600Z
+function amzDate(time: number): string {
+  return new Date(time).toISOString().replace(/[-:]|\.\d{3}/g, "");
+}
+
+function canonicalUri(pathname: string, doubleEncode: boolean): string {
+  const path = pathname
+    .split("/")
+    .map((segment) => {
+      const encoded = encodeRfc3986(safeDecode(segment));
+      return doubleEncode ? encodeRfc3986(encoded) : encoded;
+    })
+    .join("/");
+  return path || "/";
+}
+
+/**
+ * AWS Signature Version 4 签名，只签名 host、content-type 和 x-amz-* 头，其他的头可能会被代理修改
+ * 添加的头：X-Amz-Date、Authorization，以及可选的 X-Amz-Security-Token、X-Amz-Content-Sha256
+ * @param opts {SigV4SignerOptions}
+ * @return {RequestSigner}
+ */
+export function sigV4Signer(opts: SigV4SignerOptions): RequestSigner {
+  const now = opts.now || Date.now;
+  return (request) => {
+    const url = new URL(request.url);
+    const datetime = amzDate(now());
+    const date = datetime.slice(0, 8);
+    const payloadHash = sha256Hex(request.body);
+    const added: Record<string, string> = { "X-Amz-Date": datetime };
+    if (opts.sessionToken) added["X-Amz-Security-Token"] = opts.sessionToken;
+    if (opts.signContentHash) added["X-Amz-Content-Sha256"] = payloadHash;
+
+    const signing = new Map<string, string>([["host", url.host]]);
+    Object.entries({ ...request.headers, ...added }).forEach(([key, value]) => {
+      const name = key.toLowerCase();
+      if (name === "content-type" || name.startsWith("x-amz-")) {
+        signing.set(name, String(value).trim().replace(/\s+/g, " "));
+      }
+    });
+    const names = Array.from(signing.keys()).sort();
+    const signedHeaders = names.join(";");
+    const canonicalRequest = [
+      request.method.toUpperCase(),
+      canonicalUri(url.pathname, opts.doubleEncodePath ?? true),
+      canonicalQuery(url.search),
+      names.map((name) => `${name}:${signing.get(name)}\n`).join(""),
+      signedHeaders,
+      payloadHash,
+    ].join("\n");
+    const scope = `${date}/${opts.region}/${opts.service}/aws4_request`;
+    const stringToSign = [
+      "AWS4-HMAC-SHA256",
+      datetime,
+      scope,
+      sha256Hex(canonicalRequest),
+    ].join("\n");
+    const key = [date, opts.region, opts.service, "aws4_request"].reduce<
+      string | Buffer
+    >((prev, item) => hmac(prev, item), `AWS4${opts.secretAccessKey}`);
+    const signature = createHmac("sha256", key)
+      .update(stringToSign)
+      .digest("hex");
+    added.Authorization =
+      `AWS4-HMAC-SHA256 Credential=${opts.accessKeyId}/${scope}, ` +
+      `SignedHeaders=${signedHeaders}, Signature=${signature}`;
+    return added;
+  };
+}
+
+/**
+ * 签名的 middleware，放在最后执行，签名的是 http 客户端最终发送的地址、头和请求体
+ * 请求会被改写成已经拼接好 query、序列化好请求体的形式，保证发送的内容和签名的一致
+ * @param signer {RequestSigner}
+ * @return {TransportMiddleware}
+ */
+export function signingMiddleware(signer: RequestSigner): TransportMiddleware {
+  return async (request, next) => {
+    const { url, method, headers, body } = toHttpRequest(request);
+    let signed: Record<string, string> | void;
+    try {
+      signed = await signer({
+        url,
+        method,
+        headers,
+        body: body ?? "",
+        callPath: request.callPath,
+      });
+    } catch (err) {
+      return errorResult(
+        new ProxyError(
+          status.INTERNAL,
+          `failed to sign request: ${(err as Error).message}`,
+          err
+        )
+      );
+    }
+    return next({
+      ...request,
+      config: {
+        ...request.config,
+        url,
+        data: body,
+        params: undefined,
+        paramsSerializer: undefined,
+        headers: { ...headers, ...signed },
+      },
+    });
+  };
+}
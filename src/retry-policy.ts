@@ -0,0 +1,217 @@
+import { CallResult } from "./grpc-utils";
+import { ChannelOptions, status } from "@grpc/grpc-js";
+import { ProxyHandler } from "./interceptor-call";
+
+// service config 中的 retryPolicy，和 grpc-js 的格式相同
+// See: https://github.com/grpc/proposal/blob/master/A6-client-retries.md
+export interface RetryPolicy {
+  maxAttempts: number;
+  // 带单位 s 的字符串，如："0.1s"
+  initialBackoff: string;
+  maxBackoff: string;
+  backoffMultiplier: number;
+  // 状态码的名称或数字，如：["UNAVAILABLE", 14]
+  retryableStatusCodes: Array<string | number>;
+}
+
+// service config 中的 hedgingPolicy，和 retryPolicy 不能同时配置
+export interface HedgingPolicy {
+  maxAttempts: number;
+  // 带单位 s 的字符串，如："0.1s"，默认为 "0s"
+  hedgingDelay?: string;
+  // 收到这些状态码时会立即发送下一个请求，其他状态码会直接作为结果返回
+  nonFatalStatusCodes?: Array<string | number>;
+}
+
+interface MethodConfig {
+  name?: Array<{ service?: string; method?: string }>;
+  retryPolicy?: RetryPolicy;
+  hedgingPolicy?: HedgingPolicy;
+}
+
+export interface ServiceConfig {
+  methodConfig?: MethodConfig[];
+}
+
+// grpc 规定的最大尝试次数
+export const MAX_ATTEMPTS = 5;
+
+/**
+ * 解析 service config 中的时长，如："0.1s"
+ * @param duration {string}
+ * @return {number} - 毫秒
+ */
+export function parseDuration(duration: string): number {
+  const match = /^(\d+(?:\.\d+)?)s$/.exec(duration);
+  if (!match) throw new Error(`Invalid duration: ${duration}`);
+  return Number(match[1]) * 1000;
+}
+
+export function toStatusCode(code: string | number): number {
+  if (typeof code === "number") return code;
+  return status[code.toUpperCase() as keyof typeof status] as number;
+}
+
+/**
+ * 解析 channel options 中的 grpc.service_config，不是合法的 JSON 时抛出错误
+ * @param channelOptions {ChannelOptions | undefined}
+ * @return {ServiceConfig | null}
+ */
+export function parseServiceConfig(
+  channelOptions: ChannelOptions | undefined
+): ServiceConfig | null {
+  const raw = channelOptions?.["grpc.service_config"];
+  if (typeof raw !== "string" || raw === "") return null;
+  try {
+    return JSON.parse(raw) as ServiceConfig;
+  } catch (err) {
+    throw new Error(`Invalid grpc.service_config: ${(err as Error).message}`);
+  }
+}
+
+/**
+ * 读取 callPath 对应的 methodConfig 中的配置
+ * 按照 grpc 的规则匹配：service + method > service > 空
+ * @param config {ServiceConfig | null}
+ * @param callPath {string} - 如：/example.greeter.v1.services.Greeter/SayHello
+ * @param key {"retryPolicy" | "hedgingPolicy"}
+ * @return {RetryPolicy | HedgingPolicy | null}
+ */
+export function getMethodPolicy<K extends "retryPolicy" | "hedgingPolicy">(
+  config: ServiceConfig | null,
+  callPath: string,
+  key: K
+): NonNullable<MethodConfig[K]> | null {
+  if (!config) return null;
+  const [service, method] = callPath.slice(1).split("/");
+  let matched: { config: MethodConfig; score: number } | null = null;
+  for (const methodConfig of config.methodConfig || []) {
+    for (const name of methodConfig.name || []) {
+      let score = -1;
+      if (!name.service) {
+        score = 0;
+      } else if (name.service === service && !name.method) {
+        score = 1;
+      } else if (name.service === service && name.method === method) {
+        score = 2;
+      }
+      if (score >= 0 && (!matched || score > matched.score)) {
+        matched = { config: methodConfig, score };
+      }
+    }
+  }
+  return matched?.config[key] || null;
+}
+
+/**
+ * 读取 callPath 对应的 retryPolicy
+ * @param config {ServiceConfig | null}
+ * @param callPath {string}
+ * @return {RetryPolicy | null}
+ */
+export function getRetryPolicy(
+  config: ServiceConfig | null,
+  callPath: string
+): RetryPolicy | null {
+  return getMethodPolicy(config, callPath, "retryPolicy");
+}
+
+/**
+ * 读取服务端要求的重试等待时间，单位毫秒
+ *  1. grpc-retry-pushback-ms trailer，负数或者无效值表示不要重试
+ *  2. http 的 Retry-After 头，可以是秒数或者日期
+ * @param result {CallResult<any>}
+ * @return {number | null | undefined} - null 表示不要重试，undefined 表示没有要求
+ */
+export function getPushback(
+  result: CallResult<any>
+): number | null | undefined {
+  const pushback =
+    result.status.metadata.get("grpc-retry-pushback-ms")[0] ??
+    result.metadata.get("grpc-retry-pushback-ms")[0];
+  if (pushback !== undefined) {
+    const value = Number(pushback.toString());
+    return Number.isInteger(value) && value >= 0 ? value : null;
+  }
+  const retryAfter = result.http?.headers["retry-after"];
+  if (retryAfter !== undefined) {
+    const seconds = Number(retryAfter);
+    if (!Number.isNaN(seconds)) return Math.max(seconds, 0) * 1000;
+    const date = Date.parse(retryAfter);
+    if (!Number.isNaN(date)) return Math.max(date - Date.now(), 0);
+  }
+  return undefined;
+}
+
+/**
+ * 等待 ms 毫秒，signal 被中止时立即 reject
+ * @param ms {number}
+ * @param [signal] {AbortSignal}
+ * @return {Promise<void>}
+ */
+export function sleep(ms: number, signal?: AbortSignal): Promise<void> {
+  return new Promise((resolve, reject) => {
+    if (signal?.aborted) {
+      reject(new Error("sleep aborted"));
+      return;
+    }
+    const onAbort = () => {
+      clearTimeout(timer);
+      reject(new Error("sleep aborted"));
+    };
+    const timer = setTimeout(() => {
+      signal?.removeEventListener("abort", onAbort);
+      resolve();
+    }, ms);
+    signal?.addEventListener("abort", onAbort, { once: true });
+  });
+}
+
+/**
+ * 给 http 调用加上重试，语义和 grpc-js 的 retryPolicy 相同
+ *  1. 只有幂等的方法才会重试，因为无法确认失败的请求是否已经到达服务端
+ *  2. 等待时间为 random(0, min(initialBackoff * backoffMultiplier^(n-1), maxBackoff))
+ *  3. 服务端返回 pushback 时使用 pushback 的值，并且之后的等待时间重新从 initialBackoff 开始计算
+ * @param handler {ProxyHandler}
+ * @param policy {RetryPolicy | null}
+ * @param idempotent {boolean}
+ * @return {ProxyHandler}
+ */
+export function withRetry(
+  handler: ProxyHandler,
+  policy: RetryPolicy | null,
+  idempotent: boolean
+): ProxyHandler {
+  if (!policy || !idempotent) return handler;
+  const maxAttempts = Math.min(policy.maxAttempts, MAX_ATTEMPTS);
+  const initialBackoff = parseDuration(policy.initialBackoff);
+  const maxBackoff = parseDuration(policy.maxBackoff);
+  const retryableCodes = policy.retryableStatusCodes.map(toStatusCode);
+  return async function (message, metadata, signal) {
+    let backoff = initialBackoff;
+    for (let attempt = 1; ; attempt++) {
+      const result = await handler(message, metadata, signal);
+      if (
+        signal?.aborted ||
+        attempt >= maxAttempts ||
+        !retryableCodes.includes(result.status.code)
+      ) {
+        return result;
+      }
+      const pushback = getPushback(result);
+      if (pushback === null) return result;
+      const delay = pushback ?? Math.random() * backoff;
+      backoff =
+        pushback === undefined
+          ? Math.min(backoff * policy.backoffMultiplier, maxBackoff)
+          : initialBackoff;
+      // 等待期间调用被取消时不再发送请求，返回最后一次的结果
+      try {
+        await sleep(delay, signal);
+      } catch (err) {
+        return result;
+      }
+      if (signal?.aborted) return result;
+    }
+  };
+}
@@ -0,0 +1,177 @@
+import { OpenAPIV2 } from "openapi-types";
+import { status } from "@grpc/grpc-js";
+import { CallResult } from "./grpc-utils";
+import { Logging } from "./logger";
+import { Operation } from "./openapi-v2-parser";
+import { errorResult, ValidationError } from "./proxy-error";
+import {
+  formatViolations,
+  Schema,
+  SchemaViolation,
+  validateSchema,
+} from "./openapi-schema";
+
+export interface ValidationOptions {
+  // 校验请求消息，不符合时返回 INVALID_ARGUMENT，不会发送 http 请求，默认 true
+  request?: boolean;
+  // 校验成功响应的消息是否符合 200 响应的 schema，默认不校验，建议只在开发环境开启
+  //  - warn：输出 warn 日志
+  //  - error：输出日志并返回 INTERNAL
+  response?: "warn" | "error";
+}
+
+// 读取嵌套的字段，query 参数的名称可能是 a.b
+function getField(message: any, name: string): unknown {
+  return name
+    .split(".")
+    .reduce((node, key) => (node == null ? undefined : node[key]), message);
+}
+
+/**
+ * 按 operation 的参数校验请求消息
+ *  1. body 参数是 body 时（body: "*"）校验整个消息，否则校验对应的字段
+ *  2. path、query 参数按参数的 type、format 和 enum 校验
+ * @param operation {Operation}
+ * @param message {unknown}
+ * @param document {OpenAPIV2.Document}
+ * @return {SchemaViolation[]}
+ */
+export function validateRequest(
+  operation: Operation,
+  message: unknown,
+  document: OpenAPIV2.Document
+): SchemaViolation[] {
+  const violations: SchemaViolation[] = [];
+  for (const param of operation.parameters || []) {
+    if (param.in === "body") {
+      const isMessage = param.name === "body";
+      violations.push(
+        ...validateSchema(
+          isMessage ? message : getField(message, param.name),
+          param.schema as Schema,
+          document,
+          {},
+          isMessage ? "" : param.name
+        )
+      );
+      continue;
+    }
+    const value = getField(message, param.name);
+    if (value === undefined || value === null) {
+      if (param.required) {
+        violations.push({ path: param.name, message: "is required" });
+      }
+      continue;
+    }
+    // path、query 参数的 type、format 和 enum 和 schema 相同
+    const { type, format, items, enum: values } = param;
+    const schema = { type, format, items, enum: values } as Schema;
+    violations.push(
+      ...validateSchema(value, schema, document, {}, param.name)
+    );
+  }
+  return violations;
+}
+
+/**
+ * 校验成功的响应是否符合 200 响应的 schema，schema 中没有定义的字段也会被报告
+ * @param operation {Operation}
+ * @param response {unknown}
+ * @param document {OpenAPIV2.Document}
+ * @return {SchemaViolation[]}
+ */
+export function validateResponse(
+  operation: Operation,
+  response: unknown,
+  document: OpenAPIV2.Document
+): SchemaViolation[] {
+  const ok = operation.responses?.["200"];
+  if (!ok || !("schema" in ok) || !ok.schema) return [];
+  return validateSchema(response, ok.schema, document, {
+    allowUnknown: false,
+  });
+}
+
+/**
+ * 代理调用前后按 openapi 的 schema 校验消息
+ */
+export class SchemaValidator {
+  constructor(
+    private opts: ValidationOptions,
+    private logging: Logging | null = null
+  ) {}
+
+  /**
+   * 请求消息不符合 schema 时抛出 ValidationError
+   * @param callPath {string}
+   * @param operation {Operation}
+   * @param message {unknown}
+   * @param document {OpenAPIV2.Document}
+   */
+  public checkRequest(
+    callPath: string,
+    operation: Operation,
+    message: unknown,
+    document: OpenAPIV2.Document
+  ) {
+    if (this.opts.request === false) return;
+    const violations = validateRequest(operation, message, document);
+    if (violations.length === 0) return;
+    throw new ValidationError(
+      status.INVALID_ARGUMENT,
+      `request message of ${callPath} does not match the openapi schema: ` +
+        formatViolations(violations),
+      violations
+    );
+  }
+
+  /**
+   * 成功的响应不符合 schema 时输出日志，response 是 error 时返回 INTERNAL
+   * @param callPath {string}
+   * @param operation {Operation}
+   * @param result {CallResult<T>}
+   * @param document {OpenAPIV2.Document}
+   * @return {CallResult<T>}
+   */
+  public checkResponse<T>(
+    callPath: string,
+    operation: Operation,
+    result: CallResult<T>,
+    document: OpenAPIV2.Document
+  ): CallResult<T> {
+    const mode = this.opts.response;
+    if (!mode || result.status.code !== status.OK) return result;
+    const violations = validateResponse(operation, result.response, document);
+    if (violations.length === 0) return result;
+    const message =
+      `response message of ${callPath} does not match the openapi schema: ` +
+      formatViolations(violations);
+    this.logging?.log("warn", message, {
+      callPath,
+      violations,
+      url: result.http?.gateway,
+      status: result.http?.status,
+    });
+    if (mode === "warn") return result;
+    return {
+      ...errorResult(
+        new ValidationError(status.INTERNAL, message, violations)
+      ),
+      http: result.http,
+    };
+  }
+}
+
+/**
+ * 没有配置 validation 时返回 null，不校验
+ * @param [opts] {ValidationOptions}
+ * @param [logging] {Logging | null}
+ * @return {SchemaValidator | null}
+ */
+export function createValidator(
+  opts?: ValidationOptions,
+  logging: Logging | null = null
+): SchemaValidator | null {
+  if (!opts) return null;
+  return new SchemaValidator(opts, logging);
+}
@@ -0,0 +1,45 @@
+import { ConnectionOptions } from "node:tls";
+import { ChannelCredentials, ChannelOptions } from "@grpc/grpc-js";
+
+// 访问 https 的 grpc-gateway 时使用的 TLS 配置
+export interface TlsOptions {
+  // 信任的 CA 证书，不配置时使用系统的 CA
+  ca?: string | Buffer | Array<string | Buffer>;
+  // mTLS 的客户端证书和私钥
+  cert?: string | Buffer;
+  key?: string | Buffer;
+  passphrase?: string;
+  // 校验证书时使用的域名，默认读取 channelOptions 中的 grpc.ssl_target_name_override
+  servername?: string;
+  // 是否校验服务端证书，默认 true
+  rejectUnauthorized?: boolean;
+  // 复用 client 的 ChannelCredentials（grpc.credentials.createSsl）中的证书
+  credentials?: ChannelCredentials;
+}
+
+/**
+ * 转换成 tls.connect 的选项
+ *  1. 配置了 ca/cert/key 时只使用它们，否则使用 credentials 中的证书
+ *  2. insecure 的 credentials 没有证书，会被忽略
+ * @param opts {TlsOptions}
+ * @param [channelOptions] {ChannelOptions}
+ * @return {ConnectionOptions}
+ */
+export function toConnectionOptions(
+  opts: TlsOptions,
+  channelOptions?: ChannelOptions
+): ConnectionOptions {
+  const { credentials, ...rest } = opts;
+  const result: ConnectionOptions = {};
+  if (!rest.ca && !rest.cert && !rest.key && credentials) {
+    Object.assign(result, credentials._getConnectionOptions() || {});
+  }
+  Object.entries(rest).forEach(([key, value]) => {
+    if (value !== undefined) (result as any)[key] = value;
+  });
+  const override = channelOptions?.["grpc.ssl_target_name_override"];
+  if (!result.servername && typeof override === "string") {
+    result.servername = override;
+  }
+  return result;
+}
@@ -0,0 +1,132 @@
+import type { Span, SpanContext, Tracer } from "@opentelemetry/api";
+import { Metadata, status } from "@grpc/grpc-js";
+import { getServicePath } from "./grpc-utils";
+import { settleCall } from "./proxy-error";
+import { ProxyHandler } from "./interceptor-call";
+import { TransportMiddleware } from "./http-transport";
+
+// 代理调用使用的 http 协议，作为 span 的 grpc_proxy.transport 属性
+export type ProxyTransport = "grpc-gateway" | "grpc-web";
+
+// 和 @opentelemetry/api 的 SpanKind.CLIENT、SpanStatusCode.ERROR 相同，避免运行时依赖
+const SPAN_KIND_CLIENT = 2;
+const SPAN_STATUS_ERROR = 2;
+
+const INVALID_TRACE_ID = "0".repeat(32);
+
+/**
+ * W3C Trace Context 的 traceparent：00-{traceId}-{spanId}-{flags}
+ * @param ctx {SpanContext}
+ * @return {string}
+ */
+export function formatTraceparent(ctx: SpanContext): string {
+  const flags = (ctx.traceFlags & 0xff).toString(16).padStart(2, "0");
+  return `00-${ctx.traceId}-${ctx.spanId}-${flags}`;
+}
+
+/**
+ * gRPC 的 grpc-trace-bin（OpenCensus 的 binary format）
+ * version(0) | 0 traceId(16) | 1 spanId(8) | 2 traceOptions(1)
+ * @param ctx {SpanContext}
+ * @return {Buffer}
+ */
+export function encodeGrpcTraceBin(ctx: SpanContext): Buffer {
+  return Buffer.concat([
+    Buffer.from([0, 0]),
+    Buffer.from(ctx.traceId, "hex"),
+    Buffer.from([1]),
+    Buffer.from(ctx.spanId, "hex"),
+    Buffer.from([2, ctx.traceFlags & 0xff]),
+  ]);
+}
+
+/**
+ * 需要添加到 http 请求上的链路头，span 无效（如没有注册 TracerProvider）时为空
+ * @param ctx {SpanContext}
+ * @param transport {ProxyTransport} - grpc-web 时同时发送 grpc-trace-bin
+ * @return {Record<string, string>}
+ */
+export function traceHeaders(
+  ctx: SpanContext,
+  transport: ProxyTransport
+): Record<string, string> {
+  if (ctx.traceId === INVALID_TRACE_ID) return {};
+  const headers: Record<string, string> = {
+    traceparent: formatTraceparent(ctx),
+  };
+  const tracestate = ctx.traceState?.serialize();
+  if (tracestate) headers.tracestate = tracestate;
+  if (transport === "grpc-web") {
+    headers["grpc-trace-bin"] = encodeGrpcTraceBin(ctx).toString("base64");
+  }
+  return headers;
+}
+
+/**
+ * 每个代理的 rpc 创建一个 client span，属性遵循 OpenTelemetry 的 gRPC 语义约定
+ * span 按调用的 metadata 记录，重试和对冲的每一次 http 请求都使用同一个 span 传播
+ */
+export class Tracing {
+  private readonly spans = new WeakMap<Metadata, Span>();
+
+  constructor(private tracer: Tracer, private transport: ProxyTransport) {}
+
+  /**
+   * 在 span 中完成调用，span 的父级是调用时的 active context
+   * @param handler {ProxyHandler}
+   * @param callPath {string}
+   * @return {ProxyHandler}
+   */
+  public wrap(handler: ProxyHandler, callPath: string): ProxyHandler {
+    const service = getServicePath(callPath).slice(1);
+    const method = callPath.slice(callPath.lastIndexOf("/") + 1);
+    return async (message, metadata, signal) => {
+      const span = this.tracer.startSpan(`${service}/${method}`, {
+        kind: SPAN_KIND_CLIENT,
+        attributes: {
+          "rpc.system": "grpc",
+          "rpc.service": service,
+          "rpc.method": method,
+          "grpc_proxy.transport": this.transport,
+        },
+      });
+      const md = metadata.clone();
+      this.spans.set(md, span);
+      const result = await settleCall(() => handler(message, md, signal));
+      span.setAttribute("rpc.grpc.status_code", result.status.code);
+      if (result.status.code !== status.OK) {
+        if (result.error) span.recordException(result.error);
+        span.setStatus({
+          code: SPAN_STATUS_ERROR,
+          message: result.status.details,
+        });
+      }
+      span.end();
+      return result;
+    };
+  }
+
+  // 给 http 请求加上链路头，记录最终选择的 grpc-gateway
+  public middleware(): TransportMiddleware {
+    return async (request, next) => {
+      const span = request.metadata && this.spans.get(request.metadata);
+      if (!span) return next(request);
+      span.setAttribute("grpc_proxy.gateway.url", request.baseUrl);
+      const result = await next({
+        ...request,
+        config: {
+          ...request.config,
+          headers: Object.assign(
+            {},
+            request.config.headers,
+            traceHeaders(span.spanContext(), this.transport)
+          ),
+        },
+      });
+      if (result.http) {
+        span.setAttribute("http.response.status_code", result.http.status);
+      }
+      return result;
+    };
+  }
+}
@@ -0,0 +1,131 @@
+import { EventEmitter } from "node:events";
+
+// 调用使用的传输方式：直连 gRPC 端口或者通过 grpc-gateway 代理
+export type Transport = "direct" | "proxy";
+
+// 传输方式不可用后的退避配置，单位毫秒
+export interface BackoffOptions {
+  // 第一次失败后的退避时间
+  initial?: number;
+  // 最大退避时间
+  max?: number;
+  // 每次连续失败后退避时间的倍数
+  multiplier?: number;
+}
+
+// 切换传输方式时触发的 switch 事件的参数
+export interface TransportSwitchEvent {
+  target: string;
+  from: Transport;
+  to: Transport;
+}
+
+interface HealthEntry {
+  // 连续失败的次数
+  failures: number;
+  // 在此时间之前认为该传输方式不可用
+  retryAt: number;
+}
+
+const defaultBackoff: Required<BackoffOptions> = {
+  initial: 1000,
+  max: 120000,
+  multiplier: 2,
+};
+
+function other(transport: Transport): Transport {
+  return transport === "direct" ? "proxy" : "direct";
+}
+
+/**
+ * 按 target 记录两种传输方式的健康状态
+ *  1. 优先使用 preferred，失败后在退避时间内使用另一种传输方式
+ *  2. 退避时间过后会再次尝试 preferred，连续失败时退避时间按倍数增长
+ *  3. 每次切换传输方式都会触发 switch 事件，记录失败或者成功导致的切换会立即触发，
+ *     退避时间过后的切换在下一次获取传输方式时触发
+ */
+export class TransportHealth extends EventEmitter {
+  private readonly backoff: Required<BackoffOptions>;
+  private readonly entries = new Map<string, HealthEntry>();
+  private readonly selected = new Map<string, Transport>();
+
+  constructor(private preferred: Transport, backoff?: BackoffOptions) {
+    super();
+    this.backoff = { ...defaultBackoff, ...(backoff || {}) };
+  }
+
+  private key(target: string, transport: Transport): string {
+    return `${transport}:${target}`;
+  }
+
+  private isDown(target: string, transport: Transport, now: number): boolean {
+    const entry = this.entries.get(this.key(target, transport));
+    return !!entry && entry.failures > 0 && entry.retryAt > now;
+  }
+
+  // 按失败记录选择传输方式
+  private select(target: string, now: number): Transport {
+    const transport = this.preferred;
+    if (!this.isDown(target, transport, now)) return transport;
+    const fallback = other(transport);
+    // 两种方式都不可用时，使用更早恢复的那个
+    if (!this.isDown(target, fallback, now)) return fallback;
+    const preferredEntry = this.entries.get(this.key(target, transport));
+    const fallbackEntry = this.entries.get(this.key(target, fallback));
+    return fallbackEntry!.retryAt < preferredEntry!.retryAt
+      ? fallback
+      : transport;
+  }
+
+  // 记录选择的传输方式，发生变化时触发 switch 事件
+  private update(target: string): Transport {
+    const transport = this.select(target, Date.now());
+    const previous = this.selected.get(target) || this.preferred;
+    this.selected.set(target, transport);
+    if (previous !== transport) {
+      const event: TransportSwitchEvent = {
+        target,
+        from: previous,
+        to: transport,
+      };
+      this.emit("switch", event);
+    }
+    return transport;
+  }
+
+  /**
+   * 获取 target 当前应该使用的传输方式，退避时间过后的切换在这里触发 switch 事件
+   * @param target {string}
+   * @return {Transport}
+   */
+  public current(target: string): Transport {
+    return this.update(target);
+  }
+
+  /**
+   * 记录一次传输失败，在退避时间内不再使用该传输方式
+   * @param target {string}
+   * @param transport {Transport}
+   */
+  public reportFailure(target: string, transport: Transport): void {
+    const key = this.key(target, transport);
+    const entry = this.entries.get(key) || { failures: 0, retryAt: 0 };
+    const { initial, max, multiplier } = this.backoff;
+    entry.failures += 1;
+    entry.retryAt =
+      Date.now() +
+      Math.min(max, initial * Math.pow(multiplier, entry.failures - 1));
+    this.entries.set(key, entry);
+    this.update(target);
+  }
+
+  /**
+   * 记录一次传输成功，清除该传输方式的失败记录
+   * @param target {string}
+   * @param transport {Transport}
+   */
+  public reportSuccess(target: string, transport: Transport): void {
+    this.entries.delete(this.key(target, transport));
+    this.update(target);
+  }
+}
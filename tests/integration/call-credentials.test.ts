@@ -0,0 +1,69 @@
+import { promisify } from "util";
+import { credentials, Metadata, status } from "@grpc/grpc-js";
+import { GreeterClient, testGrpcRequest } from "./testlist";
+import {
+  clientWithCredentials,
+  clientWithCredentialsChannel,
+} from "../resources/client/client";
+
+// 和 tests/resources/grpc-server/auth.go 中的 BearerToken 相同
+const token = "grpc-proxy-interceptor";
+
+function bearer(value: string) {
+  return credentials.createFromMetadataGenerator((_, callback) => {
+    const md = new Metadata();
+    md.set("authorization", `Bearer ${value}`);
+    callback(null, md);
+  });
+}
+
+function sayHello(client: GreeterClient, options = {}) {
+  return promisify(client.SayHello).bind(client)({ name: "huk" }, options);
+}
+
+describe(`call-credentials.ts: interceptor`, () => {
+  let client = null as unknown as GreeterClient;
+  beforeAll(() => {
+    client = clientWithCredentials(
+      credentials.combineChannelCredentials(
+        credentials.createInsecure(),
+        bearer(token)
+      )
+    ) as GreeterClient;
+  });
+  testGrpcRequest(() => client);
+});
+
+describe(`call-credentials.ts: channel`, () => {
+  let client = null as unknown as GreeterClient;
+  beforeAll(async () => {
+    client = (await clientWithCredentialsChannel(
+      bearer(token)
+    )) as GreeterClient;
+  });
+  testGrpcRequest(() => client);
+});
+
+describe(`call-credentials.ts: per-call credentials`, () => {
+  test(`credentials call option`, async () => {
+    const client = clientWithCredentials(undefined) as GreeterClient;
+    await expect(
+      sayHello(client, { credentials: bearer(token) })
+    ).resolves.toEqual({ message: "hello huk" });
+    await expect(sayHello(client)).rejects.toMatchObject({
+      code: status.UNAUTHENTICATED,
+    });
+  });
+
+  test(`channel credentials`, async () => {
+    const client = (await clientWithCredentialsChannel(
+      undefined
+    )) as GreeterClient;
+    await expect(
+      sayHello(client, { credentials: bearer(token) })
+    ).resolves.toEqual({ message: "hello huk" });
+    await expect(
+      sayHello(client, { credentials: bearer("wrong") })
+    ).rejects.toMatchObject({ code: status.UNAUTHENTICATED });
+  });
+});
@@ -0,0 +1,141 @@
+import { readFileSync } from "node:fs";
+import { resolve } from "node:path";
+import { AddressInfo } from "node:net";
+import { createServer, Server } from "node:http";
+import { fileURLToPath, URL } from "node:url";
+import { createMockGateway, MockGateway, verifyGateway } from "../../src";
+import { runCli } from "../../src/cli";
+import { OpenapiV2Parser } from "../../src/openapi-v2-parser";
+
+const dirname = fileURLToPath(new URL(".", import.meta.url));
+const openapiDir = resolve(dirname, "../resources/grpc-server/openapi");
+const v1 = resolve(openapiDir, "greeter/v1/services/greeter.swagger.json");
+const v2 = resolve(openapiDir, "greeter/v2/services/greeter.swagger.json");
+const sayHello = "/example.greeter.v1.services.Greeter/SayHello";
+
+// 发布 openapi 文件的 grpc-gateway，v1 的 SayHello 改成了 POST，Trailer 被删除
+let swaggerServer = null as unknown as Server;
+let swaggerUrl = "";
+const headers: string[] = [];
+
+beforeAll(async () => {
+  const drifted = JSON.parse(readFileSync(v1, "utf8"));
+  const hello = drifted.paths["/v1/sayHello/{name}"];
+  drifted.paths["/v1/sayHello/{name}"] = { post: hello.get };
+  delete drifted.paths["/v1/trailer"];
+  const files: Record<string, string> = {
+    "/openapiv2/v1.swagger.json": JSON.stringify(drifted),
+    "/openapiv2/v2.swagger.json": readFileSync(v2, "utf8"),
+  };
+  swaggerServer = createServer((req, res) => {
+    headers.push(String(req.headers.authorization));
+    const body = files[req.url || ""];
+    res.statusCode = body ? 200 : 404;
+    res.setHeader("Content-Type", "application/json");
+    res.end(body || "{}");
+  });
+  await new Promise<void>((resolve) =>
+    swaggerServer.listen(0, "127.0.0.1", resolve)
+  );
+  const { port } = swaggerServer.address() as AddressInfo;
+  swaggerUrl = `http://127.0.0.1:${port}`;
+});
+
+afterAll(
+  () => new Promise((resolve) => swaggerServer.close(() => resolve(null)))
+);
+
+describe(`contract-drift.ts: swagger`, () => {
+  test(`missing and changed routes`, async () => {
+    const report = await verifyGateway({
+      openapiDir,
+      gateway: swaggerUrl,
+      swagger: ["/openapiv2/v1.swagger.json", "/openapiv2/v2.swagger.json"],
+      headers: { Authorization: "Bearer x" },
+    });
+    expect(report).toMatchObject({
+      source: "swagger",
+      gateway: swaggerUrl,
+      ok: false,
+      checked: 10,
+      missing: [
+        {
+          id: "/example.greeter.v1.services.Greeter/Trailer",
+          method: "get",
+          path: "/v1/trailer",
+        },
+      ],
+      changed: [
+        {
+          id: sayHello,
+          changes: [{ field: "method", expected: "get", actual: "post" }],
+        },
+      ],
+      extra: [],
+    });
+    expect(headers).toContain("Bearer x");
+  });
+
+  test(`unpublished swagger`, async () => {
+    await expect(
+      verifyGateway({ openapiDir, gateway: swaggerUrl, swagger: "/missing" })
+    ).rejects.toThrow("failed to fetch /missing: received http 404");
+  });
+});
+
+describe(`contract-drift.ts: probe`, () => {
+  let gateway = null as unknown as MockGateway;
+
+  afterEach(() => gateway.close());
+
+  test(`every route is served`, async () => {
+    gateway = await createMockGateway({ openapiDir });
+    const report = await verifyGateway({ openapiDir, gateway: gateway.url });
+    expect(report).toEqual({
+      source: "probe",
+      gateway: gateway.url,
+      ok: true,
+      checked: 10,
+      missing: [],
+      changed: [],
+      extra: [],
+    });
+    // OPTIONS 不会调用 handler
+    expect(gateway.calls).toEqual([]);
+  });
+
+  test(`routes answered with 404 are missing`, async () => {
+    const parser = new OpenapiV2Parser(openapiDir);
+    await parser.init(false);
+    const entries = parser
+      .getOperations()
+      .filter(({ callPath }) => callPath !== sayHello);
+    gateway = await new MockGateway(entries).listen();
+    const report = await verifyGateway({
+      openapiDir,
+      gateway: gateway.url,
+      probeMethod: "HEAD",
+    });
+    expect(report.ok).toBe(false);
+    expect(report.missing).toEqual([
+      {
+        id: sayHello,
+        operationId: "Greeter_SayHello",
+        method: "get",
+        path: "/v1/sayHello/{name}",
+        status: 404,
+      },
+    ]);
+  });
+
+  test(`cli prints a json report`, async () => {
+    gateway = await createMockGateway({ openapiDir });
+    const stdout: string[] = [];
+    const code = await runCli(
+      ["verify", "-g", gateway.url, "-d", openapiDir, "--format", "json"],
+      { stdout: (text) => stdout.push(text), stderr: () => undefined }
+    );
+    expect(code).toBe(0);
+    expect(JSON.parse(stdout[0])).toMatchObject({ ok: true, checked: 10 });
+  });
+});
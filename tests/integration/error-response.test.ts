@@ -0,0 +1,62 @@
+import * as http from "node:http";
+import * as net from "node:net";
+import { promisify } from "util";
+import { status } from "@grpc/grpc-js";
+import { GreeterClient } from "./testlist";
+import { clientWithResolvedGetaway } from "../resources/client/client";
+
+// 模拟 grpc-gateway 前的负载均衡，按 name 返回不同的错误响应
+const responses: Record<string, [number, string, string]> = {
+  html: [502, "text/html", `<html><body>${"x".repeat(1000)}</body></html>`],
+  empty: [503, "text/plain", ""],
+  timeout: [504, "text/plain", "upstream request timeout"],
+};
+
+let server = null as unknown as http.Server;
+let client = null as unknown as GreeterClient;
+
+beforeAll(async () => {
+  server = http.createServer((req, res) => {
+    const name = decodeURIComponent(req.url!.split("/").pop()!);
+    const [code, type, body] = responses[name];
+    res.writeHead(code, { "Content-Type": type });
+    res.end(body);
+  });
+  await new Promise<void>((r) => server.listen(0, "127.0.0.1", r));
+  const { port } = server.address() as net.AddressInfo;
+  client = clientWithResolvedGetaway(
+    `http://127.0.0.1:${port}`
+  ) as GreeterClient;
+});
+
+afterAll(async () => {
+  client.close();
+  await new Promise((r) => server.close(r));
+});
+
+function sayHello(name: string) {
+  return promisify(client.SayHello).bind(client)({ name });
+}
+
+describe(`error-response: non-JSON error bodies`, () => {
+  test(`502 html page returns UNAVAILABLE with a truncated body`, async () => {
+    const err = await sayHello("html").catch((e) => e);
+    expect(err.code).toBe(status.UNAVAILABLE);
+    expect(err.details).toMatch(/^received http 502: <html><body>x+\.\.\.$/);
+    expect(err.details.length).toBeLessThan(300);
+  });
+
+  test(`503 empty body returns UNAVAILABLE`, async () => {
+    await expect(sayHello("empty")).rejects.toMatchObject({
+      code: status.UNAVAILABLE,
+      details: "received http 503 with an empty body",
+    });
+  });
+
+  test(`504 returns DEADLINE_EXCEEDED`, async () => {
+    await expect(sayHello("timeout")).rejects.toMatchObject({
+      code: status.DEADLINE_EXCEEDED,
+      details: "received http 504: upstream request timeout",
+    });
+  });
+});
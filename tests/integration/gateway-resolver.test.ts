@@ -0,0 +1,29 @@
+import { tmpdir } from "node:os";
+import { join } from "node:path";
+import { mkdtemp, writeFile } from "node:fs/promises";
+import { fileResolver } from "../../src";
+import { GreeterClient, testGrpcRequest } from "./testlist";
+import { clientWithResolvedGetaway } from "../resources/client/client";
+
+let asyncClient = null as unknown as GreeterClient;
+let fileClient = null as unknown as GreeterClient;
+
+beforeAll(async () => {
+  asyncClient = clientWithResolvedGetaway(
+    async () => "http://127.0.0.1:4502"
+  ) as GreeterClient;
+  const path = join(await mkdtemp(join(tmpdir(), "gateway-")), "a.json");
+  await writeFile(
+    path,
+    JSON.stringify(["http://127.0.0.1:4501", "http://127.0.0.1:4502"])
+  );
+  fileClient = clientWithResolvedGetaway(fileResolver(path)) as GreeterClient;
+});
+
+describe(`gateway-resolver.ts: async getaway`, () => {
+  testGrpcRequest(() => asyncClient);
+});
+
+describe(`gateway-resolver.ts: fileResolver`, () => {
+  testGrpcRequest(() => fileClient);
+});
@@ -0,0 +1,56 @@
+import { promisify } from "util";
+import { Metadata } from "@grpc/grpc-js";
+import { toMetadata } from "../../src/grpc-utils";
+import { GreeterClient, testGrpcRequest } from "./testlist";
+import { clientWithHeaders } from "../resources/client/client";
+import {
+  defaultRequestHeaderMatcher,
+  defaultResponseHeaderMatcher,
+} from "../../src/header-matcher";
+
+let client = null as unknown as GreeterClient;
+
+beforeAll(() => {
+  // 和 tests/resources/grpc-server/server.go 中的 RequestIdHeaderMatcher 对应
+  client = clientWithHeaders({
+    request: {
+      matcher: (key) =>
+        key === "x-request-id"
+          ? ["X-Request-Id", true]
+          : defaultRequestHeaderMatcher(key),
+    },
+    response: {
+      matcher: (header) =>
+        header === "x-request-id"
+          ? ["x-request-id", true]
+          : defaultResponseHeaderMatcher(header),
+      deny: ["grpc-metadata-content-type"],
+    },
+  }) as GreeterClient;
+});
+
+describe(`header-matcher.ts: custom matchers`, () => {
+  testGrpcRequest(() => client);
+
+  test(`X-Request-Id is sent without prefix`, async () => {
+    const record = { "x-request-id": "r-1" };
+    const EqMetadata = promisify(client.EqMetadata).bind(client);
+    await expect(
+      EqMetadata({ metadata: record }, toMetadata(record))
+    ).resolves.toEqual({ ok: true });
+  });
+
+  test(`X-Request-Id is received without prefix`, async () => {
+    const record = { "x-request-id": "r-2" };
+    const md = (await new Promise((resolve) => {
+      const emitter = client.Metadata(
+        { metadata: record },
+        new Metadata(),
+        () => void 0
+      );
+      emitter.on("metadata", resolve);
+    })) as Metadata;
+    expect(md.get("x-request-id")).toEqual(["r-2"]);
+    expect(md.get("content-type")).toEqual([]);
+  });
+});
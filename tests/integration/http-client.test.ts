@@ -0,0 +1,18 @@
+import { undiciClient } from "../../src";
+import { GreeterClient, testGrpcRequest } from "./testlist";
+import { clientWithHttpClient } from "../resources/client/client";
+
+const httpClient = undiciClient({ connections: 4, keepAliveTimeout: 10000 });
+let client = null as unknown as GreeterClient;
+
+beforeAll(() => {
+  client = clientWithHttpClient(httpClient) as GreeterClient;
+});
+
+afterAll(async () => {
+  await httpClient.close();
+});
+
+describe(`http-client.ts: undici`, () => {
+  testGrpcRequest(() => client);
+});
@@ -0,0 +1,80 @@
+import { join } from "node:path";
+import { promisify } from "util";
+import { readFileSync } from "node:fs";
+import { status } from "@grpc/grpc-js";
+import { GreeterClient, testGrpcRequest } from "./testlist";
+import {
+  ConnectProxy,
+  startConnectProxy,
+} from "../resources/proxy/connect-proxy";
+import { certsDir, clientWithProxy } from "../resources/client/client";
+
+let proxy = null as unknown as ConnectProxy;
+let proxyUrl = "";
+
+beforeAll(async () => {
+  proxy = await startConnectProxy({ username: "huk", password: "p@ss" });
+  const { host } = new URL(proxy.url);
+  proxyUrl = `http://huk:${encodeURIComponent("p@ss")}@${host}`;
+});
+
+afterAll(async () => {
+  await proxy.close();
+});
+
+function sayHello(client: GreeterClient) {
+  return promisify(client.SayHello).bind(client)({ name: "huk" });
+}
+
+describe(`http-proxy.ts: http`, () => {
+  let client = null as unknown as GreeterClient;
+  beforeAll(() => {
+    client = clientWithProxy(
+      "http://127.0.0.1:4501",
+      proxyUrl
+    ) as GreeterClient;
+  });
+  testGrpcRequest(() => client);
+  test(`requests go through the proxy`, () => {
+    expect(proxy.requests).toContainEqual(
+      expect.stringMatching(/^POST http:\/\/127\.0\.0\.1:4501\//)
+    );
+  });
+});
+
+describe(`http-proxy.ts: https over CONNECT`, () => {
+  let client = null as unknown as GreeterClient;
+  beforeAll(() => {
+    client = clientWithProxy("https://127.0.0.1:4511", proxyUrl, {
+      ca: readFileSync(join(certsDir, "ca.pem")),
+    }) as GreeterClient;
+  });
+  testGrpcRequest(() => client);
+  test(`requests go through the tunnel`, () => {
+    expect(proxy.requests).toContain("CONNECT 127.0.0.1:4511");
+  });
+});
+
+describe(`http-proxy.ts: proxy auth and NO_PROXY`, () => {
+  test(`wrong password`, async () => {
+    const { host } = new URL(proxy.url);
+    const client = clientWithProxy(
+      "https://127.0.0.1:4511",
+      `http://huk:wrong@${host}`,
+      { ca: readFileSync(join(certsDir, "ca.pem")) }
+    ) as GreeterClient;
+    await expect(sayHello(client)).rejects.toMatchObject({
+      code: status.UNAVAILABLE,
+    });
+  });
+
+  test(`noProxy`, async () => {
+    const count = proxy.requests.length;
+    const client = clientWithProxy("http://127.0.0.1:4502", {
+      url: proxyUrl,
+      noProxy: "127.0.0.1:4502",
+    }) as GreeterClient;
+    await expect(sayHello(client)).resolves.toEqual({ message: "hello huk" });
+    expect(proxy.requests.length).toBe(count);
+  });
+});
@@ -0,0 +1,129 @@
+import { promisify } from "util";
+import { GreeterClient } from "./testlist";
+import {
+  InterceptingCall,
+  Interceptor,
+  Metadata,
+  status,
+} from "@grpc/grpc-js";
+import {
+  clientWithChannelChain,
+  clientWithDownstreamInterceptor,
+  clientWithFallbackChain,
+  clientWithInterceptorChain,
+} from "../resources/client/client";
+
+// 记录调用经过拦截器的顺序，并在 metadata 中追加一个值
+function loggingInterceptor(name: string, logs: string[]): Interceptor {
+  return function (options, nextCall) {
+    return new InterceptingCall(nextCall(options), {
+      start: function (metadata, listener, next) {
+        logs.push(`${name}:start`);
+        metadata.set(name, "1");
+        next(metadata, {
+          onReceiveStatus: function (status, nextStatus) {
+            logs.push(`${name}:status`);
+            nextStatus(status);
+          },
+        });
+      },
+      sendMessage: function (message, next) {
+        logs.push(`${name}:sendMessage`);
+        next(message);
+      },
+      halfClose: function (next) {
+        logs.push(`${name}:halfClose`);
+        next();
+      },
+    });
+  };
+}
+
+async function testChain(
+  client: GreeterClient,
+  logs: string[],
+  expected = [
+    "before:start",
+    "after:start",
+    "before:sendMessage",
+    "after:sendMessage",
+    "before:halfClose",
+    "after:halfClose",
+    "after:status",
+    "before:status",
+  ]
+) {
+  const EqMetadata = promisify(client.EqMetadata).bind(client);
+  const record = { before: "1", after: "1" };
+  const result = await EqMetadata({ metadata: record }, new Metadata());
+  expect(result).toEqual({ ok: true });
+  expect(logs).toEqual(expected);
+}
+
+// 自动切换时消息在 halfClose 之后才交给 grpc-gateway，after 在这之后执行
+const fallbackOrder = [
+  "before:start",
+  "before:sendMessage",
+  "before:halfClose",
+  "after:start",
+  "after:sendMessage",
+  "after:halfClose",
+  "after:status",
+  "before:status",
+];
+
+describe(`interceptor chain`, () => {
+  test(`interceptor forwards through downstream interceptors`, async () => {
+    const logs: string[] = [];
+    const client = clientWithInterceptorChain(
+      loggingInterceptor("before", logs),
+      loggingInterceptor("after", logs)
+    ) as GreeterClient;
+    await testChain(client, logs);
+  });
+  test(`interceptors after the proxy fail the call`, async () => {
+    const logs: string[] = [];
+    const client = clientWithDownstreamInterceptor(
+      loggingInterceptor("before", logs),
+      loggingInterceptor("after", logs)
+    ) as GreeterClient;
+    const EqMetadata = promisify(client.EqMetadata).bind(client);
+    await expect(
+      EqMetadata({ metadata: { before: "1" } }, new Metadata())
+    ).rejects.toMatchObject({ code: status.FAILED_PRECONDITION });
+    expect(logs).toEqual([
+      "before:start",
+      "before:sendMessage",
+      "before:halfClose",
+      "before:status",
+    ]);
+  });
+  test(`channel runs beneath the whole interceptor chain`, async () => {
+    const logs: string[] = [];
+    const client = (await clientWithChannelChain(
+      loggingInterceptor("before", logs),
+      loggingInterceptor("after", logs)
+    )) as GreeterClient;
+    await testChain(client, logs);
+  });
+  test(`fallback runs the interceptors before grpc-gateway`, async () => {
+    const logs: string[] = [];
+    const client = clientWithFallbackChain(
+      loggingInterceptor("before", logs),
+      loggingInterceptor("after", logs),
+      { prefer: "proxy" }
+    ) as GreeterClient;
+    await testChain(client, logs, fallbackOrder);
+  });
+  test(`failover to grpc-gateway runs the interceptors`, async () => {
+    const logs: string[] = [];
+    // 没有服务监听的端口，直连失败后幂等的 EqMetadata 切换到 grpc-gateway
+    const client = clientWithFallbackChain(
+      loggingInterceptor("before", logs),
+      loggingInterceptor("after", logs),
+      { prefer: "direct" },
+      "127.0.0.1:1"
+    ) as GreeterClient;
+    await testChain(client, logs, fallbackOrder);
+  });
+});
@@ -0,0 +1,23 @@
+import { GreeterClient, testGrpcRequest } from "./testlist";
+import { EndpointHealthEvent } from "../../src/load-balancer";
+import {
+  clientWithLoadBalancing,
+  loadBalancingInterceptor,
+} from "../resources/client/client";
+
+let client = null as unknown as GreeterClient;
+let ejected = null as unknown as EndpointHealthEvent;
+
+beforeAll(async () => {
+  const proxy = loadBalancingInterceptor();
+  client = clientWithLoadBalancing(proxy) as GreeterClient;
+  // 等待无法连接的地址被摘除
+  ejected = await new Promise((resolve) => proxy.once("endpoint", resolve));
+});
+
+describe(`load-balancer.ts`, () => {
+  test(`eject unhealthy endpoint`, () => {
+    expect(ejected).toEqual({ url: "http://127.0.0.1:4509", healthy: false });
+  });
+  testGrpcRequest(() => client);
+});
@@ -0,0 +1,60 @@
+import { promisify } from "util";
+import { Metadata, status } from "@grpc/grpc-js";
+import { GreeterClient } from "./testlist";
+import { clientWithLogger } from "../resources/client/client";
+import type { LogFields, LogLevel } from "../../src";
+
+const entries: [LogLevel, string, LogFields][] = [];
+
+const record = (level: LogLevel) => (message: string, fields: LogFields) =>
+  entries.push([level, message, fields]);
+
+const client = clientWithLogger({
+  debug: record("debug"),
+  info: record("info"),
+  warn: record("warn"),
+  error: record("error"),
+}) as GreeterClient;
+
+describe(`logger.ts: openapiInterceptorSync`, () => {
+  beforeEach(() => {
+    entries.length = 0;
+  });
+
+  test(`debug dumps the http request without credentials`, async () => {
+    const metadata = new Metadata();
+    metadata.set("authorization", "Bearer secret");
+    await promisify(client.SayHello).bind(client)({ name: "huk" }, metadata);
+    const callPath = "/example.greeter.v1.services.Greeter/SayHello";
+    expect(entries.map(([level, message]) => [level, message])).toEqual([
+      ["debug", `http request ${callPath}`],
+      ["debug", `http response ${callPath}`],
+      ["debug", `${callPath} succeeded`],
+    ]);
+    expect(entries[0][2]).toMatchObject({
+      callPath,
+      transport: "grpc-gateway",
+      method: "GET",
+      url: "http://127.0.0.1:4501/v1/sayHello/huk",
+      headers: { Authorization: "[REDACTED]" },
+    });
+    expect(entries[1][2]).toMatchObject({ status: 200, grpcCode: "OK" });
+    expect(entries[2][2]).toMatchObject({
+      url: "http://127.0.0.1:4501",
+      status: 200,
+      duration: expect.any(Number),
+    });
+  });
+
+  test(`error statuses are logged as info`, async () => {
+    const call = promisify(client.Status).bind(client);
+    await expect(
+      call({ status: status.NOT_FOUND, errorMsg: "not found" })
+    ).rejects.toMatchObject({ code: status.NOT_FOUND });
+    expect(entries[entries.length - 1]).toEqual([
+      "info",
+      "/example.greeter.v1.services.Greeter/Status returned NOT_FOUND",
+      expect.objectContaining({ status: 404, grpcCode: "NOT_FOUND" }),
+    ]);
+  });
+});
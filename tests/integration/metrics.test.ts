@@ -0,0 +1,49 @@
+import { promisify } from "util";
+import { status } from "@grpc/grpc-js";
+import { GreeterClient } from "./testlist";
+import { clientWithMetrics } from "../resources/client/client";
+import type { CallMetric, LoadMetric, RequestMetric } from "../../src";
+
+const calls: CallMetric[] = [];
+const requests: RequestMetric[] = [];
+const loads: LoadMetric[] = [];
+
+const client = clientWithMetrics({
+  recordCall: (metric) => calls.push(metric),
+  recordRequest: (metric) => requests.push(metric),
+  recordLoad: (metric) => loads.push(metric),
+}) as GreeterClient;
+
+describe(`metrics.ts: openapiInterceptorSync`, () => {
+  test(`openapi load time and route count`, () => {
+    expect(loads).toEqual([
+      { duration: expect.any(Number), routes: 10, error: null },
+    ]);
+  });
+
+  test(`successful call`, async () => {
+    await promisify(client.SayHello).bind(client)({ name: "huk" });
+    const expected = {
+      method: "/example.greeter.v1.services.Greeter/SayHello",
+      transport: "grpc-gateway",
+      gateway: "http://127.0.0.1:4501",
+      httpStatus: 200,
+      grpcCode: status.OK,
+      duration: expect.any(Number),
+    };
+    expect(calls.pop()).toEqual(expected);
+    expect(requests.pop()).toEqual(expected);
+  });
+
+  test(`status mapping`, async () => {
+    const call = promisify(client.Status).bind(client);
+    await expect(
+      call({ status: status.NOT_FOUND, errorMsg: "not found" })
+    ).rejects.toMatchObject({ code: status.NOT_FOUND });
+    expect(calls.pop()).toMatchObject({
+      method: "/example.greeter.v1.services.Greeter/Status",
+      httpStatus: 404,
+      grpcCode: status.NOT_FOUND,
+    });
+  });
+});
@@ -0,0 +1,108 @@
+import { resolve } from "node:path";
+import { promisify } from "util";
+import { fileURLToPath, URL } from "node:url";
+import { Metadata, status } from "@grpc/grpc-js";
+import axios from "axios";
+import { GreeterClient, testGrpcRequest } from "./testlist";
+import { createMockGateway, MockGateway } from "../../src";
+import { greeterHandlers } from "../resources/mock-gateway/greeter-handlers";
+import {
+  clientWithGateway,
+  clientWithGrpcWebGateway,
+} from "../resources/client/client";
+
+const dirname = fileURLToPath(new URL(".", import.meta.url));
+const openapiDir = resolve(dirname, "../resources/grpc-server/openapi");
+
+let gateway = null as unknown as MockGateway;
+let client = null as unknown as GreeterClient;
+let grpcWebClient = null as unknown as GreeterClient;
+
+beforeAll(async () => {
+  gateway = await createMockGateway({
+    openapiDir,
+    handlers: {
+      ...greeterHandlers("example.greeter.v1.services.Greeter"),
+      ...greeterHandlers("example.greeter.v2.services.Greeter"),
+    },
+  });
+  client = clientWithGateway(gateway.url) as GreeterClient;
+  grpcWebClient = clientWithGrpcWebGateway(gateway.url) as GreeterClient;
+});
+
+afterAll(() => gateway.close());
+
+describe(`mock-gateway.ts: openapi`, () => {
+  testGrpcRequest(() => client);
+});
+
+describe(`mock-gateway.ts: grpc-web`, () => {
+  testGrpcRequest(() => grpcWebClient);
+});
+
+describe(`mock-gateway.ts: createMockGateway`, () => {
+  test(`every operation is exposed`, () => {
+    expect(gateway.callPaths).toHaveLength(10);
+    expect(gateway.callPaths).toContain(
+      "/example.greeter.v2.services.Greeter/SayHello"
+    );
+  });
+
+  test(`calls are recorded with metadata`, async () => {
+    const metadata = new Metadata();
+    metadata.set("authorization", "Bearer x");
+    metadata.set("x-request-id", "1");
+    await promisify(client.SayHello).bind(client)({ name: "huk" }, metadata);
+    expect(gateway.calls[gateway.calls.length - 1]).toMatchObject({
+      callPath: "/example.greeter.v1.services.Greeter/SayHello",
+      method: "GET",
+      url: "/v1/sayHello/huk",
+      message: { name: "huk" },
+      metadata: { authorization: "Bearer x", "x-request-id": "1" },
+    });
+  });
+
+  test(`sample data without a handler`, async () => {
+    const mock = await createMockGateway({ openapiDir });
+    try {
+      const sample = clientWithGateway(mock.url) as GreeterClient;
+      const SayHello = promisify(sample.SayHello).bind(sample);
+      expect(await SayHello({ name: "huk" })).toEqual({ message: "string" });
+      const EqMetadata = promisify(sample.EqMetadata).bind(sample);
+      expect(await EqMetadata({ metadata: {} }, new Metadata())).toEqual({
+        ok: false,
+      });
+    } finally {
+      await mock.close();
+    }
+  });
+
+  test(`grpc-gateway error bodies`, async () => {
+    const http = axios.create({
+      baseURL: gateway.url,
+      validateStatus: () => true,
+    });
+    const notFound = await http.get("/v1/unknown");
+    expect(notFound.status).toBe(404);
+    expect(notFound.data).toEqual({
+      code: status.NOT_FOUND,
+      message: "Not Found",
+      details: [],
+    });
+    const notAllowed = await http.delete("/v1/sayHello/huk");
+    expect(notAllowed.status).toBe(405);
+    expect(notAllowed.data.code).toBe(status.UNIMPLEMENTED);
+    const error = await http.get("/v1/status", {
+      params: { status: status.PERMISSION_DENIED, errorMsg: "denied" },
+    });
+    expect(error.status).toBe(403);
+    expect(error.headers["grpc-metadata-content-type"]).toBe(
+      "application/grpc"
+    );
+    expect(error.data).toEqual({
+      code: status.PERMISSION_DENIED,
+      message: "denied",
+      details: [],
+    });
+  });
+});
@@ -0,0 +1,43 @@
+import { promisify } from "util";
+import { GreeterClient, testGrpcRequest } from "./testlist";
+import { clientWithOAuth2 } from "../resources/client/client";
+import {
+  startTokenServer,
+  TokenServer,
+} from "../resources/oauth2/token-server";
+
+let server = null as unknown as TokenServer;
+let client = null as unknown as GreeterClient;
+
+beforeAll(async () => {
+  // 第一个 token 会被 grpc-gateway 拒绝，第二个和 auth.go 中的 BearerToken 相同
+  server = await startTokenServer({
+    clientId: "grpc-proxy",
+    clientSecret: "s3cret",
+    tokens: ["expired", "grpc-proxy-interceptor"],
+    expiresIn: 3600,
+  });
+  client = clientWithOAuth2({
+    tokenUrl: server.url,
+    clientId: "grpc-proxy",
+    clientSecret: "s3cret",
+  }) as GreeterClient;
+});
+
+afterAll(async () => {
+  await server.close();
+});
+
+describe(`oauth2.ts: client credentials`, () => {
+  test(`refresh token after 401`, async () => {
+    const sayHello = promisify(client.SayHello).bind(client);
+    await expect(sayHello({ name: "huk" })).resolves.toEqual({
+      message: "hello huk",
+    });
+    expect(server.requests).toHaveLength(2);
+  });
+  testGrpcRequest(() => client);
+  test(`token is cached`, () => {
+    expect(server.requests).toHaveLength(2);
+  });
+});
@@ -0,0 +1,26 @@
+import { promisify } from "util";
+import { GreeterClient, testGrpcRequest } from "./testlist";
+import {
+  clientWithGrpcWebChannel,
+  clientWithOpenapiChannel,
+} from "../resources/client/client";
+
+let client = null as unknown as GreeterClient;
+let grpcWebClient = null as unknown as GreeterClient;
+
+beforeAll(async () => {
+  client = (await clientWithOpenapiChannel()) as GreeterClient;
+  grpcWebClient = clientWithGrpcWebChannel() as GreeterClient;
+});
+
+describe(`proxy-channel.ts: openapi`, () => {
+  testGrpcRequest(() => client);
+  test(`waitForReady`, async () => {
+    const waitForReady = promisify(client.waitForReady).bind(client);
+    await waitForReady(Date.now() + 3000);
+  });
+});
+
+describe(`proxy-channel.ts: grpc-web`, () => {
+  testGrpcRequest(() => grpcWebClient);
+});
@@ -0,0 +1,37 @@
+import { resolve } from "node:path";
+import { promisify } from "util";
+import { status } from "@grpc/grpc-js";
+import { fileURLToPath, URL } from "node:url";
+import { GreeterClient } from "./testlist";
+import {
+  clientWithLoadBalancing,
+  openapiDirInterceptor,
+} from "../resources/client/client";
+
+const dirname = fileURLToPath(new URL(".", import.meta.url));
+const openapiDir = resolve(dirname, "../resources/grpc-server/openapi");
+
+function sayHello(client: GreeterClient, request: object) {
+  return promisify(client.SayHello).bind(client)(request);
+}
+
+describe(`proxy-error.ts: failed calls finish with a grpc status`, () => {
+  test(`method without openapi definition returns UNIMPLEMENTED`, async () => {
+    // 只加载 v2 的 openapi 文件，v1 的方法没有定义
+    const proxy = openapiDirInterceptor(resolve(openapiDir, "greeter/v2"));
+    const client = clientWithLoadBalancing(proxy) as GreeterClient;
+    await expect(sayHello(client, { name: "huk" })).rejects.toMatchObject({
+      code: status.UNIMPLEMENTED,
+      details: expect.stringContaining("no openapi operation found"),
+    });
+  });
+
+  test(`missing path parameter returns INVALID_ARGUMENT`, async () => {
+    const proxy = openapiDirInterceptor(openapiDir);
+    const client = clientWithLoadBalancing(proxy) as GreeterClient;
+    await expect(sayHello(client, {})).rejects.toMatchObject({
+      code: status.INVALID_ARGUMENT,
+      details: expect.stringContaining("missing path parameter name"),
+    });
+  });
+});
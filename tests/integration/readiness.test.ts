@@ -0,0 +1,41 @@
+import { resolve } from "node:path";
+import { promisify } from "util";
+import { status } from "@grpc/grpc-js";
+import { fileURLToPath, URL } from "node:url";
+import { GreeterClient } from "./testlist";
+import {
+  clientWithLoadBalancing,
+  openapiDirInterceptor,
+} from "../resources/client/client";
+
+const dirname = fileURLToPath(new URL(".", import.meta.url));
+
+function sayHello(client: GreeterClient) {
+  return promisify(client.SayHello).bind(client)({ name: "huk" });
+}
+
+describe(`readiness.ts: openapiInterceptorSync`, () => {
+  test(`calls right after creation are proxied`, async () => {
+    const proxy = openapiDirInterceptor(
+      resolve(dirname, "../resources/grpc-server/openapi")
+    );
+    const client = clientWithLoadBalancing(proxy) as GreeterClient;
+    const ready = new Promise((r) => proxy.once("ready", r));
+    await expect(sayHello(client)).resolves.toEqual({
+      message: "hello huk",
+    });
+    await ready;
+    await expect(proxy.ready()).resolves.toBeUndefined();
+  });
+
+  test(`missing directory returns FAILED_PRECONDITION`, async () => {
+    const proxy = openapiDirInterceptor(resolve(dirname, "not-exist"));
+    const error = new Promise<Error>((r) => proxy.once("error", r));
+    const client = clientWithLoadBalancing(proxy) as GreeterClient;
+    await expect(sayHello(client)).rejects.toMatchObject({
+      code: status.FAILED_PRECONDITION,
+    });
+    expect((await error).message).toMatch(/does not exist/);
+    await expect(proxy.ready()).rejects.toThrow(/does not exist/);
+  });
+});
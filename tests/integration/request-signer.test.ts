@@ -0,0 +1,25 @@
+import { GreeterClient, testGrpcRequest } from "./testlist";
+import { clientWithSignRequest } from "../resources/client/client";
+import { hmacSigner, SignableRequest } from "../../src/request-signer";
+
+const signed: SignableRequest[] = [];
+let client = null as unknown as GreeterClient;
+
+beforeAll(() => {
+  const signer = hmacSigner({ keyId: "key-1", secret: "s3cret" });
+  client = clientWithSignRequest((request) => {
+    signed.push(request);
+    return signer(request);
+  }) as GreeterClient;
+});
+
+describe(`request-signer.ts: hmacSigner`, () => {
+  // 改写后的请求（完整地址、序列化后的请求体）仍然可以正常调用
+  testGrpcRequest(() => client);
+  test(`every request is signed`, () => {
+    expect(signed.length).toBeGreaterThan(0);
+    signed.forEach((request) => {
+      expect(request.url).toMatch(/^http:\/\/127\.0\.0\.1:4501\/v1\//);
+    });
+  });
+});
@@ -0,0 +1,119 @@
+import { resolve } from "node:path";
+import { promisify } from "util";
+import { fileURLToPath, URL } from "node:url";
+import { status } from "@grpc/grpc-js";
+import { GreeterClient } from "./testlist";
+import { createMockGateway, MockGateway } from "../../src";
+import type { LogFields } from "../../src";
+import { clientWithValidation } from "../resources/client/client";
+
+const dirname = fileURLToPath(new URL(".", import.meta.url));
+const openapiDir = resolve(dirname, "../resources/grpc-server/openapi");
+const sayHello = "/example.greeter.v1.services.Greeter/SayHello";
+
+let gateway = null as unknown as MockGateway;
+
+beforeAll(async () => {
+  gateway = await createMockGateway({ openapiDir });
+});
+
+afterAll(() => gateway.close());
+
+describe(`schema-validator.ts: request`, () => {
+  test(`invalid messages are rejected before the http request`, async () => {
+    const client = clientWithValidation(gateway.url, {}) as GreeterClient;
+    const calls = gateway.calls.length;
+    await expect(
+      promisify(client.Status).bind(client)({ status: "abc", errorMsg: "x" })
+    ).rejects.toMatchObject({
+      code: status.INVALID_ARGUMENT,
+      details: expect.stringContaining("status must be an integer"),
+    });
+    await expect(
+      promisify(client.EqMetadata).bind(client)({ metadata: { a: 1 } })
+    ).rejects.toMatchObject({
+      code: status.INVALID_ARGUMENT,
+      details: expect.stringContaining("metadata.a must be a string"),
+    });
+    expect(gateway.calls).toHaveLength(calls);
+  });
+
+  test(`valid messages are sent`, async () => {
+    const client = clientWithValidation(gateway.url, {}) as GreeterClient;
+    await expect(
+      promisify(client.EqMetadata).bind(client)({ metadata: { a: "1" } })
+    ).resolves.toEqual({ ok: false });
+  });
+
+  test(`request validation can be disabled`, async () => {
+    const client = clientWithValidation(gateway.url, {
+      request: false,
+    }) as GreeterClient;
+    const calls = gateway.calls.length;
+    await promisify(client.Status).bind(client)({ status: "0" });
+    expect(gateway.calls).toHaveLength(calls + 1);
+  });
+});
+
+describe(`schema-validator.ts: response`, () => {
+  const warnings: [string, LogFields][] = [];
+  const logger = {
+    warn: (message: string, fields: LogFields) =>
+      warnings.push([message, fields]),
+  };
+
+  beforeAll(() => {
+    // 和 openapi 不一致的响应：message 不是字符串，多了 extra 字段
+    gateway.handle(sayHello, () => ({ message: { message: 1, extra: true } }));
+  });
+
+  beforeEach(() => {
+    warnings.length = 0;
+  });
+
+  test(`drift is logged in warn mode`, async () => {
+    const client = clientWithValidation(
+      gateway.url,
+      { response: "warn" },
+      logger
+    ) as GreeterClient;
+    await promisify(client.SayHello).bind(client)({ name: "huk" });
+    expect(warnings).toEqual([
+      [
+        `response message of ${sayHello} does not match the openapi schema: ` +
+          "message must be a string; extra is not defined",
+        expect.objectContaining({
+          callPath: sayHello,
+          violations: [
+            { path: "message", message: "must be a string" },
+            { path: "extra", message: "is not defined" },
+          ],
+        }),
+      ],
+    ]);
+  });
+
+  test(`drift fails the call in error mode`, async () => {
+    const client = clientWithValidation(
+      gateway.url,
+      { response: "error" },
+      logger
+    ) as GreeterClient;
+    await expect(
+      promisify(client.SayHello).bind(client)({ name: "huk" })
+    ).rejects.toMatchObject({
+      code: status.INTERNAL,
+      details: expect.stringContaining("does not match the openapi schema"),
+    });
+  });
+
+  test(`responses are not checked by default`, async () => {
+    const client = clientWithValidation(
+      gateway.url,
+      {},
+      logger
+    ) as GreeterClient;
+    await promisify(client.SayHello).bind(client)({ name: "huk" });
+    expect(warnings).toEqual([]);
+  });
+});
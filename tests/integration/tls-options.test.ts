@@ -0,0 +1,53 @@
+import { join } from "node:path";
+import { promisify } from "util";
+import { readFileSync } from "node:fs";
+import { credentials, status } from "@grpc/grpc-js";
+import { GreeterClient, testGrpcRequest } from "./testlist";
+import { certsDir, clientWithTls } from "../resources/client/client";
+
+const read = (name: string) => readFileSync(join(certsDir, name));
+
+let httpsClient = null as unknown as GreeterClient;
+let mtlsClient = null as unknown as GreeterClient;
+let credentialsClient = null as unknown as GreeterClient;
+let anonymousClient = null as unknown as GreeterClient;
+
+beforeAll(() => {
+  const ca = read("ca.pem");
+  const cert = read("client.pem");
+  const key = read("client-key.pem");
+  httpsClient = clientWithTls("https://127.0.0.1:4511", {
+    ca,
+  }) as GreeterClient;
+  mtlsClient = clientWithTls("https://127.0.0.1:4512", {
+    ca,
+    cert,
+    key,
+  }) as GreeterClient;
+  // 证书中的域名是 localhost 和 gateway.test
+  credentialsClient = clientWithTls("https://127.0.0.1:4512", {
+    credentials: credentials.createSsl(ca, key, cert),
+    servername: "gateway.test",
+  }) as GreeterClient;
+  anonymousClient = clientWithTls("https://127.0.0.1:4512", {
+    ca,
+  }) as GreeterClient;
+});
+
+describe(`tls-options.ts: https`, () => {
+  testGrpcRequest(() => httpsClient);
+});
+
+describe(`tls-options.ts: mTLS`, () => {
+  testGrpcRequest(() => mtlsClient);
+});
+
+describe(`tls-options.ts: ChannelCredentials`, () => {
+  testGrpcRequest(() => credentialsClient);
+  test(`client certificate is required`, async () => {
+    const sayHello = promisify(anonymousClient.SayHello).bind(anonymousClient);
+    await expect(sayHello({ name: "huk" })).rejects.toMatchObject({
+      code: status.UNAVAILABLE,
+    });
+  });
+});
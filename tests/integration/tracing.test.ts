@@ -0,0 +1,67 @@
+import { Metadata, status } from "@grpc/grpc-js";
+import { GreeterClient } from "./testlist";
+import { createFakeTracer } from "../resources/tracing/fake-tracer";
+import {
+  clientWithGrpcWebTracer,
+  clientWithTracer,
+} from "../resources/client/client";
+
+const traceparent = (spanId: string) =>
+  `00-0af7651916cd43dd8448eb211c80319c-${spanId}-01`;
+
+// 返回响应的 header metadata
+function sayHello(client: GreeterClient, name: string) {
+  return new Promise<Metadata>((resolve, reject) => {
+    let metadata = new Metadata();
+    const call = client.SayHello({ name }, (err: Error | null) =>
+      err ? reject(err) : resolve(metadata)
+    );
+    call.on("metadata", (md: Metadata) => (metadata = md));
+  });
+}
+
+describe(`tracing.ts: grpc-gateway`, () => {
+  test(`traceparent and tracestate reach the gRPC service`, async () => {
+    const { tracer, spans } = createFakeTracer("vendor=abc");
+    const client = clientWithTracer(tracer) as GreeterClient;
+    const metadata = await sayHello(client, "huk");
+    expect(metadata.get("echo-traceparent")).toEqual([
+      traceparent("0000000000000001"),
+    ]);
+    expect(metadata.get("echo-tracestate")).toEqual(["vendor=abc"]);
+    // 不是 grpc-web 模式时不发送 grpc-trace-bin
+    expect(metadata.get("echo-grpc-trace-bin")).toEqual([]);
+    expect(spans).toHaveLength(1);
+    expect(spans[0]).toMatchObject({
+      name: "example.greeter.v1.services.Greeter/SayHello",
+      kind: 2,
+      ended: true,
+      attributes: {
+        "rpc.system": "grpc",
+        "rpc.service": "example.greeter.v1.services.Greeter",
+        "rpc.method": "SayHello",
+        "rpc.grpc.status_code": status.OK,
+        "grpc_proxy.transport": "grpc-gateway",
+        "grpc_proxy.gateway.url": "http://127.0.0.1:4505",
+        "http.response.status_code": 200,
+      },
+    });
+    client.close();
+  });
+});
+
+describe(`tracing.ts: grpc-web`, () => {
+  test(`grpc-trace-bin is sent with traceparent`, async () => {
+    const { tracer, spans } = createFakeTracer();
+    const client = clientWithGrpcWebTracer(tracer) as GreeterClient;
+    const metadata = await sayHello(client, "huk");
+    expect(metadata.get("echo-traceparent")).toEqual([
+      traceparent("0000000000000001"),
+    ]);
+    expect(metadata.get("echo-grpc-trace-bin")).toEqual([
+      "AAAK92UZFs1D3YRI6yEcgDGcAQAAAAAAAAABAgE=",
+    ]);
+    expect(spans[0].attributes["grpc_proxy.transport"]).toBe("grpc-web");
+    client.close();
+  });
+});
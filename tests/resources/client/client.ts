@@ -3,7 +3,14 @@ import * as grpc from "@grpc/grpc-js";
 import { interceptor } from "../../../src";
 import { fileURLToPath, URL } from "node:url";
 import * as protoLoader from "@grpc/proto-loader";
+import { grpcWebChannel, openapiChannel } from "../../../src";
+import type { HttpClient } from "../../../src";
 import { openapiInterceptorSync } from "../../../src";
+import { recordClient, replayClient } from "../../../src";
+import type { Tracer } from "@opentelemetry/api";
+import type { ProxyMetrics } from "../../../src";
+import type { ProxyLogger } from "../../../src";
+import type { ValidationOptions } from "../../../src";
 
 const dirname = fileURLToPath(new URL(".", import.meta.url));
 
@@ -47,6 +54,20 @@ const GreeterClient = grpcObject.example.greeter.v1.services
 const GreeterClientV2 = grpcObjectV2.example.greeter.v2.services
   .Greeter as typeof grpc.Client;
 
+// PROXY_FIXTURES=record 时把 http 交互录制到 tests/resources/fixtures
+// PROXY_FIXTURES=replay 时从 fixture 回放，不需要启动 grpc-gateway
+const fixtureDir = resolve(dirname, "../fixtures");
+
+function fixtureClient(): HttpClient | undefined {
+  switch (process.env.PROXY_FIXTURES) {
+    case "record":
+      return recordClient({ dir: fixtureDir });
+    case "replay":
+      return replayClient({ dir: fixtureDir });
+  }
+  return undefined;
+}
+
 export const client = new GreeterClient(
   "127.0.0.1:9091",
   grpc.credentials.createInsecure()
@@ -59,6 +80,7 @@ export function clientWithInterceptor() {
     {
       interceptors: [
         openapiInterceptorSync({
+          httpClient: fixtureClient(),
           // grpc-getaway 服务地址
           getaway: "http://127.0.0.1:4501",
           // openapi 文件输出目录
@@ -78,6 +100,7 @@ export function clientWithGrpcWeb() {
       interceptors: [
         interceptor({
           enable: true,
+          httpClient: fixtureClient(),
           // grpc-getaway 服务地址
           getaway: "http://127.0.0.1:4501",
         }),
@@ -85,3 +108,426 @@ export function clientWithGrpcWeb() {
     }
   );
 }
+
+// 不连接 gRPC 端口，完全通过 grpc-gateway 调用
+export async function clientWithOpenapiChannel() {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      channelOverride: await openapiChannel({
+        getaway: "http://127.0.0.1:4501",
+        openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        definition: packageDefinition,
+      }),
+    }
+  );
+}
+
+export function clientWithGrpcWebChannel() {
+  return new GreeterClientV2(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      channelOverride: grpcWebChannel({
+        getaway: "http://127.0.0.1:4501",
+        definition: packageDefinitionV2,
+      }),
+    }
+  );
+}
+
+// before 在代理拦截器之前执行，after 由代理拦截器在接管调用之前执行
+export function clientWithInterceptorChain(
+  before: grpc.Interceptor,
+  after: grpc.Interceptor
+) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        before,
+        openapiInterceptorSync({
+          getaway: "http://127.0.0.1:4501",
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+          interceptors: [after],
+        }),
+      ],
+    }
+  );
+}
+
+// after 排在代理拦截器之后，代理拦截器无法执行它，调用会失败
+export function clientWithDownstreamInterceptor(
+  before: grpc.Interceptor,
+  after: grpc.Interceptor
+) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        before,
+        openapiInterceptorSync({
+          getaway: "http://127.0.0.1:4501",
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+          logger: {},
+        }),
+        after,
+      ],
+    }
+  );
+}
+
+// 开启自动切换，通过 grpc-gateway 发送时 after 同样会被执行
+export function clientWithFallbackChain(
+  before: grpc.Interceptor,
+  after: grpc.Interceptor,
+  fallback: { prefer: "direct" | "proxy" },
+  address = "127.0.0.1:9091"
+) {
+  return new GreeterClient(address, grpc.credentials.createInsecure(), {
+    interceptors: [
+      before,
+      openapiInterceptorSync({
+        fallback,
+        getaway: "http://127.0.0.1:4501",
+        openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        idempotent: ["/example.greeter.v1.services.Greeter/EqMetadata"],
+        interceptors: [after],
+      }),
+    ],
+  });
+}
+
+// 使用 Channel 时所有的拦截器都会在 http 调用之前执行
+export async function clientWithChannelChain(
+  before: grpc.Interceptor,
+  after: grpc.Interceptor
+) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [before, after],
+      channelOverride: await openapiChannel({
+        getaway: "http://127.0.0.1:4501",
+        openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        definition: packageDefinition,
+      }),
+    }
+  );
+}
+
+// 两个 grpc-gateway 实例和一个无法连接的地址，无法连接的地址会被健康检查摘除
+export function loadBalancingInterceptor() {
+  return openapiInterceptorSync({
+    getaway: [
+      "http://127.0.0.1:4501",
+      "http://127.0.0.1:4502",
+      "http://127.0.0.1:4509",
+    ],
+    openapiDir: resolve(dirname, "../grpc-server/openapi"),
+    loadBalancing: {
+      policy: "round_robin",
+      healthCheck: { interval: 200 },
+    },
+  });
+}
+
+export function clientWithLoadBalancing(proxy: grpc.Interceptor) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    { interceptors: [proxy] }
+  );
+}
+
+// getaway 是异步函数或者 GatewayResolver
+export function clientWithResolvedGetaway(
+  getaway: Parameters<typeof openapiInterceptorSync>[0]["getaway"]
+) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        openapiInterceptorSync({
+          getaway,
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        }),
+      ],
+    }
+  );
+}
+
+// 使用自定义的 http 客户端发送请求
+export function clientWithHttpClient(httpClient: HttpClient) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        openapiInterceptorSync({
+          httpClient,
+          getaway: "http://127.0.0.1:4501",
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        }),
+      ],
+    }
+  );
+}
+
+// -tls 模式下测试服务生成的证书
+export const certsDir = resolve(dirname, "../grpc-server/certs");
+
+export function clientWithTls(
+  getaway: string,
+  tls: Parameters<typeof openapiInterceptorSync>[0]["tls"]
+) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        openapiInterceptorSync({
+          tls,
+          getaway,
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        }),
+      ],
+    }
+  );
+}
+
+// 通过代理访问 grpc-gateway
+export function clientWithProxy(
+  getaway: string,
+  proxy: Parameters<typeof openapiInterceptorSync>[0]["proxy"],
+  tls?: Parameters<typeof openapiInterceptorSync>[0]["tls"]
+) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        openapiInterceptorSync({
+          tls,
+          proxy,
+          getaway,
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        }),
+      ],
+    }
+  );
+}
+
+// 4503 端口的 grpc-gateway 需要 bearer token
+export function clientWithCredentials(
+  callCredentials: Parameters<typeof openapiInterceptorSync>[0]["credentials"]
+) {
+  return new GreeterClient(
+    "127.0.0.1:9093",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        openapiInterceptorSync({
+          credentials: callCredentials,
+          getaway: "http://127.0.0.1:4503",
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        }),
+      ],
+    }
+  );
+}
+
+export async function clientWithCredentialsChannel(
+  callCredentials: Parameters<typeof openapiChannel>[0]["credentials"]
+) {
+  return new GreeterClient(
+    "127.0.0.1:9093",
+    grpc.credentials.createInsecure(),
+    {
+      channelOverride: await openapiChannel({
+        credentials: callCredentials,
+        getaway: "http://127.0.0.1:4503",
+        openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        definition: packageDefinition,
+      }),
+    }
+  );
+}
+
+// 通过 OAuth2 获取访问 4503 端口的 grpc-gateway 的 token
+export function clientWithOAuth2(
+  oauth2: Parameters<typeof openapiInterceptorSync>[0]["oauth2"]
+) {
+  return new GreeterClient(
+    "127.0.0.1:9093",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        openapiInterceptorSync({
+          oauth2,
+          getaway: "http://127.0.0.1:4503",
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        }),
+      ],
+    }
+  );
+}
+
+// 发送前对请求签名
+export function clientWithSignRequest(
+  signRequest: Parameters<typeof openapiInterceptorSync>[0]["signRequest"]
+) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        openapiInterceptorSync({
+          signRequest,
+          getaway: "http://127.0.0.1:4501",
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        }),
+      ],
+    }
+  );
+}
+
+// 4504 端口的 grpc-gateway 使用自定义的 header matcher
+export function clientWithHeaders(
+  headers: Parameters<typeof openapiInterceptorSync>[0]["headers"]
+) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        openapiInterceptorSync({
+          headers,
+          getaway: "http://127.0.0.1:4504",
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        }),
+      ],
+    }
+  );
+}
+
+// 指定 openapi 目录的拦截器，用于测试加载状态
+export function openapiDirInterceptor(openapiDir: string) {
+  return openapiInterceptorSync({
+    openapiDir,
+    getaway: "http://127.0.0.1:4501",
+    readyTimeout: 1000,
+  });
+}
+
+// 4505 端口的 grpc-gateway 会把链路头传给 gRPC 服务，服务通过 echo- 前缀的 metadata 回显
+export function clientWithTracer(tracer: Tracer) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        openapiInterceptorSync({
+          tracer,
+          getaway: "http://127.0.0.1:4505",
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        }),
+      ],
+    }
+  );
+}
+
+export function clientWithGrpcWebTracer(tracer: Tracer) {
+  return new GreeterClientV2(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        interceptor({ enable: true, tracer, getaway: "http://127.0.0.1:4505" }),
+      ],
+    }
+  );
+}
+
+export function clientWithMetrics(metrics: ProxyMetrics) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        openapiInterceptorSync({
+          metrics,
+          getaway: "http://127.0.0.1:4501",
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        }),
+      ],
+    }
+  );
+}
+
+export function clientWithLogger(logger: ProxyLogger) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        openapiInterceptorSync({
+          logger,
+          getaway: "http://127.0.0.1:4501",
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        }),
+      ],
+    }
+  );
+}
+
+// 使用指定的 grpc-gateway 地址，如 MockGateway 的 url
+export function clientWithGateway(getaway: string) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        openapiInterceptorSync({
+          getaway,
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        }),
+      ],
+    }
+  );
+}
+
+// 按 openapi 的 schema 校验消息，grpc-gateway 使用 MockGateway 的 url
+export function clientWithValidation(
+  getaway: string,
+  validation: ValidationOptions,
+  logger?: ProxyLogger
+) {
+  return new GreeterClient(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    {
+      interceptors: [
+        openapiInterceptorSync({
+          getaway,
+          logger,
+          validation,
+          openapiDir: resolve(dirname, "../grpc-server/openapi"),
+        }),
+      ],
+    }
+  );
+}
+
+export function clientWithGrpcWebGateway(getaway: string) {
+  return new GreeterClientV2(
+    "127.0.0.1:9091",
+    grpc.credentials.createInsecure(),
+    { interceptors: [interceptor({ getaway, enable: true })] }
+  );
+}
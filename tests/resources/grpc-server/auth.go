@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	greeter "example/genproto/greeter/v1/services"
+	greeterV2 "example/genproto/greeter/v2/services"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net"
+	"strings"
+)
+
+const (
+	// 需要 bearer token 的 gRPC 服务和它的 grpc-gateway
+	AuthGrpcAddr = ":9093"
+	AuthHttpAddr = ":4503"
+	// 测试使用的固定 token
+	BearerToken = "grpc-proxy-interceptor"
+)
+
+// BearerAuthInterceptor 校验 authorization: Bearer <token>，不匹配时返回 UNAUTHENTICATED
+func BearerAuthInterceptor(token string) grpc.UnaryServerInterceptor {
+	expected := []byte("Bearer " + token)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get("authorization")
+		if len(values) == 0 {
+			return nil, status.Error(codes.Unauthenticated, "missing authorization")
+		}
+		// grpc-gateway 会把 Authorization 头转换成 authorization metadata
+		actual := []byte(strings.TrimSpace(values[0]))
+		if subtle.ConstantTimeCompare(actual, expected) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "invalid bearer token")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// RunAuthServer 启动需要 bearer token 的 gRPC 服务，grpc-gateway 由调用方启动
+func RunAuthServer(grpcAddr string, token string) error {
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer(grpc.UnaryInterceptor(BearerAuthInterceptor(token)))
+	greeter.RegisterGreeterServer(server, &Greeter{})
+	greeterV2.RegisterGreeterServer(server, &GreeterV2{})
+	fmt.Printf("run grpc server with bearer auth in %s \n", grpcAddr)
+	return server.Serve(lis)
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	greeter "example/genproto/greeter/v1/services"
 	greeterV2 "example/genproto/greeter/v2/services"
+	"flag"
 	"fmt"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
@@ -20,7 +24,20 @@ import (
 
 const (
 	HttpAddr = ":4501"
-	GrpcAddr = ":9091"
+	// 第二个 grpc-gateway 实例，用于测试负载均衡和健康检查
+	HttpAddr2 = ":4502"
+	GrpcAddr  = ":9091"
+	// 使用自定义 header matcher 的 grpc-gateway，X-Request-Id 不加前缀
+	HeaderMatcherAddr = ":4504"
+	// -tls 模式下的 https grpc-gateway，客户端证书可选
+	HttpsAddr = ":4511"
+	// -tls 模式下要求客户端证书的 grpc-gateway
+	MtlsAddr = ":4512"
+)
+
+var (
+	enableTls = flag.Bool("tls", false, "同时启动 https 和 mTLS 的 grpc-gateway")
+	certsDir  = flag.String("certs", "certs", "-tls 模式下生成的证书的输出目录")
 )
 
 type Greeter struct {
@@ -112,18 +129,22 @@ func (g GreeterV2) Status(ctx context.Context, request *greeterV2.StatusRequest)
 }
 
 func main() {
+	flag.Parse()
+
 	lis, err := net.Listen("tcp", GrpcAddr)
 
 	if err != nil {
 		log.Panicf("failed to listen: %v", err)
 	}
 
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(TraceEchoInterceptor))
 
 	greeter.RegisterGreeterServer(grpcServer, &Greeter{})
 	greeterV2.RegisterGreeterServer(grpcServer, &GreeterV2{})
 
 	reflection.Register(grpcServer)
+	// grpc-gateway 通过 /healthz 暴露 grpc.health.v1.Health/Check
+	grpc_health_v1.RegisterHealthServer(grpcServer, health.NewServer())
 
 	go func() {
 		fmt.Printf("run grpc server in %s \n", GrpcAddr)
@@ -132,19 +153,95 @@ func main() {
 		}
 	}()
 
-	if err := RunGrpcHttpGateway(GrpcAddr); err != nil {
+	go func() {
+		if err := RunGrpcHttpGateway(GrpcAddr, HttpAddr2, nil); err != nil {
+			log.Panicf("failed to gateway serve: %v\n", err)
+		}
+	}()
+
+	go func() {
+		if err := RunGrpcHttpGateway(GrpcAddr, HeaderMatcherAddr, nil,
+			runtime.WithIncomingHeaderMatcher(RequestIdHeaderMatcher),
+			runtime.WithOutgoingHeaderMatcher(RequestIdOutgoingMatcher),
+		); err != nil {
+			log.Panicf("failed to gateway serve: %v\n", err)
+		}
+	}()
+
+	go func() {
+		if err := RunGrpcHttpGateway(GrpcAddr, TraceHttpAddr, nil,
+			runtime.WithIncomingHeaderMatcher(TraceHeaderMatcher),
+		); err != nil {
+			log.Panicf("failed to gateway serve: %v\n", err)
+		}
+	}()
+
+	go func() {
+		if err := RunAuthServer(AuthGrpcAddr, BearerToken); err != nil {
+			log.Panicf("failed to grpc serve: %v\n", err)
+		}
+	}()
+
+	go func() {
+		if err := RunGrpcHttpGateway(AuthGrpcAddr, AuthHttpAddr, nil); err != nil {
+			log.Panicf("failed to gateway serve: %v\n", err)
+		}
+	}()
+
+	if *enableTls {
+		certs, err := GenerateCerts(*certsDir)
+		if err != nil {
+			log.Panicf("failed to generate certs: %v\n", err)
+		}
+		go func() {
+			if err := RunGrpcHttpGateway(GrpcAddr, HttpsAddr, certs.ServerConfig(tls.VerifyClientCertIfGiven)); err != nil {
+				log.Panicf("failed to gateway serve: %v\n", err)
+			}
+		}()
+		go func() {
+			if err := RunGrpcHttpGateway(GrpcAddr, MtlsAddr, certs.ServerConfig(tls.RequireAndVerifyClientCert)); err != nil {
+				log.Panicf("failed to gateway serve: %v\n", err)
+			}
+		}()
+	}
+
+	if err := RunGrpcHttpGateway(GrpcAddr, HttpAddr, nil); err != nil {
 		log.Panicf("failed to gateway serve: %v\n", err)
 	}
 }
 
-func RunGrpcHttpGateway(grpcServerEndpoint string) error {
+// RequestIdHeaderMatcher X-Request-Id 直接作为 x-request-id metadata，其他的头使用默认的规则
+func RequestIdHeaderMatcher(key string) (string, bool) {
+	if key == "X-Request-Id" {
+		return "x-request-id", true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// RequestIdOutgoingMatcher x-request-id metadata 直接作为 X-Request-Id 响应头
+func RequestIdOutgoingMatcher(key string) (string, bool) {
+	if key == "x-request-id" {
+		return "X-Request-Id", true
+	}
+	return fmt.Sprintf("%s%s", runtime.MetadataHeaderPrefix, key), true
+}
+
+// RunGrpcHttpGateway tlsConfig 不为空时启动 https 服务
+func RunGrpcHttpGateway(grpcServerEndpoint string, httpAddr string, tlsConfig *tls.Config, muxOpts ...runtime.ServeMuxOption) error {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	mux := runtime.NewServeMux()
 	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
-	err := greeter.RegisterGreeterHandlerFromEndpoint(ctx, mux, grpcServerEndpoint, opts)
+	conn, err := grpc.DialContext(ctx, grpcServerEndpoint, opts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	muxOpts = append(muxOpts, runtime.WithHealthzEndpoint(grpc_health_v1.NewHealthClient(conn)))
+	mux := runtime.NewServeMux(muxOpts...)
+	err = greeter.RegisterGreeterHandlerFromEndpoint(ctx, mux, grpcServerEndpoint, opts)
 	if err != nil {
 		return err
 	}
@@ -153,6 +250,11 @@ func RunGrpcHttpGateway(grpcServerEndpoint string) error {
 		return err
 	}
 	// Start HTTP server (and proxy calls to gRPC server endpoint)
-	fmt.Printf("run grpc http proxy server in %s \n", HttpAddr)
-	return http.ListenAndServe(HttpAddr, mux)
+	if tlsConfig != nil {
+		fmt.Printf("run grpc https proxy server in %s \n", httpAddr)
+		server := &http.Server{Addr: httpAddr, Handler: mux, TLSConfig: tlsConfig}
+		return server.ListenAndServeTLS("", "")
+	}
+	fmt.Printf("run grpc http proxy server in %s \n", httpAddr)
+	return http.ListenAndServe(httpAddr, mux)
 }
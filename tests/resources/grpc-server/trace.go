@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"strings"
+)
+
+const (
+	// 把链路头作为 metadata 传给 gRPC 服务的 grpc-gateway
+	TraceHttpAddr = ":4505"
+	// 回显的 metadata 的前缀，如 echo-traceparent
+	TraceEchoPrefix = "echo-"
+)
+
+// 链路相关的 metadata key，grpc-gateway 的 DefaultHeaderMatcher 会丢弃这些头
+var traceKeys = []string{"traceparent", "tracestate", "grpc-trace-bin"}
+
+// TraceHeaderMatcher Traceparent、Tracestate、Grpc-Trace-Bin 头直接作为 metadata，其他的头使用默认的规则
+// grpc-gateway 会对 -Bin 结尾的头做 base64 解码
+func TraceHeaderMatcher(key string) (string, bool) {
+	switch key {
+	case "Traceparent", "Tracestate", "Grpc-Trace-Bin":
+		return strings.ToLower(key), true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// TraceEchoInterceptor 把收到的链路 metadata 加上 echo- 前缀放到响应的 header 中，grpc-trace-bin 使用 base64 编码
+func TraceEchoInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	echo := metadata.MD{}
+	for _, key := range traceKeys {
+		values := md.Get(key)
+		if len(values) == 0 {
+			continue
+		}
+		value := values[0]
+		if key == "grpc-trace-bin" {
+			value = base64.StdEncoding.EncodeToString([]byte(value))
+		}
+		echo.Set(TraceEchoPrefix+key, value)
+	}
+	if echo.Len() > 0 {
+		if err := grpc.SetHeader(ctx, echo); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
@@ -0,0 +1,34 @@
+import type { MockHandler } from "../../../src";
+
+/**
+ * 和 grpc-server/server.go 相同的实现，testlist 可以直接使用 mock 运行
+ * @param service {string} - 如 example.greeter.v1.services.Greeter
+ * @return {Record<string, MockHandler>}
+ */
+export function greeterHandlers(service: string): Record<string, MockHandler> {
+  return {
+    [`/${service}/SayHello`]: ({ message }) => ({
+      message: { message: `hello ${message.name}` },
+    }),
+    [`/${service}/Metadata`]: ({ message }) => ({
+      message: {},
+      metadata: message.metadata as Record<string, string>,
+    }),
+    [`/${service}/EqMetadata`]: ({ message, metadata }) => {
+      const expected = (message.metadata || {}) as Record<string, string>;
+      const ok = Object.entries(expected).every(
+        ([key, value]) => metadata[key] === value
+      );
+      return { message: { ok } };
+    },
+    [`/${service}/Trailer`]: ({ message }) => ({
+      message: {},
+      trailers: message.metadata as Record<string, string>,
+    }),
+    [`/${service}/Status`]: ({ message }) => ({
+      code: Number(message.status || 0),
+      details: (message.errorMsg as string) || "",
+      trailers: { buf: "buffer" },
+    }),
+  };
+}
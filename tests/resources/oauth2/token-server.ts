@@ -0,0 +1,77 @@
+import * as net from "node:net";
+import * as http from "node:http";
+
+export interface TokenServerOptions {
+  clientId: string;
+  clientSecret: string;
+  // 按顺序发放的 token，发完后一直使用最后一个
+  tokens: string[];
+  // 不配置时响应中没有 expires_in
+  expiresIn?: number;
+  // 响应前的延迟，单位毫秒，用于测试并发请求只获取一次 token
+  delay?: number;
+}
+
+export interface TokenServer {
+  url: string;
+  // 收到的 token 请求的参数
+  requests: URLSearchParams[];
+  close(): Promise<void>;
+}
+
+/**
+ * 测试用的 OAuth2 token 接口，只支持 client_credentials
+ * client 凭证可以通过 Basic 认证或者请求体发送，错误时返回 401 invalid_client
+ * @param opts {TokenServerOptions}
+ * @return {Promise<TokenServer>}
+ */
+export async function startTokenServer(
+  opts: TokenServerOptions
+): Promise<TokenServer> {
+  const requests: URLSearchParams[] = [];
+  let issued = 0;
+  const basic = `Basic ${Buffer.from(
+    `${opts.clientId}:${opts.clientSecret}`
+  ).toString("base64")}`;
+
+  const server = http.createServer((req, res) => {
+    let body = "";
+    req.on("data", (chunk) => (body += chunk));
+    req.on("end", () => {
+      const params = new URLSearchParams(body);
+      requests.push(params);
+      const reply = (statusCode: number, data: Record<string, unknown>) => {
+        setTimeout(() => {
+          res.writeHead(statusCode, { "Content-Type": "application/json" });
+          res.end(JSON.stringify(data));
+        }, opts.delay || 0);
+      };
+      const authorized =
+        req.headers.authorization === basic ||
+        (params.get("client_id") === opts.clientId &&
+          params.get("client_secret") === opts.clientSecret);
+      if (!authorized) {
+        reply(401, { error: "invalid_client" });
+        return;
+      }
+      if (params.get("grant_type") !== "client_credentials") {
+        reply(400, { error: "unsupported_grant_type" });
+        return;
+      }
+      const index = Math.min(issued++, opts.tokens.length - 1);
+      reply(200, {
+        access_token: opts.tokens[index],
+        token_type: "Bearer",
+        ...(opts.expiresIn ? { expires_in: opts.expiresIn } : {}),
+      });
+    });
+  });
+
+  await new Promise<void>((resolve) => server.listen(0, "127.0.0.1", resolve));
+  const { port } = server.address() as net.AddressInfo;
+  return {
+    requests,
+    url: `http://127.0.0.1:${port}/oauth2/token`,
+    close: () => new Promise((resolve) => server.close(() => resolve())),
+  };
+}
@@ -0,0 +1,100 @@
+import * as net from "node:net";
+import * as http from "node:http";
+
+export interface ConnectProxy {
+  url: string;
+  // 收到的请求，如：CONNECT 127.0.0.1:4511、GET http://127.0.0.1:4501/v1/...
+  requests: string[];
+  close(): Promise<void>;
+}
+
+// 不转发给 grpc-gateway 的头
+const hopByHop = [
+  "connection",
+  "keep-alive",
+  "proxy-authorization",
+  "proxy-connection",
+  "transfer-encoding",
+];
+
+/**
+ * 测试用的代理，支持 CONNECT 隧道和 http 的绝对路径请求
+ * 配置了 auth 时要求正确的 Proxy-Authorization，否则返回 407
+ * @param [auth] {{username: string; password: string}}
+ * @return {Promise<ConnectProxy>}
+ */
+export async function startConnectProxy(auth?: {
+  username: string;
+  password: string;
+}): Promise<ConnectProxy> {
+  const requests: string[] = [];
+  const expected =
+    auth &&
+    `Basic ${Buffer.from(`${auth.username}:${auth.password}`).toString(
+      "base64"
+    )}`;
+  const authorized = (req: http.IncomingMessage) =>
+    !expected || req.headers["proxy-authorization"] === expected;
+
+  const server = http.createServer((req, res) => {
+    requests.push(`${req.method} ${req.url}`);
+    if (!authorized(req)) {
+      res.writeHead(407, { "Proxy-Authenticate": "Basic" }).end();
+      return;
+    }
+    const headers = { ...req.headers };
+    hopByHop.forEach((key) => delete headers[key]);
+    const upstream = http.request(
+      req.url as string,
+      { method: req.method, headers },
+      (response) => {
+        const responseHeaders = { ...response.headers };
+        hopByHop.forEach((key) => delete responseHeaders[key]);
+        res.writeHead(response.statusCode as number, responseHeaders);
+        response.on("data", (chunk) => res.write(chunk));
+        response.on("end", () => {
+          res.addTrailers(response.trailers);
+          res.end();
+        });
+      }
+    );
+    upstream.on("error", () => res.writeHead(502).end());
+    req.pipe(upstream);
+  });
+
+  server.on("connect", (req, socket: net.Socket, head) => {
+    requests.push(`CONNECT ${req.url}`);
+    if (!authorized(req)) {
+      socket.end("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n");
+      return;
+    }
+    const [host, port] = (req.url as string).split(":");
+    const upstream = net.connect(Number(port), host, () => {
+      socket.write("HTTP/1.1 200 Connection Established\r\n\r\n");
+      upstream.write(head);
+      upstream.pipe(socket);
+      socket.pipe(upstream);
+    });
+    upstream.on("error", () => socket.destroy());
+    socket.on("error", () => upstream.destroy());
+  });
+
+  // keep-alive 和隧道的连接不会自动关闭，close 时需要主动断开
+  const sockets = new Set<net.Socket>();
+  server.on("connection", (socket) => {
+    sockets.add(socket);
+    socket.on("close", () => sockets.delete(socket));
+  });
+
+  await new Promise<void>((resolve) => server.listen(0, "127.0.0.1", resolve));
+  const { port } = server.address() as net.AddressInfo;
+  return {
+    requests,
+    url: `http://127.0.0.1:${port}`,
+    close: () =>
+      new Promise((resolve) => {
+        sockets.forEach((socket) => socket.destroy());
+        server.close(() => resolve());
+      }),
+  };
+}
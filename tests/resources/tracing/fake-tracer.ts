@@ -0,0 +1,59 @@
+import type { SpanContext, Tracer } from "@opentelemetry/api";
+
+// 记录下来的 span，用于断言
+export interface RecordedSpan {
+  name: string;
+  kind?: number;
+  attributes: Record<string, unknown>;
+  status?: { code: number; message?: string };
+  exceptions: unknown[];
+  ended: boolean;
+  context: SpanContext;
+}
+
+/**
+ * 测试用的 Tracer，不依赖 OpenTelemetry SDK，每个 span 使用固定的 traceId 和递增的 spanId
+ * @param [traceState] {string} - tracestate 的值
+ * @return {{tracer: Tracer, spans: RecordedSpan[]}}
+ */
+export function createFakeTracer(traceState?: string) {
+  const spans: RecordedSpan[] = [];
+  const tracer = {
+    startSpan(name: string, options: any = {}) {
+      const record: RecordedSpan = {
+        name,
+        kind: options.kind,
+        attributes: { ...options.attributes },
+        exceptions: [],
+        ended: false,
+        context: {
+          traceId: "0af7651916cd43dd8448eb211c80319c",
+          spanId: (spans.length + 1).toString(16).padStart(16, "0"),
+          traceFlags: 1,
+          traceState: traceState
+            ? ({ serialize: () => traceState } as SpanContext["traceState"])
+            : undefined,
+        },
+      };
+      spans.push(record);
+      return {
+        spanContext: () => record.context,
+        setAttribute(key: string, value: unknown) {
+          record.attributes[key] = value;
+          return this;
+        },
+        setStatus(status: RecordedSpan["status"]) {
+          record.status = status;
+          return this;
+        },
+        recordException(exception: unknown) {
+          record.exceptions.push(exception);
+        },
+        end() {
+          record.ended = true;
+        },
+      };
+    },
+  } as unknown as Tracer;
+  return { tracer, spans };
+}
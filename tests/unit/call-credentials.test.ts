@@ -0,0 +1,93 @@
+import { credentials, Metadata, status } from "@grpc/grpc-js";
+import { CallResult } from "../../src/grpc-utils";
+import { toMetadataHeader } from "../../src/openapi-utils";
+import {
+  getServiceUrl,
+  toCallCredentials,
+  withCallCredentials,
+} from "../../src/call-credentials";
+import { TransportError } from "../../src/proxy-error";
+
+function bearer(token: string) {
+  return credentials.createFromMetadataGenerator((options, callback) => {
+    const md = new Metadata();
+    md.set("authorization", `Bearer ${token}`);
+    md.set("x-service-url", options.service_url);
+    callback(null, md);
+  });
+}
+
+function echoHandler() {
+  return jest.fn(
+    async (message: any, metadata: Metadata) =>
+      ({ response: message, metadata } as unknown as CallResult<any>)
+  );
+}
+
+describe("call-credentials: withCallCredentials", () => {
+  test("merge generated metadata", async () => {
+    const handler = echoHandler();
+    const metadata = new Metadata();
+    metadata.set("x-user-id", "1");
+    const result = await withCallCredentials(
+      handler,
+      toCallCredentials(bearer("token")),
+      getServiceUrl("http://127.0.0.1:4501", "/pkg.Greeter/SayHello")
+    )({}, metadata);
+    expect(result.metadata.get("authorization")).toEqual(["Bearer token"]);
+    expect(result.metadata.get("x-user-id")).toEqual(["1"]);
+    expect(result.metadata.get("x-service-url")).toEqual([
+      "https://127.0.0.1:4501/pkg.Greeter",
+    ]);
+    // 不修改调用方的 metadata
+    expect(metadata.get("authorization")).toEqual([]);
+  });
+
+  test("compose channel and call credentials", async () => {
+    const channel = credentials.combineChannelCredentials(
+      credentials.createSsl(),
+      bearer("channel")
+    );
+    const extra = credentials.createFromMetadataGenerator((_, callback) => {
+      const md = new Metadata();
+      md.set("x-api-key", "key");
+      callback(null, md);
+    });
+    const handler = echoHandler();
+    const result = await withCallCredentials(
+      handler,
+      toCallCredentials(channel, extra),
+      ""
+    )({}, new Metadata());
+    expect(result.metadata.get("authorization")).toEqual(["Bearer channel"]);
+    expect(result.metadata.get("x-api-key")).toEqual(["key"]);
+    expect(toCallCredentials(undefined, null)).toBeNull();
+  });
+
+  test("generator error returns UNAVAILABLE", async () => {
+    const failed = credentials.createFromMetadataGenerator((_, callback) =>
+      callback(new Error("token expired"))
+    );
+    const handler = echoHandler();
+    const result = await withCallCredentials(
+      handler,
+      failed,
+      ""
+    )({}, new Metadata());
+    expect(handler).not.toHaveBeenCalled();
+    expect(result.status.code).toBe(status.UNAVAILABLE);
+    expect(result.status.details).toContain("token expired");
+    expect(result.error).toBeInstanceOf(TransportError);
+  });
+
+  test("authorization is sent as Authorization header", () => {
+    const metadata = new Metadata();
+    metadata.set("authorization", "Bearer token");
+    metadata.set("x-user-id", "1");
+    expect(toMetadataHeader(metadata)).toEqual({
+      TE: "trailers",
+      Authorization: "Bearer token",
+      "Grpc-Metadata-x-user-id": "1",
+    });
+  });
+});
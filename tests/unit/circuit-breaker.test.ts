@@ -0,0 +1,101 @@
+import { Metadata, status } from "@grpc/grpc-js";
+import { CallResult } from "../../src/grpc-utils";
+import { ProxyRequest } from "../../src/http-transport";
+import { TransportError } from "../../src/proxy-error";
+import {
+  CircuitBreaker,
+  CircuitStateEvent,
+} from "../../src/circuit-breaker";
+
+const request: ProxyRequest = {
+  callPath: "/example.greeter.v1.services.Greeter/SayHello",
+  baseUrl: "http://127.0.0.1:4501",
+  config: {},
+};
+
+function result(httpStatus: number) {
+  return {
+    response: null,
+    metadata: new Metadata(),
+    status: { code: status.OK, details: "", metadata: new Metadata() },
+    http: { status: httpStatus, headers: {}, gateway: "" },
+  } as CallResult<any>;
+}
+
+describe("circuit-breaker: CircuitBreaker", () => {
+  afterEach(() => {
+    jest.useRealTimers();
+  });
+
+  test("open, half-open and close again", async () => {
+    jest.useFakeTimers({ now: 0 });
+    const events: CircuitStateEvent[] = [];
+    const breaker = new CircuitBreaker(
+      { minimumRequests: 2, failureRatio: 0.5, openDuration: 1000 },
+      (event) => events.push(event)
+    );
+    const middleware = breaker.middleware();
+    const failed = jest.fn(async () => result(503));
+    const ok = jest.fn(async () => result(200));
+
+    await middleware(request, ok);
+    await middleware(request, failed);
+    expect(breaker.getState(request.baseUrl)).toBe("open");
+
+    const rejected = await middleware(request, ok);
+    expect(rejected.status.code).toBe(status.UNAVAILABLE);
+    expect(rejected.status.details).toContain("circuit breaker is open");
+    expect(rejected.status.details).toContain(
+      "retry after 1970-01-01T00:00:01"
+    );
+    expect(rejected.error).toBeInstanceOf(TransportError);
+    expect(ok).toHaveBeenCalledTimes(1);
+
+    jest.setSystemTime(1000);
+    await middleware(request, ok);
+    expect(breaker.getState(request.baseUrl)).toBe("closed");
+    expect(events.map(({ to }) => to)).toEqual(["open", "half-open", "closed"]);
+  });
+
+  test("calls during the probe are rejected without a retry time", async () => {
+    jest.useFakeTimers({ now: 0 });
+    const breaker = new CircuitBreaker(
+      { minimumRequests: 1, openDuration: 1000 },
+      () => 0
+    );
+    const middleware = breaker.middleware();
+    await middleware(request, async () => result(503));
+    jest.setSystemTime(1000);
+    let release = () => {};
+    const probe = middleware(
+      request,
+      () =>
+        new Promise<CallResult<any>>((resolve) => {
+          release = () => resolve(result(200));
+        })
+    );
+    const rejected = await middleware(request, async () => result(200));
+    expect(rejected.status.details).toContain("circuit breaker is half-open");
+    expect(rejected.status.details).toContain("probe request in flight");
+    expect(rejected.status.details).not.toContain("retry after");
+    release();
+    await probe;
+    expect(breaker.getState(request.baseUrl)).toBe("closed");
+  });
+
+  test("a failed probe opens the circuit again", async () => {
+    jest.useFakeTimers({ now: 0 });
+    const breaker = new CircuitBreaker(
+      { minimumRequests: 1, openDuration: 1000, perMethod: true },
+      () => 0
+    );
+    const middleware = breaker.middleware();
+    const failed = async () => result(502);
+    const key = `${request.baseUrl}${request.callPath}`;
+    await middleware(request, failed);
+    expect(breaker.getState(key)).toBe("open");
+    jest.setSystemTime(1000);
+    await middleware(request, failed);
+    expect(breaker.getState(key)).toBe("open");
+  });
+});
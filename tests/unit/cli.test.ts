@@ -0,0 +1,90 @@
+import { parseVerifyArgs, runCli, USAGE } from "../../src/cli";
+
+function output() {
+  const lines = { stdout: [] as string[], stderr: [] as string[] };
+  return {
+    lines,
+    stdout: (text: string) => lines.stdout.push(text),
+    stderr: (text: string) => lines.stderr.push(text),
+  };
+}
+
+describe("cli: parseVerifyArgs", () => {
+  test("defaults", () => {
+    expect(parseVerifyArgs(["-g", "http://127.0.0.1:4501"])).toEqual({
+      gateway: "http://127.0.0.1:4501",
+      openapiDir: "openapi",
+      format: "text",
+    });
+  });
+
+  test("every option", () => {
+    expect(
+      parseVerifyArgs([
+        "--gateway=http://127.0.0.1:4501",
+        "--openapi-dir",
+        "./api",
+        "-s",
+        "/a.swagger.json",
+        "--swagger",
+        "/b.swagger.json",
+        "--probe-method",
+        "head",
+        "-H",
+        "Authorization: Bearer a:b",
+        "--format",
+        "json",
+      ])
+    ).toEqual({
+      gateway: "http://127.0.0.1:4501",
+      openapiDir: "./api",
+      swagger: ["/a.swagger.json", "/b.swagger.json"],
+      probeMethod: "HEAD",
+      headers: { Authorization: "Bearer a:b" },
+      format: "json",
+    });
+  });
+
+  test("invalid arguments", () => {
+    expect(() => parseVerifyArgs([])).toThrow("--gateway is required");
+    expect(() => parseVerifyArgs(["-g"])).toThrow("-g requires a value");
+    expect(() => parseVerifyArgs(["-g", "x", "--format", "xml"])).toThrow(
+      "unsupported format xml"
+    );
+    expect(() => parseVerifyArgs(["-g", "x", "-H", "token"])).toThrow(
+      "invalid header token"
+    );
+    expect(() => parseVerifyArgs(["-g", "x", "--verbose"])).toThrow(
+      "unknown option --verbose"
+    );
+  });
+});
+
+describe("cli: runCli", () => {
+  test("usage", async () => {
+    const out = output();
+    expect(await runCli(["--help"], out)).toBe(0);
+    expect(out.lines.stdout).toEqual([USAGE]);
+    expect(await runCli([], out)).toBe(2);
+  });
+
+  test("usage errors exit with 2", async () => {
+    const out = output();
+    expect(await runCli(["check"], out)).toBe(2);
+    expect(await runCli(["verify"], out)).toBe(2);
+    expect(out.lines.stderr[0]).toMatch(/^unknown command check/);
+    expect(out.lines.stderr[1]).toMatch(/^--gateway is required/);
+  });
+
+  test("errors while verifying exit with 2", async () => {
+    const out = output();
+    const code = await runCli(
+      ["verify", "-g", "http://127.0.0.1:1", "-d", "./does-not-exist"],
+      out
+    );
+    expect(code).toBe(2);
+    expect(out.lines.stderr[0]).toMatch(
+      /^verify failed: openapi directory .* does not exist$/
+    );
+  });
+});
@@ -0,0 +1,172 @@
+import { OpenAPIV2 } from "openapi-types";
+import {
+  collectRoutes,
+  diffRoutes,
+  DriftReport,
+  formatDriftReport,
+} from "../../src/contract-drift";
+
+const tag = "example.Users";
+
+function document(paths: Record<string, unknown>): OpenAPIV2.Document {
+  return {
+    swagger: "2.0",
+    info: { title: "test", version: "1" },
+    parameters: {
+      view: { name: "view", in: "query", type: "string" },
+    },
+    paths,
+  } as unknown as OpenAPIV2.Document;
+}
+
+const local = document({
+  "/v1/users/{id}": {
+    parameters: [{ name: "id", in: "path", required: true, type: "string" }],
+    get: {
+      tags: [tag],
+      operationId: "Users_GetUser",
+      parameters: [{ $ref: "#/parameters/view" }],
+    },
+    delete: { tags: [tag], operationId: "Users_DeleteUser" },
+  },
+  "/v1/users": {
+    get: { operationId: "ListUsers" },
+  },
+});
+
+describe("contract-drift: collectRoutes", () => {
+  test("path parameters and $ref", () => {
+    expect(collectRoutes([local])).toEqual([
+      {
+        id: "/example.Users/GetUser",
+        operationId: "Users_GetUser",
+        method: "get",
+        path: "/v1/users/{id}",
+        parameters: [
+          { name: "id", in: "path", required: true, type: "string" },
+          { name: "view", in: "query", required: false, type: "string" },
+        ],
+      },
+      {
+        id: "/example.Users/DeleteUser",
+        operationId: "Users_DeleteUser",
+        method: "delete",
+        path: "/v1/users/{id}",
+        parameters: [
+          { name: "id", in: "path", required: true, type: "string" },
+        ],
+      },
+      // 没有 tag 时使用 operationId
+      {
+        id: "ListUsers",
+        operationId: "ListUsers",
+        method: "get",
+        path: "/v1/users",
+        parameters: [],
+      },
+    ]);
+  });
+});
+
+describe("contract-drift: diffRoutes", () => {
+  const remote = document({
+    "/v2/users/{id}": {
+      post: {
+        tags: [tag],
+        operationId: "Users_GetUser",
+        parameters: [
+          { name: "id", in: "path", required: true, type: "integer" },
+          { name: "fields", in: "query", type: "string" },
+        ],
+      },
+    },
+    "/v1/users": {
+      get: { operationId: "ListUsers" },
+      post: { tags: [tag], operationId: "Users_CreateUser" },
+    },
+  });
+
+  test("missing, changed and extra routes", () => {
+    const diff = diffRoutes(collectRoutes([local]), collectRoutes([remote]));
+    expect(diff.missing).toEqual([
+      {
+        id: "/example.Users/DeleteUser",
+        operationId: "Users_DeleteUser",
+        method: "delete",
+        path: "/v1/users/{id}",
+      },
+    ]);
+    expect(diff.extra).toEqual([
+      {
+        id: "/example.Users/CreateUser",
+        operationId: "Users_CreateUser",
+        method: "post",
+        path: "/v1/users",
+      },
+    ]);
+    expect(diff.changed).toHaveLength(1);
+    expect(diff.changed[0].changes).toEqual([
+      { field: "method", expected: "get", actual: "post" },
+      { field: "path", expected: "/v1/users/{id}", actual: "/v2/users/{id}" },
+      {
+        field: "parameter",
+        name: "path.id",
+        expected: { name: "id", in: "path", required: true, type: "string" },
+        actual: { name: "id", in: "path", required: true, type: "integer" },
+      },
+      {
+        field: "parameter",
+        name: "query.view",
+        expected: {
+          name: "view",
+          in: "query",
+          required: false,
+          type: "string",
+        },
+        actual: null,
+      },
+      {
+        field: "parameter",
+        name: "query.fields",
+        expected: null,
+        actual: {
+          name: "fields",
+          in: "query",
+          required: false,
+          type: "string",
+        },
+      },
+    ]);
+  });
+
+  test("identical documents", () => {
+    const routes = collectRoutes([local]);
+    expect(diffRoutes(routes, routes)).toEqual({
+      missing: [],
+      changed: [],
+      extra: [],
+    });
+  });
+
+  test("formatDriftReport", () => {
+    const report: DriftReport = {
+      source: "swagger",
+      gateway: "http://127.0.0.1:4501",
+      ok: false,
+      checked: 3,
+      ...diffRoutes(collectRoutes([local]), collectRoutes([remote])),
+    };
+    expect(formatDriftReport(report).split("\n")).toEqual([
+      "checked 3 routes against http://127.0.0.1:4501 (swagger): " +
+        "1 missing, 1 changed, 1 extra",
+      "  missing /example.Users/DeleteUser DELETE /v1/users/{id}",
+      "  changed /example.Users/GetUser GET /v1/users/{id}",
+      "    method get -> post",
+      "    path /v1/users/{id} -> /v2/users/{id}",
+      "    parameter path.id type string -> integer",
+      "    parameter query.view is missing",
+      "    parameter query.fields is extra",
+      "  extra   /example.Users/CreateUser POST /v1/users",
+    ]);
+  });
+});
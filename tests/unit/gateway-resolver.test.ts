@@ -0,0 +1,162 @@
+import { tmpdir } from "node:os";
+import { join } from "node:path";
+import { promises as dns } from "node:dns";
+import { mkdtemp, rename, writeFile } from "node:fs/promises";
+import { status } from "@grpc/grpc-js";
+import { LoadBalancer } from "../../src/load-balancer";
+import {
+  CachedResolver,
+  dnsSrvResolver,
+  envResolver,
+  fileResolver,
+} from "../../src/gateway-resolver";
+
+const flush = () => new Promise((resolve) => setImmediate(resolve));
+
+describe("gateway-resolver: CachedResolver", () => {
+  afterEach(() => {
+    jest.restoreAllMocks();
+  });
+
+  test("cache until ttl, then refresh in background", async () => {
+    const now = jest.spyOn(Date, "now").mockReturnValue(0);
+    let version = 0;
+    const load = jest.fn(async () => [`http://127.0.0.1:450${++version}`]);
+    const resolver = new CachedResolver(load, 1000);
+    const [first, second] = await Promise.all([
+      resolver.resolve(),
+      resolver.resolve(),
+    ]);
+    expect(first).toEqual(["http://127.0.0.1:4501"]);
+    expect(second).toBe(first);
+    expect(load).toHaveBeenCalledTimes(1);
+
+    now.mockReturnValue(1000);
+    // 过期后先返回旧的结果
+    expect(await resolver.resolve()).toBe(first);
+    await flush();
+    expect(await resolver.resolve()).toEqual(["http://127.0.0.1:4502"]);
+    expect(load).toHaveBeenCalledTimes(2);
+  });
+
+  test("keep the last result when refreshing fails", async () => {
+    const now = jest.spyOn(Date, "now").mockReturnValue(0);
+    const load = jest
+      .fn()
+      .mockResolvedValueOnce(["http://127.0.0.1:4501"])
+      .mockRejectedValue(new Error("boom"));
+    const resolver = new CachedResolver(load, 1000);
+    const first = await resolver.resolve();
+    now.mockReturnValue(1000);
+    await resolver.resolve();
+    await flush();
+    expect(load).toHaveBeenCalledTimes(2);
+    expect(await resolver.resolve()).toBe(first);
+  });
+
+  test("throw when nothing was resolved", async () => {
+    const resolver = new CachedResolver(async () => {
+      throw new Error("boom");
+    });
+    await expect(resolver.resolve()).rejects.toThrow("boom");
+  });
+});
+
+describe("gateway-resolver: built-in resolvers", () => {
+  test("dnsSrvResolver", async () => {
+    jest.spyOn(dns, "resolveSrv").mockResolvedValue([
+      { name: "a.example.com", port: 4501, priority: 10, weight: 3 },
+      { name: "b.example.com", port: 4502, priority: 10, weight: 1 },
+      { name: "c.example.com", port: 4503, priority: 20, weight: 1 },
+    ]);
+    const resolver = dnsSrvResolver("_http._tcp.gateway.example.com");
+    expect(await resolver.resolve()).toEqual([
+      { url: "http://a.example.com:4501", weight: 3 },
+      { url: "http://b.example.com:4502", weight: 1 },
+    ]);
+  });
+
+  test("envResolver", async () => {
+    process.env.TEST_GATEWAY_ENDPOINTS =
+      "http://127.0.0.1:4501, http://127.0.0.1:4502";
+    expect(await envResolver("TEST_GATEWAY_ENDPOINTS").resolve()).toEqual([
+      "http://127.0.0.1:4501",
+      "http://127.0.0.1:4502",
+    ]);
+    await expect(envResolver("TEST_GATEWAY_UNSET").resolve()).rejects.toThrow(
+      "TEST_GATEWAY_UNSET"
+    );
+  });
+
+  test("fileResolver", async () => {
+    const path = join(await mkdtemp(join(tmpdir(), "gateway-")), "a.json");
+    await writeFile(path, JSON.stringify([{ url: "http://127.0.0.1:4501" }]));
+    const resolver = fileResolver(path);
+    expect(await resolver.resolve()).toEqual([
+      { url: "http://127.0.0.1:4501" },
+    ]);
+    resolver.close?.();
+
+    await writeFile(path, JSON.stringify(["127.0.0.1:4501"]));
+    await expect(fileResolver(path).resolve()).rejects.toThrow("invalid");
+  });
+
+  test("fileResolver follows atomic replaces", async () => {
+    const dir = await mkdtemp(join(tmpdir(), "gateway-"));
+    const path = join(dir, "a.json");
+    await writeFile(path, JSON.stringify(["http://127.0.0.1:4501"]));
+    const resolver = fileResolver(path, { ttl: 60000 });
+    expect(await resolver.resolve()).toEqual(["http://127.0.0.1:4501"]);
+    // 写入临时文件后 rename，每次替换之后都应该能收到变化
+    for (const port of [4502, 4503]) {
+      const tmp = join(dir, `.a.json.${port}`);
+      await writeFile(tmp, JSON.stringify([`http://127.0.0.1:${port}`]));
+      await rename(tmp, path);
+      let list = await resolver.resolve();
+      for (let i = 0; i < 50 && list[0] !== `http://127.0.0.1:${port}`; i++) {
+        await new Promise((resolve) => setTimeout(resolve, 20));
+        list = await resolver.resolve();
+      }
+      expect(list).toEqual([`http://127.0.0.1:${port}`]);
+    }
+    resolver.close?.();
+  });
+});
+
+describe("gateway-resolver: LoadBalancer", () => {
+  test("pick from resolved endpoints", async () => {
+    let list = ["http://127.0.0.1:4501"];
+    const balancer = new LoadBalancer(
+      { resolve: async () => list },
+      {},
+      () => 0
+    );
+    const middleware = balancer.middleware();
+    const sent: string[] = [];
+    const next = async (req: any) => {
+      sent.push(req.baseUrl);
+      return {} as any;
+    };
+    const request = { callPath: "/a/b", baseUrl: "", config: {} };
+    await middleware(request, next);
+    list = ["http://127.0.0.1:4502"];
+    await middleware(request, next);
+    expect(sent).toEqual(["http://127.0.0.1:4501", "http://127.0.0.1:4502"]);
+  });
+
+  test("UNAVAILABLE when resolving fails", async () => {
+    const balancer = new LoadBalancer(
+      { resolve: () => Promise.reject(new Error("boom")) },
+      {},
+      () => 0
+    );
+    const next = jest.fn();
+    const result = await balancer.middleware()(
+      { callPath: "/a/b", baseUrl: "", config: {} },
+      next
+    );
+    expect(next).not.toHaveBeenCalled();
+    expect(result.status.code).toBe(status.UNAVAILABLE);
+    expect(result.status.details).toContain("boom");
+  });
+});
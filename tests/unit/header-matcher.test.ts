@@ -0,0 +1,99 @@
+import { Metadata } from "@grpc/grpc-js";
+import {
+  canonicalHeaderKey,
+  defaultRequestHeaderMatcher,
+  defaultResponseHeaderMatcher,
+} from "../../src/header-matcher";
+import {
+  getMetadataFromHeader,
+  toMetadataHeader,
+} from "../../src/openapi-utils";
+
+describe("header-matcher: default matchers", () => {
+  test("request: permanent headers are sent as is", () => {
+    expect(defaultRequestHeaderMatcher("authorization")).toEqual([
+      "Authorization",
+      true,
+    ]);
+    expect(defaultRequestHeaderMatcher("cookie")).toEqual(["Cookie", true]);
+    // grpc-gateway 会把 Cookie 转换成 grpcgateway-cookie
+    expect(defaultRequestHeaderMatcher("grpcgateway-cookie")).toEqual([
+      "Cookie",
+      true,
+    ]);
+    expect(defaultRequestHeaderMatcher("content-type")).toEqual([
+      "Grpc-Metadata-content-type",
+      true,
+    ]);
+    expect(defaultRequestHeaderMatcher("x-request-id")).toEqual([
+      "Grpc-Metadata-x-request-id",
+      true,
+    ]);
+  });
+
+  test("response: only Grpc-Metadata- headers", () => {
+    expect(defaultResponseHeaderMatcher("grpc-metadata-a")).toEqual([
+      "a",
+      true,
+    ]);
+    expect(defaultResponseHeaderMatcher("x-request-id")).toEqual(["", false]);
+  });
+
+  test("canonicalHeaderKey", () => {
+    expect(canonicalHeaderKey("x-request-id")).toBe("X-Request-Id");
+    expect(canonicalHeaderKey("USER-AGENT")).toBe("User-Agent");
+  });
+});
+
+describe("header-matcher: allow and deny", () => {
+  const metadata = new Metadata();
+  metadata.set("x-tenant", "t1");
+  metadata.set("x-debug-level", "2");
+  metadata.set("cookie", "a=1");
+
+  test("request deny wins over allow", () => {
+    expect(
+      toMetadataHeader(metadata, {
+        allow: ["x-*", "cookie"],
+        deny: [/^x-debug/],
+      })
+    ).toEqual({
+      TE: "trailers",
+      "Grpc-Metadata-x-tenant": "t1",
+      Cookie: "a=1",
+    });
+  });
+
+  test("custom request matcher", () => {
+    expect(
+      toMetadataHeader(metadata, {
+        matcher: (key) => [`X-Meta-${key}`, key !== "cookie"],
+      })
+    ).toEqual({
+      TE: "trailers",
+      "X-Meta-x-tenant": "t1",
+      "X-Meta-x-debug-level": "2",
+    });
+  });
+
+  test("response rules", () => {
+    const headers = {
+      "grpc-metadata-a": "1",
+      "grpc-metadata-content-type": "application/grpc",
+      "x-request-id": "r1",
+      "set-cookie": "a=1",
+    };
+    expect(getMetadataFromHeader(headers).getMap()).toEqual({
+      a: "1",
+      "content-type": "application/grpc",
+    });
+    const md = getMetadataFromHeader(headers, {
+      matcher: (header) =>
+        header.startsWith("grpc-metadata-")
+          ? defaultResponseHeaderMatcher(header)
+          : [header, true],
+      deny: ["grpc-metadata-content-type", "set-cookie"],
+    });
+    expect(md.getMap()).toEqual({ a: "1", "x-request-id": "r1" });
+  });
+});
@@ -0,0 +1,87 @@
+import { Metadata, status } from "@grpc/grpc-js";
+import { CallResult } from "../../src/grpc-utils";
+import { HedgeEvent, withHedging } from "../../src/hedging-policy";
+
+const callPath = "/example.greeter.v1.services.Greeter/SayHello";
+
+function result(code: status, response: unknown = null) {
+  return {
+    response,
+    metadata: new Metadata(),
+    status: { code, details: "", metadata: new Metadata() },
+  } as CallResult<any>;
+}
+
+// 按顺序返回预设的结果，每个结果在 delay 毫秒后返回，取消时返回 UNAVAILABLE
+function fakeHandler(plans: Array<{ delay: number; code: status }>) {
+  const signals: AbortSignal[] = [];
+  const handler = jest.fn(
+    (message: any, metadata: Metadata, signal?: AbortSignal) => {
+      const plan = plans[signals.length];
+      signals.push(signal!);
+      return new Promise<CallResult<any>>((resolve) => {
+        const timer = setTimeout(
+          () => resolve(result(plan.code, plan.delay)),
+          plan.delay
+        );
+        signal?.addEventListener("abort", () => {
+          clearTimeout(timer);
+          resolve(result(status.UNAVAILABLE));
+        });
+      });
+    }
+  );
+  return { handler, signals };
+}
+
+const policy = {
+  maxAttempts: 3,
+  hedgingDelay: "0.02s",
+  nonFatalStatusCodes: ["UNAVAILABLE"],
+};
+
+describe("hedging-policy: withHedging", () => {
+  test("the first successful hedge wins and the others are aborted", async () => {
+    const events: HedgeEvent[] = [];
+    const { handler, signals } = fakeHandler([
+      { delay: 200, code: status.OK },
+      { delay: 10, code: status.OK },
+      { delay: 200, code: status.OK },
+    ]);
+    const hedging = withHedging(handler, policy, true, callPath, (event) =>
+      events.push(event)
+    );
+    const last = await hedging({}, new Metadata());
+    expect(last.response).toBe(10);
+    expect(handler).toHaveBeenCalledTimes(2);
+    expect(signals[0].aborted).toBe(true);
+    expect(events).toEqual([{ callPath, attempts: 2, winner: 1 }]);
+  });
+
+  test("non fatal codes trigger the next hedge immediately", async () => {
+    const { handler } = fakeHandler([
+      { delay: 1, code: status.UNAVAILABLE },
+      { delay: 1, code: status.UNAVAILABLE },
+      { delay: 1, code: status.UNAVAILABLE },
+    ]);
+    const hedging = withHedging(handler, policy, true, callPath, () => 0);
+    const last = await hedging({}, new Metadata());
+    expect(last.status.code).toBe(status.UNAVAILABLE);
+    expect(handler).toHaveBeenCalledTimes(3);
+  });
+
+  test("fatal codes are returned without hedging", async () => {
+    const { handler } = fakeHandler([{ delay: 1, code: status.INTERNAL }]);
+    const hedging = withHedging(handler, policy, true, callPath, () => 0);
+    const last = await hedging({}, new Metadata());
+    expect(last.status.code).toBe(status.INTERNAL);
+    expect(handler).toHaveBeenCalledTimes(1);
+  });
+
+  test("non idempotent methods are not hedged", async () => {
+    const { handler } = fakeHandler([{ delay: 1, code: status.OK }]);
+    expect(withHedging(handler, policy, false, callPath, () => 0)).toBe(
+      handler
+    );
+  });
+});
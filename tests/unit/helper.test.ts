@@ -1,6 +1,6 @@
 import { resolve } from "node:path";
 import { fileURLToPath, URL } from "node:url";
-import { checkPathIsExist } from "../../src/helper";
+import { checkPathIsExist, isValidUrl } from "../../src/helper";
 
 const dirname = fileURLToPath(new URL(".", import.meta.url));
 
@@ -18,3 +18,13 @@ describe("helper: File system functions", () => {
     ).toBe(false);
   });
 });
+
+describe("helper: isValidUrl", () => {
+  test("isValidUrl:", () => {
+    expect(isValidUrl("http://127.0.0.1:4501")).toBe(true);
+    expect(isValidUrl("https://gateway.example.com/api")).toBe(true);
+    expect(isValidUrl("127.0.0.1:4501")).toBe(false);
+    expect(isValidUrl("http://")).toBe(false);
+    expect(isValidUrl("https://exa mple.com")).toBe(false);
+  });
+});
@@ -0,0 +1,79 @@
+import axios from "axios";
+import { ProxyRequest } from "../../src/http-transport";
+import {
+  FetchLike,
+  toHttpClientAdapter,
+  toHttpRequest,
+} from "../../src/http-client";
+
+const request: ProxyRequest = {
+  callPath: "/example.greeter.v1.services.Greeter/SayHello",
+  baseUrl: "http://127.0.0.1:4501/",
+  config: {
+    method: "post",
+    url: "/v1/sayHello/huk",
+    params: "a=1&b=2",
+    paramsSerializer: (params) => new URLSearchParams(params).toString(),
+    data: { name: "huk" },
+    headers: { "Grpc-Metadata-a": "1" },
+  },
+};
+
+describe("http-client: toHttpRequest", () => {
+  test("combine url, query and json body", () => {
+    expect(toHttpRequest(request)).toEqual({
+      method: "POST",
+      url: "http://127.0.0.1:4501/v1/sayHello/huk?a=1&b=2",
+      headers: {
+        "Grpc-Metadata-a": "1",
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify({ name: "huk" }),
+      signal: undefined,
+    });
+  });
+});
+
+describe("http-client: toHttpClientAdapter", () => {
+  test("fetch-compatible function", async () => {
+    const fetch = jest.fn<ReturnType<FetchLike>, Parameters<FetchLike>>(
+      async () => ({
+        status: 200,
+        headers: new Map([["Grpc-Metadata-b", "2"]]),
+        text: async () => JSON.stringify({ message: "hello huk" }),
+      })
+    );
+    const adapter = toHttpClientAdapter(fetch);
+    const result = await adapter.send(request);
+    expect(fetch.mock.calls[0][0]).toBe(
+      "http://127.0.0.1:4501/v1/sayHello/huk?a=1&b=2"
+    );
+    expect(result).toEqual({
+      status: 200,
+      headers: { "grpc-metadata-b": "2" },
+      data: { message: "hello huk" },
+    });
+  });
+
+  test("keep non-JSON body as text", async () => {
+    const adapter = toHttpClientAdapter(async () => ({
+      status: 502,
+      headers: new Map(),
+      text: async () => "Bad Gateway",
+    }));
+    expect((await adapter.send(request)).data).toBe("Bad Gateway");
+  });
+
+  test("axios instance is not treated as fetch", async () => {
+    const client = axios.create();
+    const spy = jest.spyOn(client, "request").mockResolvedValue({
+      status: 200,
+      headers: {},
+      data: {},
+      request: { res: { rawTrailers: ["Grpc-Trailer-c", "3"] } },
+    } as any);
+    const result = await toHttpClientAdapter(client).send(request);
+    expect(spy.mock.calls[0][0].baseURL).toBe(request.baseUrl);
+    expect(result.rawTrailers).toEqual(["Grpc-Trailer-c", "3"]);
+  });
+});
@@ -0,0 +1,299 @@
+import {
+  Client,
+  credentials,
+  InterceptingCall,
+  Interceptor,
+  InterceptorOptions,
+  Metadata,
+  NextCall,
+  status,
+} from "@grpc/grpc-js";
+import { InterceptingCallInterface } from "@grpc/grpc-js/build/src/client-interceptors";
+import {
+  InterceptingListener,
+  MessageContext,
+} from "@grpc/grpc-js/build/src/call-stream";
+import { CallResult } from "../../src/grpc-utils";
+import {
+  hasDownstreamInterceptor,
+  hybridCall,
+  proxyCall,
+} from "../../src/interceptor-call";
+import { errorResult, TransportError } from "../../src/proxy-error";
+import { Transport, TransportHealth } from "../../src/transport-health";
+
+const options = {
+  method_definition: { path: "/example.greeter.v1.services.Greeter/SayHello" },
+} as unknown as InterceptorOptions;
+
+function run(result: CallResult<any>): Promise<string[]> {
+  const events: string[] = [];
+  const call = proxyCall(options, async () => result);
+  return new Promise((resolve) => {
+    call.start(new Metadata(), {
+      onReceiveMetadata: () => events.push("metadata"),
+      onReceiveMessage: () => events.push("message"),
+      onReceiveStatus: () => {
+        events.push("status");
+        resolve(events);
+      },
+    });
+    call.sendMessage({ name: "huk" });
+    call.halfClose();
+  });
+}
+
+describe("interceptor-call: proxyCall", () => {
+  test("events are delivered as metadata, message, status", async () => {
+    const events = await run({
+      response: { message: "hello huk" },
+      metadata: new Metadata(),
+      status: { code: status.OK, details: "", metadata: new Metadata() },
+    });
+    expect(events).toEqual(["metadata", "message", "status"]);
+  });
+
+  test("failed calls do not receive a message", async () => {
+    const events = await run({
+      response: { message: "bad name" },
+      metadata: new Metadata(),
+      status: {
+        code: status.INVALID_ARGUMENT,
+        details: "bad name",
+        metadata: new Metadata(),
+      },
+    });
+    expect(events).toEqual(["metadata", "status"]);
+  });
+});
+
+describe("interceptor-call: cancellation", () => {
+  // 直到 signal 被中止才结束的 handler
+  function pending(signals: AbortSignal[]) {
+    return (message: unknown, metadata: Metadata, signal?: AbortSignal) =>
+      new Promise<CallResult<any>>((resolve, reject) => {
+        signals.push(signal!);
+        signal?.addEventListener("abort", () => reject(new Error("aborted")));
+      });
+  }
+
+  function start(call: ReturnType<typeof proxyCall>) {
+    return new Promise<status>((resolve) => {
+      call.start(new Metadata(), {
+        onReceiveStatus: (st) => resolve(st.code),
+      });
+      call.sendMessage({ name: "huk" });
+      call.halfClose();
+    });
+  }
+
+  test("cancelled calls abort the http request", async () => {
+    const signals: AbortSignal[] = [];
+    const call = proxyCall(options, pending(signals));
+    const code = start(call);
+    expect(signals[0].aborted).toBe(false);
+    call.cancelWithStatus(status.CANCELLED, "Cancelled on client");
+    expect(await code).toBe(status.CANCELLED);
+    expect(signals[0].aborted).toBe(true);
+  });
+
+  test("expired deadlines abort the http request", async () => {
+    const signals: AbortSignal[] = [];
+    const call = proxyCall(
+      { ...options, deadline: Date.now() + 20 },
+      pending(signals)
+    );
+    expect(await start(call)).toBe(status.DEADLINE_EXCEEDED);
+    expect(signals[0].aborted).toBe(true);
+  });
+});
+
+describe("interceptor-call: hybridCall", () => {
+  const target = "http://127.0.0.1:4501";
+  const ok: CallResult<any> = {
+    response: { message: "hello huk" },
+    metadata: new Metadata(),
+    status: { code: status.OK, details: "", metadata: new Metadata() },
+  };
+  const unavailable = errorResult(new TransportError("connect ECONNREFUSED"));
+
+  // 直连的 gRPC 调用，halfClose 后返回 code，不发送 metadata 表示连接没有建立
+  class FakeDirectCall implements InterceptingCallInterface {
+    private listener: Partial<InterceptingListener> = {};
+
+    constructor(private code: status, private starts: string[]) {}
+
+    public start(_: Metadata, listener?: Partial<InterceptingListener>) {
+      this.starts.push("direct");
+      this.listener = listener || {};
+    }
+
+    public sendMessageWithContext(context: MessageContext) {
+      context.callback?.();
+    }
+
+    public sendMessage() {}
+
+    public startRead() {}
+
+    public halfClose() {
+      setImmediate(() => {
+        if (this.code === status.OK) {
+          this.listener.onReceiveMetadata?.(new Metadata());
+          this.listener.onReceiveMessage?.({ message: "hello huk" });
+        }
+        this.listener.onReceiveStatus?.({
+          code: this.code,
+          details: "",
+          metadata: new Metadata(),
+        });
+      });
+    }
+
+    public cancelWithStatus() {}
+
+    public getPeer() {
+      return "";
+    }
+
+    public setCredentials() {}
+  }
+
+  // 返回调用结束时的状态码和 direct、proxy 被调用的顺序
+  function run(
+    prefer: Transport,
+    idempotent: boolean,
+    direct: status,
+    proxy: CallResult<any>
+  ) {
+    const sent: string[] = [];
+    const health = new TransportHealth(prefer);
+    const nextCall: NextCall = () => {
+      sent.push("nextCall");
+      return new FakeDirectCall(direct, sent);
+    };
+    const handler = async () => {
+      sent.push("proxy");
+      return proxy;
+    };
+    const call = hybridCall(options, nextCall, handler, {
+      health,
+      target,
+      idempotent,
+    });
+    return new Promise<{ code: status; sent: string[]; health: Transport }>(
+      (resolve) => {
+        call.start(new Metadata(), {
+          onReceiveStatus: (st) =>
+            resolve({ code: st.code, sent, health: health.current(target) }),
+        });
+        call.sendMessage({ name: "huk" });
+        call.halfClose();
+      }
+    );
+  }
+
+  test("non-idempotent calls are not resent after a direct failure", async () => {
+    const result = await run("direct", false, status.UNAVAILABLE, ok);
+    expect(result).toEqual({
+      code: status.UNAVAILABLE,
+      sent: ["nextCall", "direct"],
+      health: "proxy",
+    });
+  });
+
+  test("non-idempotent calls are not resent after a proxy failure", async () => {
+    const result = await run("proxy", false, status.OK, unavailable);
+    expect(result).toEqual({
+      code: status.UNAVAILABLE,
+      sent: ["proxy"],
+      health: "direct",
+    });
+  });
+
+  test("idempotent calls fail over to the proxy once", async () => {
+    const result = await run("direct", true, status.UNAVAILABLE, ok);
+    expect(result.code).toBe(status.OK);
+    expect(result.sent).toEqual(["nextCall", "direct", "proxy"]);
+  });
+
+  test("idempotent calls fail over to the direct call once", async () => {
+    const result = await run("proxy", true, status.OK, unavailable);
+    expect(result.code).toBe(status.OK);
+    expect(result.sent).toEqual(["proxy", "nextCall", "direct"]);
+  });
+
+  test("the direct call is not created when the gateway succeeds", async () => {
+    const result = await run("proxy", true, status.OK, ok);
+    expect(result).toEqual({
+      code: status.OK,
+      sent: ["proxy"],
+      health: "proxy",
+    });
+  });
+
+  test("unavailable responses from the gateway are not transport failures", async () => {
+    const gatewayUnavailable: CallResult<any> = {
+      ...unavailable,
+      http: { status: 503, headers: {}, gateway: target },
+    };
+    const result = await run("proxy", true, status.OK, gatewayUnavailable);
+    expect(result).toEqual({
+      code: status.UNAVAILABLE,
+      sent: ["proxy"],
+      health: "proxy",
+    });
+  });
+
+  test("idempotent calls are not resent when both transports fail", async () => {
+    const direct = await run("direct", true, status.UNAVAILABLE, unavailable);
+    expect(direct.code).toBe(status.UNAVAILABLE);
+    expect(direct.sent).toEqual(["nextCall", "direct", "proxy"]);
+    const proxy = await run("proxy", true, status.UNAVAILABLE, unavailable);
+    expect(proxy.code).toBe(status.UNAVAILABLE);
+    expect(proxy.sent).toEqual(["proxy", "nextCall", "direct"]);
+  });
+});
+
+describe("interceptor-call: hasDownstreamInterceptor", () => {
+  // 通过 grpc-js 的 client 发起调用，probe 记录收到的 nextCall 后面是否还有拦截器
+  function probe(interceptors: (probe: Interceptor) => Interceptor[]) {
+    const found: boolean[] = [];
+    const interceptor: Interceptor = (options, nextCall) => {
+      found.push(hasDownstreamInterceptor(nextCall));
+      return proxyCall(options, async () => ({
+        response: {},
+        metadata: new Metadata(),
+        status: { code: status.OK, details: "", metadata: new Metadata() },
+      }));
+    };
+    const client = new Client(
+      "127.0.0.1:1",
+      credentials.createInsecure(),
+      { interceptors: interceptors(interceptor) }
+    );
+    return new Promise<boolean>((resolve) => {
+      client.makeUnaryRequest(
+        options.method_definition.path,
+        (value: Buffer) => value,
+        (value: Buffer) => value,
+        Buffer.from(""),
+        () => {
+          client.close();
+          resolve(found[0]);
+        }
+      );
+    });
+  }
+
+  const passThrough: Interceptor = (options, nextCall) =>
+    new InterceptingCall(nextCall(options));
+
+  test("the last interceptor has no downstream interceptor", async () => {
+    expect(await probe((last) => [passThrough, last])).toBe(false);
+  });
+
+  test("interceptors after the proxy are detected", async () => {
+    expect(await probe((proxy) => [proxy, passThrough])).toBe(true);
+  });
+});
@@ -0,0 +1,127 @@
+import { EventEmitter } from "node:events";
+import { Metadata, status } from "@grpc/grpc-js";
+import { createTransport } from "../../src/call-pipeline";
+import { CallResult } from "../../src/grpc-utils";
+import { ProxyRequest } from "../../src/http-transport";
+import { isValidGatewayList, LoadBalancer } from "../../src/load-balancer";
+import { TransportError } from "../../src/proxy-error";
+
+const endpoints = [
+  "http://127.0.0.1:4501",
+  "http://127.0.0.1:4502",
+  "http://127.0.0.1:4503",
+];
+
+function pickMany(balancer: LoadBalancer, count: number, md?: Metadata) {
+  return Array.from({ length: count }, () => balancer.pick(md));
+}
+
+describe("load-balancer: LoadBalancer", () => {
+  test("round_robin", () => {
+    const balancer = new LoadBalancer(endpoints, {}, () => 0);
+    expect(pickMany(balancer, 4)).toEqual([...endpoints, endpoints[0]]);
+  });
+
+  test("weighted", () => {
+    const balancer = new LoadBalancer(
+      [
+        { url: endpoints[0], weight: 5 },
+        { url: endpoints[1], weight: 1 },
+        { url: endpoints[2], weight: 1 },
+      ],
+      { policy: "weighted" },
+      () => 0
+    );
+    // 平滑加权轮询不会连续选择权重大的地址
+    expect(pickMany(balancer, 7)).toEqual([
+      endpoints[0],
+      endpoints[0],
+      endpoints[1],
+      endpoints[0],
+      endpoints[2],
+      endpoints[0],
+      endpoints[0],
+    ]);
+  });
+
+  test("least_outstanding", async () => {
+    const balancer = new LoadBalancer(
+      endpoints,
+      { policy: "least_outstanding" },
+      () => 0
+    );
+    const middleware = balancer.middleware();
+    const request: ProxyRequest = { callPath: "/a/b", baseUrl: "", config: {} };
+    const sent: string[] = [];
+    let release = () => {};
+    const blocked = new Promise<void>((resolve) => (release = resolve));
+    const next = async (req: ProxyRequest) => {
+      sent.push(req.baseUrl);
+      // 第一个请求一直没有完成
+      if (sent.length === 1) await blocked;
+      return {} as CallResult<any>;
+    };
+    const first = middleware(request, next);
+    for (let i = 0; i < 4; i++) await middleware(request, next);
+    expect(sent.slice(1)).not.toContain(endpoints[0]);
+    release();
+    await first;
+  });
+
+  test("consistent_hash", () => {
+    const balancer = new LoadBalancer(
+      endpoints,
+      { policy: { consistent_hash: "x-user-id" } },
+      () => 0
+    );
+    const users = Array.from({ length: 20 }, (_, i) => {
+      const md = new Metadata();
+      md.set("x-user-id", `user-${i}`);
+      return md;
+    });
+    const first = users.map((md) => balancer.pick(md));
+    const second = users.map((md) => balancer.pick(md));
+    expect(second).toEqual(first);
+    expect(new Set(first).size).toBeGreaterThan(1);
+  });
+
+  test("no endpoint returns UNAVAILABLE", async () => {
+    const balancer = new LoadBalancer(endpoints, {}, () => 0);
+    // @ts-ignore
+    balancer.endpoints.forEach((item) => (item.healthy = false));
+    const next = jest.fn();
+    const result = await balancer.middleware()(
+      { callPath: "/a/b", baseUrl: "", config: {} },
+      next
+    );
+    expect(next).not.toHaveBeenCalled();
+    expect(result.status.code).toBe(status.UNAVAILABLE);
+    expect(result.error).toBeInstanceOf(TransportError);
+  });
+
+  test("closing the transport closes the balancer and the client", async () => {
+    const resolver = {
+      resolve: async () => endpoints,
+      close: jest.fn(),
+    };
+    const httpClient = {
+      send: async () => ({ status: 200, headers: {}, data: {} }),
+      close: jest.fn(),
+    };
+    const transport = createTransport(
+      { getaway: resolver, httpClient },
+      new EventEmitter()
+    );
+    await transport.close();
+    expect(resolver.close).toHaveBeenCalledTimes(1);
+    expect(httpClient.close).toHaveBeenCalledTimes(1);
+    await transport.close();
+    expect(resolver.close).toHaveBeenCalledTimes(1);
+  });
+
+  test("isValidGatewayList", () => {
+    expect(isValidGatewayList(endpoints)).toBe(true);
+    expect(isValidGatewayList([])).toBe(false);
+    expect(isValidGatewayList([{ url: "127.0.0.1:4501" }])).toBe(false);
+  });
+});
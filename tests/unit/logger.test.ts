@@ -0,0 +1,295 @@
+import { EventEmitter } from "node:events";
+import { AxiosHeaders } from "axios";
+import { Metadata, status } from "@grpc/grpc-js";
+import { HttpTransport } from "../../src/http-transport";
+import { Readiness } from "../../src/readiness";
+import { TransportError } from "../../src/proxy-error";
+import {
+  consoleLogger,
+  LogFields,
+  Logging,
+  LogLevel,
+  redactHeaders,
+} from "../../src/logger";
+
+const callPath = "/example.greeter.v1.services.Greeter/SayHello";
+
+function createLogger(levels: LogLevel[] = ["debug", "info", "warn", "error"]) {
+  const entries: [LogLevel, string, LogFields][] = [];
+  const logger: Record<string, unknown> = {};
+  for (const level of levels) {
+    logger[level] = (message: string, fields: LogFields) =>
+      entries.push([level, message, fields]);
+  }
+  return { logger, entries };
+}
+
+function result(code: status, httpStatus?: number) {
+  return {
+    response: code === status.OK ? { message: "hi" } : null,
+    metadata: new Metadata(),
+    status: { code, details: "", metadata: new Metadata() },
+    http:
+      httpStatus === undefined
+        ? undefined
+        : { status: httpStatus, headers: {}, gateway: "http://a" },
+  };
+}
+
+describe("logger: consoleLogger", () => {
+  test("levels below the configured level are disabled", () => {
+    const logger = consoleLogger("info");
+    expect(logger.debug).toBeUndefined();
+    const spy = jest.spyOn(console, "warn").mockImplementation(() => {});
+    logger.warn?.("boom", { callPath });
+    expect(spy).toHaveBeenCalledWith("grpc-proxy-interceptor: boom", {
+      callPath,
+    });
+    spy.mockRestore();
+  });
+
+  test("defaults to warn", () => {
+    expect(Object.keys(consoleLogger())).toEqual(["warn", "error"]);
+  });
+});
+
+describe("logger: redactHeaders", () => {
+  test("case insensitive", () => {
+    expect(
+      redactHeaders(
+        { Authorization: "Bearer x", cookie: "a=1", "X-Id": "1" },
+        ["authorization", "Cookie"]
+      )
+    ).toEqual({
+      Authorization: "[REDACTED]",
+      cookie: "[REDACTED]",
+      "X-Id": "1",
+    });
+  });
+
+  test("AxiosHeaders", () => {
+    const headers = new AxiosHeaders({ authorization: "Bearer x" });
+    expect(redactHeaders(headers, ["authorization"])).toEqual({
+      authorization: "[REDACTED]",
+    });
+  });
+});
+
+describe("logger: Logging", () => {
+  test("proxy errors are logged as warn with the cause", async () => {
+    const { logger, entries } = createLogger();
+    const cause = new Error("connect ECONNREFUSED");
+    const error = new TransportError("failed to send", cause);
+    const handler = new Logging(logger, "grpc-gateway").wrap(async () => {
+      throw error;
+    }, callPath);
+    await handler({}, new Metadata());
+    expect(entries).toEqual([
+      [
+        "warn",
+        `${callPath} failed: failed to send`,
+        {
+          callPath,
+          transport: "grpc-gateway",
+          url: "",
+          status: null,
+          grpcCode: "UNAVAILABLE",
+          duration: expect.any(Number),
+          error,
+        },
+      ],
+    ]);
+    expect((entries[0][2].error as TransportError).cause).toBe(cause);
+  });
+
+  test("error statuses are info and successful calls are debug", async () => {
+    const { logger, entries } = createLogger();
+    const logging = new Logging(logger, "grpc-web");
+    await logging.wrap(async () => result(status.NOT_FOUND, 404), callPath)(
+      {},
+      new Metadata()
+    );
+    await logging.wrap(async () => result(status.OK, 200), callPath)(
+      {},
+      new Metadata()
+    );
+    expect(entries.map(([level, , fields]) => [level, fields.status])).toEqual([
+      ["info", 404],
+      ["debug", 200],
+    ]);
+    expect(entries[0][2]).toMatchObject({
+      transport: "grpc-web",
+      url: "http://a",
+      grpcCode: "NOT_FOUND",
+    });
+  });
+
+  test("debug dumps redacted requests and responses", async () => {
+    const { logger, entries } = createLogger();
+    const logging = new Logging(logger, "grpc-gateway");
+    const transport = new HttpTransport({
+      send: async () => ({
+        status: 200,
+        headers: { "set-cookie": "sid=1", "grpc-metadata-x": "y" },
+        data: { message: "hi" },
+      }),
+    }).use(logging.middleware()!);
+    await transport.send({
+      callPath,
+      baseUrl: "http://a",
+      config: {
+        method: "post",
+        url: "/v1/hello",
+        params: "name=huk",
+        data: { name: "huk" },
+        headers: { Authorization: "Bearer x", "X-Id": "1" },
+      },
+    });
+    expect(entries).toEqual([
+      [
+        "debug",
+        `http request ${callPath}`,
+        {
+          callPath,
+          transport: "grpc-gateway",
+          url: "http://a/v1/hello?name=huk",
+          method: "POST",
+          headers: { Authorization: "[REDACTED]", "X-Id": "1" },
+          body: { name: "huk" },
+        },
+      ],
+      [
+        "debug",
+        `http response ${callPath}`,
+        {
+          callPath,
+          transport: "grpc-gateway",
+          url: "http://a/v1/hello?name=huk",
+          status: 200,
+          grpcCode: "OK",
+          duration: expect.any(Number),
+          headers: { "set-cookie": "[REDACTED]", "grpc-metadata-x": "y" },
+          body: { message: "hi" },
+          details: "",
+        },
+      ],
+    ]);
+  });
+
+  test("debug dumps the raw body of failed responses", async () => {
+    const { logger, entries } = createLogger();
+    const logging = new Logging(logger, "grpc-gateway");
+    const body = { code: 5, message: "not found", details: [] };
+    const transport = new HttpTransport({
+      send: async () => ({ status: 404, headers: {}, data: body }),
+    }).use(logging.middleware()!);
+    await transport.send({ callPath, baseUrl: "http://a", config: {} });
+    expect(entries[1][2]).toMatchObject({
+      status: 404,
+      grpcCode: "NOT_FOUND",
+      body,
+      details: "not found",
+    });
+  });
+
+  test("custom redacted headers", async () => {
+    const { logger, entries } = createLogger();
+    const logging = new Logging(logger, "grpc-gateway", ["x-api-key"]);
+    const transport = new HttpTransport({
+      send: async () => ({ status: 200, headers: {}, data: {} }),
+    }).use(logging.middleware()!);
+    await transport.send({
+      callPath,
+      baseUrl: "http://a",
+      config: { headers: { Authorization: "Bearer x", "X-Api-Key": "k" } },
+    });
+    expect(entries[0][2].headers).toEqual({
+      Authorization: "Bearer x",
+      "X-Api-Key": "[REDACTED]",
+    });
+  });
+
+  test("metadata headers are redacted", async () => {
+    const { logger, entries } = createLogger();
+    const matcher = (key: string): [string, boolean] => [`X-Md-${key}`, true];
+    const logging = new Logging(logger, "grpc-gateway", undefined, matcher);
+    const transport = new HttpTransport({
+      send: async () => ({ status: 200, headers: {}, data: {} }),
+    }).use(logging.middleware()!);
+    // oauth2 开启时调用凭证的 token 通过 Grpc-Metadata-authorization 发送
+    await transport.send({
+      callPath,
+      baseUrl: "http://a",
+      config: {
+        headers: {
+          Authorization: "Bearer oauth2",
+          "Grpc-Metadata-authorization": "Bearer grpc",
+          "grpc-metadata-cookie": "sid=1",
+          "X-Md-authorization": "Bearer custom",
+          "Grpc-Metadata-x-id": "1",
+        },
+      },
+    });
+    expect(entries[0][2].headers).toEqual({
+      Authorization: "[REDACTED]",
+      "Grpc-Metadata-authorization": "[REDACTED]",
+      "grpc-metadata-cookie": "[REDACTED]",
+      "X-Md-authorization": "[REDACTED]",
+      "Grpc-Metadata-x-id": "1",
+    });
+  });
+
+  test("signed requests are dumped with their absolute url", async () => {
+    const { logger, entries } = createLogger();
+    const logging = new Logging(logger, "grpc-gateway");
+    const transport = new HttpTransport({
+      send: async () => ({ status: 200, headers: {}, data: {} }),
+    }).use(logging.middleware()!);
+    // signingMiddleware 把地址改成了完整的地址，params 为 undefined
+    await transport.send({
+      callPath,
+      baseUrl: "http://a",
+      config: { url: "http://a/v1/hello?name=huk", params: undefined },
+    });
+    expect(entries[0][2].url).toBe("http://a/v1/hello?name=huk");
+  });
+
+  test("no dump without the debug level", () => {
+    const { logger } = createLogger(["warn"]);
+    expect(new Logging(logger, "grpc-gateway").middleware()).toBeNull();
+  });
+
+  test("logger errors do not break calls", async () => {
+    const handler = new Logging(
+      {
+        debug: () => {
+          throw new Error("logger down");
+        },
+      },
+      "grpc-gateway"
+    ).wrap(async () => result(status.OK, 200), callPath);
+    const { status: st } = await handler({}, new Metadata());
+    expect(st.code).toBe(status.OK);
+  });
+});
+
+describe("logger: Readiness", () => {
+  test("load failures are logged without error listeners", async () => {
+    const { logger, entries } = createLogger();
+    const readiness = new Readiness(
+      () => {
+        throw new Error("openapi directory /x does not exist");
+      },
+      new EventEmitter(),
+      new Logging(logger, "grpc-gateway")
+    );
+    await readiness.whenSettled();
+    expect(entries).toEqual([
+      [
+        "error",
+        "openapi files failed to load: openapi directory /x does not exist",
+        { transport: "grpc-gateway", error: expect.any(Error) },
+      ],
+    ]);
+  });
+});
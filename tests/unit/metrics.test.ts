@@ -0,0 +1,297 @@
+import { EventEmitter } from "node:events";
+import { Metadata, status } from "@grpc/grpc-js";
+import { HttpTransport } from "../../src/http-transport";
+import { CallPipeline } from "../../src/call-pipeline";
+import {
+  CallMetric,
+  HedgeMetric,
+  LoadMetric,
+  Metrics,
+  openTelemetryMetrics,
+  prometheusMetrics,
+  PromClientLike,
+  RequestMetric,
+  withLoadMetrics,
+} from "../../src/metrics";
+
+const callPath = "/example.greeter.v1.services.Greeter/SayHello";
+
+function createSink() {
+  const calls: CallMetric[] = [];
+  const requests: RequestMetric[] = [];
+  const loads: LoadMetric[] = [];
+  const hedges: HedgeMetric[] = [];
+  return {
+    calls,
+    requests,
+    loads,
+    hedges,
+    sink: {
+      recordCall: (metric: CallMetric) => calls.push(metric),
+      recordRequest: (metric: RequestMetric) => requests.push(metric),
+      recordLoad: (metric: LoadMetric) => loads.push(metric),
+      recordHedge: (metric: HedgeMetric) => hedges.push(metric),
+    },
+  };
+}
+
+describe("metrics: Metrics", () => {
+  test("record each request and the whole call", async () => {
+    const { sink, calls, requests } = createSink();
+    const metrics = new Metrics(sink, "grpc-gateway");
+    let attempt = 0;
+    const transport = new HttpTransport({
+      send: async () =>
+        attempt++ === 0
+          ? { status: 503, headers: {}, data: { message: "down" } }
+          : { status: 200, headers: {}, data: {} },
+    }).use(metrics.middleware()!);
+    const handler = metrics.wrap(async () => {
+      await transport.send({ callPath, baseUrl: "http://a", config: {} });
+      return transport.send({ callPath, baseUrl: "http://b", config: {} });
+    }, callPath);
+    await handler({}, new Metadata());
+    expect(requests.map((r) => [r.gateway, r.httpStatus, r.grpcCode])).toEqual([
+      ["http://a", 503, status.UNAVAILABLE],
+      ["http://b", 200, status.OK],
+    ]);
+    expect(calls).toEqual([
+      {
+        method: callPath,
+        transport: "grpc-gateway",
+        gateway: "http://b",
+        httpStatus: 200,
+        grpcCode: status.OK,
+        duration: expect.any(Number),
+      },
+    ]);
+  });
+
+  test("no http response", async () => {
+    const { sink, calls } = createSink();
+    const handler = new Metrics(sink, "grpc-web").wrap(async () => {
+      throw new Error("boom");
+    }, callPath);
+    await handler({}, new Metadata());
+    expect(calls[0]).toMatchObject({
+      gateway: "",
+      httpStatus: null,
+      grpcCode: status.INTERNAL,
+    });
+  });
+
+  test("sink errors do not break calls", async () => {
+    const handler = new Metrics(
+      {
+        recordCall: () => {
+          throw new Error("sink");
+        },
+      },
+      "grpc-web"
+    ).wrap(
+      async () => ({
+        response: {},
+        metadata: new Metadata(),
+        status: { code: status.OK, details: "", metadata: new Metadata() },
+      }),
+      callPath
+    );
+    expect((await handler({}, new Metadata())).status.code).toBe(status.OK);
+  });
+
+  test("record hedged calls from the pipeline", async () => {
+    const { sink, hedges } = createSink();
+    const serviceConfig = {
+      methodConfig: [
+        {
+          name: [{ service: "example.greeter.v1.services.Greeter" }],
+          hedgingPolicy: {
+            maxAttempts: 3,
+            hedgingDelay: "1s",
+            nonFatalStatusCodes: ["UNAVAILABLE"],
+          },
+        },
+      ],
+    };
+    const emitter = new EventEmitter();
+    const events: unknown[] = [];
+    emitter.on("hedge", (event) => events.push(event));
+    let attempt = 0;
+    const pipeline = new CallPipeline(
+      async () => ({
+        response: {},
+        metadata: new Metadata(),
+        status: {
+          code: attempt++ === 0 ? status.UNAVAILABLE : status.OK,
+          details: "",
+          metadata: new Metadata(),
+        },
+      }),
+      {
+        idempotent: [callPath],
+        channelOptions: {
+          "grpc.service_config": JSON.stringify(serviceConfig),
+        },
+      },
+      emitter,
+      { tracing: null, metrics: new Metrics(sink, "grpc-web"), logging: null }
+    );
+    const result = await pipeline.handler(callPath)({}, new Metadata());
+    expect(result.status.code).toBe(status.OK);
+    expect(events).toEqual([{ callPath, attempts: 2, winner: 1 }]);
+    expect(hedges).toEqual([
+      { method: callPath, transport: "grpc-web", attempts: 2, winner: 1 },
+    ]);
+  });
+});
+
+describe("metrics: withLoadMetrics", () => {
+  test("sync load", () => {
+    const { sink, loads } = createSink();
+    withLoadMetrics(() => undefined, () => 10, sink)();
+    expect(loads).toEqual([
+      { duration: expect.any(Number), routes: 10, error: null },
+    ]);
+  });
+
+  test("failed async load", async () => {
+    const { sink, loads } = createSink();
+    const error = new Error("not exist");
+    const load = withLoadMetrics(() => Promise.reject(error), () => 10, sink);
+    await expect(load()).rejects.toBe(error);
+    expect(loads).toEqual([{ duration: expect.any(Number), routes: 0, error }]);
+  });
+});
+
+describe("metrics: prometheusMetrics", () => {
+  test("labels", () => {
+    const recorded: Record<string, any[]> = {};
+    const metric = (config: any) => {
+      recorded[config.name] = [config];
+      const record = (...args: any[]) => recorded[config.name].push(args);
+      return { inc: record, observe: record, set: record };
+    };
+    const client = {
+      Counter: function (config: any) {
+        return metric(config);
+      },
+      Histogram: function (config: any) {
+        return metric(config);
+      },
+      Gauge: function (config: any) {
+        return metric(config);
+      },
+    } as unknown as PromClientLike;
+    const sink = prometheusMetrics(client);
+    sink.recordCall({
+      method: callPath,
+      transport: "grpc-gateway",
+      gateway: "http://a",
+      httpStatus: null,
+      grpcCode: status.UNAVAILABLE,
+      duration: 0.5,
+    });
+    sink.recordLoad?.({ duration: 0.1, routes: 10, error: null });
+    sink.recordHedge?.({
+      method: callPath,
+      transport: "grpc-gateway",
+      attempts: 3,
+      winner: 2,
+    });
+    const labels = {
+      method: callPath,
+      transport: "grpc-gateway",
+      gateway: "http://a",
+      http_status: "none",
+      grpc_code: "UNAVAILABLE",
+    };
+    expect(recorded.grpc_proxy_calls_total).toEqual([
+      {
+        name: "grpc_proxy_calls_total",
+        help: "Proxied gRPC calls",
+        labelNames: Object.keys(labels),
+      },
+      [labels],
+    ]);
+    expect(recorded.grpc_proxy_call_duration_seconds[1]).toEqual([
+      labels,
+      0.5,
+    ]);
+    expect(recorded.grpc_proxy_openapi_routes[1]).toEqual([10]);
+    expect(recorded.grpc_proxy_hedged_calls_total[1]).toEqual([
+      { method: callPath, transport: "grpc-gateway", hedge_won: "true" },
+    ]);
+    expect(recorded.grpc_proxy_hedge_attempts_total[1]).toEqual([
+      { method: callPath, transport: "grpc-gateway" },
+      3,
+    ]);
+  });
+});
+
+describe("metrics: openTelemetryMetrics", () => {
+  test("attributes and observable gauges", () => {
+    const recorded: Record<string, any[]> = {};
+    const callbacks: Record<string, (result: any) => void> = {};
+    const counters: string[] = [];
+    const record =
+      (name: string) =>
+      (...args: any[]) =>
+        (recorded[name] = args);
+    const meter = {
+      createCounter: (name: string) => {
+        counters.push(name);
+        return { add: record(name) };
+      },
+      createHistogram: (name: string) => ({ record: record(name) }),
+      createObservableGauge: (name: string) => ({
+        addCallback: (callback: any) => (callbacks[name] = callback),
+      }),
+    } as any;
+    const sink = openTelemetryMetrics(meter);
+    sink.recordCall({
+      method: callPath,
+      transport: "grpc-web",
+      gateway: "http://a",
+      httpStatus: 200,
+      grpcCode: status.OK,
+      duration: 0.5,
+    });
+    expect(recorded["grpc_proxy.calls"]).toEqual([
+      1,
+      {
+        "rpc.system": "grpc",
+        "rpc.service": "example.greeter.v1.services.Greeter",
+        "rpc.method": "SayHello",
+        "rpc.grpc.status_code": status.OK,
+        "grpc_proxy.transport": "grpc-web",
+        "grpc_proxy.gateway.url": "http://a",
+        "http.response.status_code": 200,
+      },
+    ]);
+    const observe = jest.fn();
+    callbacks["grpc_proxy.openapi.routes"]({ observe });
+    expect(observe).not.toHaveBeenCalled();
+    sink.recordLoad?.({ duration: 0.1, routes: 10, error: null });
+    callbacks["grpc_proxy.openapi.routes"]({ observe });
+    expect(observe).toHaveBeenCalledWith(10);
+    sink.recordHedge?.({
+      method: callPath,
+      transport: "grpc-web",
+      attempts: 2,
+      winner: 0,
+    });
+    const attributes = {
+      "rpc.system": "grpc",
+      "rpc.service": "example.greeter.v1.services.Greeter",
+      "rpc.method": "SayHello",
+      "grpc_proxy.transport": "grpc-web",
+    };
+    expect(recorded["grpc_proxy.hedged_calls"]).toEqual([
+      1,
+      { ...attributes, "grpc_proxy.hedge.won": false },
+    ]);
+    expect(recorded["grpc_proxy.hedge.attempts"]).toEqual([2, attributes]);
+    // 和 prometheus 的 hedge_attempts_total 一样是计数器
+    expect(counters).toContain("grpc_proxy.hedge.attempts");
+  });
+});
@@ -0,0 +1,136 @@
+import { Metadata, status } from "@grpc/grpc-js";
+import { CallResult } from "../../src/grpc-utils";
+import { ProxyRequest } from "../../src/http-transport";
+import { ClientCredentialsProvider } from "../../src/oauth2";
+import { TransportError } from "../../src/proxy-error";
+import {
+  startTokenServer,
+  TokenServer,
+} from "../resources/oauth2/token-server";
+
+const request: ProxyRequest = {
+  callPath: "/a/b",
+  baseUrl: "http://127.0.0.1:4501",
+  config: { headers: { TE: "trailers", Authorization: "Bearer grpc" } },
+};
+
+function httpResult(httpStatus: number): CallResult<any> {
+  return {
+    response: null,
+    metadata: new Metadata(),
+    status: { code: status.OK, details: "", metadata: new Metadata() },
+    http: { status: httpStatus, headers: {}, gateway: "" },
+  };
+}
+
+describe("oauth2: ClientCredentialsProvider", () => {
+  let server = null as unknown as TokenServer;
+
+  afterEach(async () => {
+    await server.close();
+  });
+
+  test("cache token and fetch it only once", async () => {
+    server = await startTokenServer({
+      clientId: "client",
+      clientSecret: "s3cret",
+      tokens: ["t1", "t2"],
+      expiresIn: 3600,
+      delay: 50,
+    });
+    const provider = new ClientCredentialsProvider({
+      tokenUrl: server.url,
+      clientId: "client",
+      clientSecret: "s3cret",
+      scope: ["read", "write"],
+    });
+    const tokens = await Promise.all([
+      provider.getToken(),
+      provider.getToken(),
+      provider.getToken(),
+    ]);
+    expect(tokens).toEqual(["t1", "t1", "t1"]);
+    expect(await provider.getToken()).toBe("t1");
+    expect(server.requests).toHaveLength(1);
+    expect(server.requests[0].get("scope")).toBe("read write");
+  });
+
+  test("refresh before expiry", async () => {
+    server = await startTokenServer({
+      clientId: "client",
+      clientSecret: "s3cret",
+      tokens: ["t1", "t2"],
+      expiresIn: 60,
+    });
+    const provider = new ClientCredentialsProvider({
+      tokenUrl: server.url,
+      clientId: "client",
+      clientSecret: "s3cret",
+      authMethod: "client_secret_post",
+    });
+    const now = Date.now();
+    const spy = jest.spyOn(Date, "now").mockReturnValue(now);
+    expect(await provider.getToken()).toBe("t1");
+    // 默认提前 30 秒刷新
+    spy.mockReturnValue(now + 29000);
+    expect(await provider.getToken()).toBe("t1");
+    spy.mockReturnValue(now + 30000);
+    expect(await provider.getToken()).toBe("t2");
+    spy.mockRestore();
+    expect(server.requests[0].get("client_id")).toBe("client");
+  });
+
+  test("retry once on 401", async () => {
+    server = await startTokenServer({
+      clientId: "client",
+      clientSecret: "s3cret",
+      tokens: ["stale", "fresh"],
+    });
+    const provider = new ClientCredentialsProvider({
+      tokenUrl: server.url,
+      clientId: "client",
+      clientSecret: "s3cret",
+    });
+    const sent: ProxyRequest[] = [];
+    const next = jest.fn(async (req: ProxyRequest) => {
+      sent.push(req);
+      const auth = (req.config.headers as Record<string, string>).Authorization;
+      return httpResult(auth === "Bearer fresh" ? 200 : 401);
+    });
+    const result = await provider.middleware()(request, next);
+    expect(result.http?.status).toBe(200);
+    expect(sent.map((req) => req.config.headers?.Authorization)).toEqual([
+      "Bearer stale",
+      "Bearer fresh",
+    ]);
+    // 调用凭证生成的 authorization 通过 metadata 头发送
+    expect(sent[1].config.headers?.["Grpc-Metadata-authorization"]).toBe(
+      "Bearer grpc"
+    );
+    // 只重发一次
+    next.mockImplementation(async () => httpResult(401));
+    expect((await provider.middleware()(request, next)).http?.status).toBe(
+      401
+    );
+    expect(next).toHaveBeenCalledTimes(4);
+  });
+
+  test("token endpoint error returns UNAVAILABLE", async () => {
+    server = await startTokenServer({
+      clientId: "client",
+      clientSecret: "s3cret",
+      tokens: ["t1"],
+    });
+    const provider = new ClientCredentialsProvider({
+      tokenUrl: server.url,
+      clientId: "client",
+      clientSecret: "wrong",
+    });
+    const next = jest.fn();
+    const result = await provider.middleware()(request, next);
+    expect(next).not.toHaveBeenCalled();
+    expect(result.status.code).toBe(status.UNAVAILABLE);
+    expect(result.status.details).toContain("invalid_client");
+    expect(result.error).toBeInstanceOf(TransportError);
+  });
+});
@@ -0,0 +1,139 @@
+import { OpenAPIV2 } from "openapi-types";
+import {
+  formatViolations,
+  resolveRef,
+  sampleFromSchema,
+  validateSchema,
+} from "../../src/openapi-schema";
+
+const document = {
+  swagger: "2.0",
+  info: { title: "test", version: "1" },
+  paths: {},
+  definitions: {
+    "example.Node": {
+      type: "object",
+      properties: {
+        id: { type: "string", format: "int64" },
+        name: { type: "string" },
+        kind: { type: "string", enum: ["LEAF", "BRANCH"] },
+        weight: { type: "number", format: "double" },
+        visible: { type: "boolean" },
+        createdAt: { type: "string", format: "date-time" },
+        children: {
+          type: "array",
+          items: { $ref: "#/definitions/example.Node" },
+        },
+        labels: { type: "object", additionalProperties: { type: "string" } },
+        owner: { type: "string", example: "huk" },
+      },
+    },
+  },
+} as unknown as OpenAPIV2.Document;
+
+describe("openapi-schema: resolveRef", () => {
+  test("definitions", () => {
+    expect(resolveRef(document, "#/definitions/example.Node").type).toBe(
+      "object"
+    );
+  });
+
+  test("unresolved refs", () => {
+    expect(() => resolveRef(document, "#/definitions/missing")).toThrow(
+      "cannot resolve $ref #/definitions/missing"
+    );
+    expect(() => resolveRef(document, "other.json#/a")).toThrow(
+      "unsupported $ref other.json#/a"
+    );
+  });
+});
+
+describe("openapi-schema: sampleFromSchema", () => {
+  test("every type", () => {
+    expect(
+      sampleFromSchema({ $ref: "#/definitions/example.Node" }, document)
+    ).toEqual({
+      id: "0",
+      name: "string",
+      kind: "LEAF",
+      weight: 0,
+      visible: false,
+      createdAt: "1970-01-01T00:00:00Z",
+      // 循环引用在第二次出现时为空对象
+      children: [{}],
+      labels: {},
+      owner: "huk",
+    });
+  });
+});
+
+describe("openapi-schema: validateSchema", () => {
+  const node = { $ref: "#/definitions/example.Node" };
+
+  test("valid messages", () => {
+    const message = {
+      id: "12",
+      name: "root",
+      kind: "BRANCH",
+      weight: 1.5,
+      visible: true,
+      children: [{ id: 13, kind: 1 }, null],
+      labels: { env: "dev" },
+    };
+    expect(validateSchema(message, node, document)).toEqual([]);
+    expect(validateSchema(null, node, document)).toEqual([]);
+  });
+
+  test("type mismatch", () => {
+    const message = {
+      id: "1.5",
+      name: 1,
+      kind: "ROOT",
+      children: [{ visible: "yes" }],
+      labels: { env: 1 },
+    };
+    expect(validateSchema(message, node, document)).toEqual([
+      { path: "id", message: "must be an int64 string" },
+      { path: "name", message: "must be a string" },
+      { path: "kind", message: "must be one of LEAF, BRANCH" },
+      { path: "children[0].visible", message: "must be a boolean" },
+      { path: "labels.env", message: "must be a string" },
+    ]);
+    expect(validateSchema([], node, document)).toEqual([
+      { path: "", message: "must be an object" },
+    ]);
+  });
+
+  test("unknown fields", () => {
+    const message = { name: "root", extra: 1 };
+    expect(validateSchema(message, node, document)).toEqual([]);
+    expect(
+      validateSchema(message, node, document, { allowUnknown: false })
+    ).toEqual([{ path: "extra", message: "is not defined" }]);
+  });
+
+  test("required fields", () => {
+    const schema: OpenAPIV2.SchemaObject = {
+      type: "object",
+      required: ["name"],
+    };
+    expect(validateSchema({}, schema, document)).toEqual([
+      { path: "name", message: "is required" },
+    ]);
+  });
+});
+
+describe("openapi-schema: formatViolations", () => {
+  test("limit", () => {
+    const violations = ["a", "b", "c"].map((path) => ({
+      path,
+      message: "is required",
+    }));
+    expect(formatViolations(violations, 2)).toBe(
+      "a is required; b is required; and 1 more"
+    );
+    expect(formatViolations([{ path: "", message: "must be an object" }])).toBe(
+      "must be an object"
+    );
+  });
+});
@@ -0,0 +1,44 @@
+import { status } from "@grpc/grpc-js";
+import {
+  getErrorDetails,
+  httpStatus2GrpcStatus,
+  MAX_ERROR_BODY_LENGTH,
+} from "../../src/openapi-utils";
+
+describe("openapi-utils: httpStatus2GrpcStatus", () => {
+  test("proxy errors", () => {
+    expect(httpStatus2GrpcStatus(502)).toBe(status.UNAVAILABLE);
+    expect(httpStatus2GrpcStatus(503)).toBe(status.UNAVAILABLE);
+    expect(httpStatus2GrpcStatus(504)).toBe(status.DEADLINE_EXCEEDED);
+  });
+});
+
+describe("openapi-utils: getErrorDetails", () => {
+  test("grpc-gateway JSON error", () => {
+    expect(getErrorDetails(400, { code: 3, message: "bad name" })).toBe(
+      "bad name"
+    );
+  });
+
+  test("empty body", () => {
+    expect(getErrorDetails(502, "")).toBe(
+      "received http 502 with an empty body"
+    );
+    expect(getErrorDetails(502, undefined)).toBe(
+      "received http 502 with an empty body"
+    );
+  });
+
+  test("html body is truncated", () => {
+    const details = getErrorDetails(502, `<html>${"x".repeat(1000)}</html>`);
+    expect(details).toBe(
+      `received http 502: <html>${"x".repeat(MAX_ERROR_BODY_LENGTH - 6)}...`
+    );
+  });
+
+  test("JSON without message", () => {
+    expect(getErrorDetails(500, { error: "oops" })).toBe(
+      'received http 500: {"error":"oops"}'
+    );
+  });
+});
@@ -0,0 +1,165 @@
+import { resolve } from "node:path";
+import { fileURLToPath, URL } from "node:url";
+import { InterceptorOptions, Metadata, status } from "@grpc/grpc-js";
+import { parseCallPath } from "../../src/grpc-utils";
+import { HttpTransport } from "../../src/http-transport";
+import { proxyCall } from "../../src/interceptor-call";
+import { OpenapiV2Proxy } from "../../src/openapi-proxy-impl";
+import {
+  DecodeError,
+  errorResult,
+  ProxyError,
+  RouteNotFound,
+  settleCall,
+  toProxyError,
+  TranscodeError,
+  TransportError,
+} from "../../src/proxy-error";
+
+const dirname = fileURLToPath(new URL(".", import.meta.url));
+const openapiDir = resolve(dirname, "../resources/grpc-server/openapi");
+const sayHello = "/example.greeter.v1.services.Greeter/SayHello";
+
+describe("proxy-error: error model", () => {
+  test("each error maps to a grpc status", () => {
+    expect(new RouteNotFound("x").code).toBe(status.UNIMPLEMENTED);
+    expect(new TranscodeError("x").code).toBe(status.INVALID_ARGUMENT);
+    expect(new TransportError("x").code).toBe(status.UNAVAILABLE);
+    expect(new DecodeError("x").code).toBe(status.INTERNAL);
+    expect(new TransportError("x")).toBeInstanceOf(ProxyError);
+    expect(new TransportError("x").name).toBe("TransportError");
+  });
+
+  test("unknown errors become INTERNAL and keep the cause", () => {
+    const cause = new Error("boom");
+    const error = toProxyError(cause);
+    expect(error.code).toBe(status.INTERNAL);
+    expect(error.message).toBe("proxy call failed: boom");
+    expect(error.cause).toBe(cause);
+  });
+
+  test("errorResult carries the error", () => {
+    const error = new TransportError("down", new Error("ECONNREFUSED"));
+    const result = errorResult(error);
+    expect(result.response).toBeNull();
+    expect(result.error).toBe(error);
+    expect(result.status).toMatchObject({
+      code: status.UNAVAILABLE,
+      details: "down",
+    });
+  });
+
+  test("settleCall converts throws and rejections", async () => {
+    const thrown = await settleCall(() => {
+      throw new RouteNotFound("no route");
+    });
+    expect(thrown.status.code).toBe(status.UNIMPLEMENTED);
+    const rejected = await settleCall(() => Promise.reject(new Error("x")));
+    expect(rejected.status.code).toBe(status.INTERNAL);
+  });
+
+  test("parseCallPath throws RouteNotFound", () => {
+    expect(() => parseCallPath("SayHello")).toThrow(RouteNotFound);
+  });
+});
+
+describe("proxy-error: OpenapiV2Proxy.call", () => {
+  const transport = new HttpTransport({
+    send: async () => ({ status: 200, headers: {}, data: {} }),
+  });
+
+  function createProxy() {
+    const proxy = new OpenapiV2Proxy(
+      openapiDir,
+      "http://127.0.0.1:4501",
+      transport
+    );
+    proxy.load(true);
+    return proxy;
+  }
+
+  test("not loaded returns FAILED_PRECONDITION", async () => {
+    const proxy = new OpenapiV2Proxy(openapiDir, "http://127.0.0.1:4501");
+    const result = await proxy.call(sayHello, { name: "huk" }, new Metadata());
+    expect(result.status.code).toBe(status.FAILED_PRECONDITION);
+  });
+
+  test("unknown method returns UNIMPLEMENTED", async () => {
+    const result = await createProxy().call(
+      "/example.greeter.v1.services.Greeter/NotExist",
+      {},
+      new Metadata()
+    );
+    expect(result.error).toBeInstanceOf(RouteNotFound);
+    expect(result.status).toMatchObject({
+      code: status.UNIMPLEMENTED,
+      details:
+        "no openapi operation found for " +
+        "/example.greeter.v1.services.Greeter/NotExist",
+    });
+  });
+
+  test("invalid call path returns UNIMPLEMENTED", async () => {
+    const result = await createProxy().call("bad", {}, new Metadata());
+    expect(result.status.code).toBe(status.UNIMPLEMENTED);
+  });
+
+  test("missing path parameter returns INVALID_ARGUMENT", async () => {
+    const result = await createProxy().call(sayHello, {}, new Metadata());
+    expect(result.error).toBeInstanceOf(TranscodeError);
+    expect(result.status.code).toBe(status.INVALID_ARGUMENT);
+    expect(result.status.details).toContain("missing path parameter name");
+  });
+
+  test("non-JSON success body returns INTERNAL", async () => {
+    const proxy = new OpenapiV2Proxy(
+      openapiDir,
+      "http://127.0.0.1:4501",
+      new HttpTransport({
+        send: async () => ({ status: 200, headers: {}, data: "<html>" }),
+      })
+    );
+    proxy.load(true);
+    const result = await proxy.call(sayHello, { name: "huk" }, new Metadata());
+    expect(result.error).toBeInstanceOf(DecodeError);
+    expect(result.status.code).toBe(status.INTERNAL);
+  });
+
+  test("client error returns UNAVAILABLE", async () => {
+    const cause = new Error("connect ECONNREFUSED");
+    const proxy = new OpenapiV2Proxy(
+      openapiDir,
+      "http://127.0.0.1:4501",
+      new HttpTransport({
+        send: async () => {
+          throw cause;
+        },
+      })
+    );
+    proxy.load(true);
+    const result = await proxy.call(sayHello, { name: "huk" }, new Metadata());
+    expect(result.error).toBeInstanceOf(TransportError);
+    expect(result.error?.cause).toBe(cause);
+    expect(result.status.code).toBe(status.UNAVAILABLE);
+  });
+});
+
+describe("proxy-error: proxyCall", () => {
+  test("a rejected handler still finishes the call", async () => {
+    const call = proxyCall(
+      {
+        method_definition: { path: sayHello },
+      } as unknown as InterceptorOptions,
+      () => Promise.reject(new Error("boom"))
+    );
+    const received = new Promise<any>((r) => {
+      call.start(new Metadata(), { onReceiveStatus: r });
+    });
+    call.sendMessage({ name: "huk" });
+    call.halfClose();
+    expect(await received).toMatchObject({
+      code: status.INTERNAL,
+      details: "proxy call failed: boom",
+    });
+  });
+});
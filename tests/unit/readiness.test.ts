@@ -0,0 +1,84 @@
+import { EventEmitter } from "node:events";
+import { resolve } from "node:path";
+import { fileURLToPath, URL } from "node:url";
+import { Metadata, status } from "@grpc/grpc-js";
+import { Readiness } from "../../src/readiness";
+import { OpenapiV2Parser } from "../../src/openapi-v2-parser";
+
+const dirname = fileURLToPath(new URL(".", import.meta.url));
+
+function okHandler() {
+  return jest.fn(async () => ({
+    response: { ok: true },
+    metadata: new Metadata(),
+    status: { code: status.OK, details: "", metadata: new Metadata() },
+  }));
+}
+
+describe("readiness: Readiness", () => {
+  test("calls wait until loaded", async () => {
+    const emitter = new EventEmitter();
+    const onReady = jest.fn();
+    emitter.on("ready", onReady);
+    let finish = () => {};
+    const readiness = new Readiness(
+      () => new Promise<void>((r) => (finish = r)),
+      emitter
+    );
+    const handler = okHandler();
+    const pending = readiness.wrap(handler)({}, new Metadata());
+    await new Promise((r) => setImmediate(r));
+    expect(handler).not.toHaveBeenCalled();
+    expect(readiness.getState()).toBe("loading");
+    finish();
+    expect((await pending).status.code).toBe(status.OK);
+    expect(onReady).toHaveBeenCalledTimes(1);
+    await expect(readiness.ready()).resolves.toBeUndefined();
+  });
+
+  test("timeout returns UNAVAILABLE", async () => {
+    const readiness = new Readiness(
+      () => new Promise<void>(() => undefined),
+      new EventEmitter()
+    );
+    const handler = okHandler();
+    const result = await readiness.wrap(handler, 20)({}, new Metadata());
+    expect(handler).not.toHaveBeenCalled();
+    expect(result.status.code).toBe(status.UNAVAILABLE);
+    expect(result.status.details).toContain("still loading after 20ms");
+  });
+
+  test("failed load returns FAILED_PRECONDITION", async () => {
+    const emitter = new EventEmitter();
+    const onError = jest.fn();
+    emitter.on("error", onError);
+    const readiness = new Readiness(() => {
+      throw new Error("openapi directory /x does not exist");
+    }, emitter);
+    const handler = okHandler();
+    const result = await readiness.wrap(handler)({}, new Metadata());
+    expect(handler).not.toHaveBeenCalled();
+    expect(result.status.code).toBe(status.FAILED_PRECONDITION);
+    expect(result.status.details).toContain("/x does not exist");
+    expect(onError).toHaveBeenCalledTimes(1);
+    await expect(readiness.ready()).rejects.toThrow("does not exist");
+  });
+});
+
+describe("readiness: OpenapiV2Parser errors", () => {
+  test("missing directory", async () => {
+    const parser = new OpenapiV2Parser(resolve(dirname, "not-exist"));
+    await expect(parser.init(false)).rejects.toThrow(/does not exist/);
+    expect(() => parser.init(true)).toThrow(/does not exist/);
+  });
+
+  test("not a directory", async () => {
+    const parser = new OpenapiV2Parser(resolve(dirname, "readiness.test.ts"));
+    await expect(parser.init(false)).rejects.toThrow(/is not a directory/);
+  });
+
+  test("no openapi files", async () => {
+    const parser = new OpenapiV2Parser(dirname);
+    await expect(parser.init(false)).rejects.toThrow(/no \*\.swagger\.json/);
+  });
+});
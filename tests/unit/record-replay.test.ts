@@ -0,0 +1,193 @@
+import { mkdtempSync, readdirSync, readFileSync, rmSync } from "fs";
+import { tmpdir } from "node:os";
+import { join } from "node:path";
+import { status } from "@grpc/grpc-js";
+import { HttpTransport, ProxyRequest } from "../../src/http-transport";
+import {
+  Fixture,
+  fixtureKey,
+  fixturePath,
+  recordClient,
+  replayClient,
+} from "../../src/record-replay";
+
+const callPath = "/example.greeter.v1.services.Greeter/SayHello";
+
+function request(data: unknown, headers = {}): ProxyRequest {
+  return {
+    callPath,
+    baseUrl: "http://127.0.0.1:4501",
+    config: { method: "post", url: "/v1/sayHello", data, headers },
+  };
+}
+
+let dir = "";
+
+beforeEach(() => {
+  dir = mkdtempSync(join(tmpdir(), "grpc-proxy-fixtures-"));
+});
+
+afterEach(() => {
+  rmSync(dir, { recursive: true, force: true });
+});
+
+describe("record-replay: fixtureKey", () => {
+  test("ignores key order, query order, gateway and headers", () => {
+    const a = fixtureKey({
+      callPath,
+      baseUrl: "http://a",
+      config: {
+        method: "get",
+        url: "/v1/hello?b=2",
+        params: "a=1",
+        data: { x: 1, y: { b: 1, a: 2 } },
+        headers: { traceparent: "00-1" },
+      },
+    });
+    const b = fixtureKey({
+      callPath,
+      baseUrl: "http://b",
+      config: {
+        method: "GET",
+        url: "/v1/hello",
+        params: { b: "2", a: "1" },
+        data: '{"y":{"a":2,"b":1},"x":1}',
+      },
+    });
+    expect(a).toBe(b);
+    expect(a).toMatch(/^[0-9a-f]{16}$/);
+  });
+
+  test("different bodies", () => {
+    expect(fixtureKey(request({ name: "a" }))).not.toBe(
+      fixtureKey(request({ name: "b" }))
+    );
+  });
+
+  test("metadata headers take part in the key", () => {
+    const key = (headers: Record<string, string>, keyHeaders?: string[]) =>
+      fixtureKey(request({ name: "a" }, headers), keyHeaders);
+    expect(key({ "Grpc-Metadata-code": "1" })).not.toBe(
+      key({ "Grpc-Metadata-code": "2" })
+    );
+    expect(key({ "Grpc-Metadata-code": "1", "grpc-metadata-hello": "w" })).toBe(
+      key({ "grpc-metadata-hello": "w", "grpc-metadata-code": "1" })
+    );
+    // 凭证每次都可能不同，不参与匹配
+    expect(key({ "Grpc-Metadata-authorization": "Bearer t1" })).toBe(
+      fixtureKey(request({ name: "a" }))
+    );
+    expect(key({ "X-Md-code": "1" })).toBe(key({ "X-Md-code": "2" }));
+    expect(key({ "X-Md-code": "1" }, ["x-md-code"])).not.toBe(
+      key({ "X-Md-code": "2" }, ["x-md-code"])
+    );
+  });
+
+  test("fixturePath stays inside the directory", () => {
+    expect(fixturePath("/fixtures", callPath, "k")).toBe(
+      "/fixtures/example.greeter.v1.services.Greeter/SayHello/k.json"
+    );
+    expect(fixturePath("/fixtures", "/../a b/c", "k")).toBe(
+      "/fixtures/_/a_b/c/k.json"
+    );
+  });
+});
+
+describe("record-replay: recordClient", () => {
+  test("saves the exchange with redacted headers", async () => {
+    const send = jest.fn(async () => ({
+      status: 200,
+      headers: { "set-cookie": "sid=1", "grpc-metadata-x": "y" },
+      data: { message: "hello" },
+      rawTrailers: ["Grpc-Trailer-Code", "1"],
+    }));
+    const client = recordClient({ dir, client: { send } });
+    const req = request({ name: "huk" }, { Authorization: "Bearer x" });
+    const response = await client.send(req);
+    expect(response.data).toEqual({ message: "hello" });
+    const key = fixtureKey(req);
+    const fixture: Fixture = JSON.parse(
+      readFileSync(fixturePath(dir, callPath, key), "utf8")
+    );
+    expect(fixture).toEqual({
+      key,
+      callPath,
+      request: {
+        method: "POST",
+        url: "/v1/sayHello",
+        query: "",
+        headers: { Authorization: "[REDACTED]" },
+        body: { name: "huk" },
+      },
+      response: {
+        status: 200,
+        headers: { "set-cookie": "[REDACTED]", "grpc-metadata-x": "y" },
+        data: { message: "hello" },
+        rawTrailers: ["Grpc-Trailer-Code", "1"],
+      },
+    });
+  });
+
+  test("failed requests are not saved", async () => {
+    const client = recordClient({
+      dir,
+      client: {
+        send: async () => {
+          throw new Error("ECONNREFUSED");
+        },
+      },
+    });
+    await expect(client.send(request({}))).rejects.toThrow("ECONNREFUSED");
+    expect(readdirSync(dir)).toEqual([]);
+  });
+});
+
+describe("record-replay: replayClient", () => {
+  async function record(data: unknown, message: string) {
+    await recordClient({
+      dir,
+      client: {
+        send: async () => ({ status: 200, headers: {}, data: { message } }),
+      },
+    }).send(request(data));
+  }
+
+  test("strict", async () => {
+    await record({ name: "a" }, "hello a");
+    const client = replayClient({ dir });
+    const transport = new HttpTransport(client);
+    const hit = await transport.send(request({ name: "a" }));
+    expect(hit.response).toEqual({ message: "hello a" });
+    const miss = await transport.send(request({ name: "b" }));
+    expect(miss.status.code).toBe(status.UNAVAILABLE);
+    expect(miss.status.details).toContain(`no fixture matches ${callPath}`);
+    expect(client.report()).toEqual({
+      matched: 1,
+      unmatched: [
+        {
+          callPath,
+          key: fixtureKey(request({ name: "b" })),
+          method: "POST",
+          url: "/v1/sayHello",
+          served: false,
+        },
+      ],
+    });
+  });
+
+  test("lenient", async () => {
+    await record({ name: "a" }, "hello a");
+    const client = replayClient({ dir, match: "lenient" });
+    const response = await client.send(request({ name: "b" }));
+    expect(response.data).toEqual({ message: "hello a" });
+    expect(client.report().unmatched).toMatchObject([{ served: true }]);
+  });
+
+  test("responses are copies", async () => {
+    await record({ name: "a" }, "hello a");
+    const client = replayClient({ dir });
+    (await client.send(request({ name: "a" }))).data.message = "changed";
+    const response = await client.send(request({ name: "a" }));
+    expect(response.data).toEqual({ message: "hello a" });
+  });
+});
@@ -0,0 +1,178 @@
+import { createHmac } from "node:crypto";
+import { status } from "@grpc/grpc-js";
+import { ProxyRequest } from "../../src/http-transport";
+import {
+  canonicalQuery,
+  hmacSigner,
+  hmacStringToSign,
+  SignableRequest,
+  signingMiddleware,
+  sigV4Signer,
+} from "../../src/request-signer";
+
+function signable(
+  method: string,
+  url: string,
+  body = "",
+  headers: Record<string, string> = {}
+): SignableRequest {
+  return { callPath: "/a/b", method, url, body, headers };
+}
+
+describe("request-signer: sigV4Signer", () => {
+  // AWS Signature Version 4 test suite
+  const signer = sigV4Signer({
+    region: "us-east-1",
+    service: "service",
+    accessKeyId: "AKIDEXAMPLE",
+    secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+    now: () => Date.UTC(2015, 7, 30, 12, 36, 0),
+  });
+  const credential =
+    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request";
+
+  test("get-vanilla", () => {
+    expect(
+      signer(signable("GET", "https://example.amazonaws.com/"))
+    ).toEqual({
+      "X-Amz-Date": "20150830T123600Z",
+      Authorization:
+        `${credential}, SignedHeaders=host;x-amz-date, ` +
+        "Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+    });
+  });
+
+  test("post-vanilla", () => {
+    expect(
+      signer(signable("POST", "https://example.amazonaws.com/"))
+    ).toMatchObject({
+      Authorization: expect.stringContaining(
+        "Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"
+      ),
+    });
+  });
+
+  test("get-vanilla-query-order-key-case", () => {
+    expect(
+      signer(
+        signable(
+          "GET",
+          "https://example.amazonaws.com/?Param2=value2&Param1=value1"
+        )
+      )
+    ).toMatchObject({
+      Authorization: expect.stringContaining(
+        "Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"
+      ),
+    });
+  });
+
+  test("post-x-www-form-urlencoded", () => {
+    expect(
+      signer(
+        signable("POST", "https://example.amazonaws.com/", "Param1=value1", {
+          "Content-Type": "application/x-www-form-urlencoded",
+        })
+      )
+    ).toMatchObject({
+      Authorization:
+        `${credential}, SignedHeaders=content-type;host;x-amz-date, ` +
+        "Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+    });
+  });
+});
+
+describe("request-signer: hmacSigner", () => {
+  const signer = hmacSigner({
+    keyId: "key-1",
+    secret: "s3cret",
+    now: () => 1700000000000,
+  });
+
+  test("sign method, path, query, body and timestamp", () => {
+    const request = signable(
+      "POST",
+      "http://127.0.0.1:4501/v1/eqMetadata?b=2&a=1",
+      '{"name":"huk"}'
+    );
+    expect(hmacStringToSign(request, "1700000000")).toBe(
+      [
+        "POST",
+        "/v1/eqMetadata",
+        "a=1&b=2",
+        "2ca34efb38814a207a7341ab183332df8217fadf41e1180a8a17f6d1df6410a7",
+        "1700000000",
+      ].join("\n")
+    );
+    expect(signer(request)).toEqual({
+      "X-Timestamp": "1700000000",
+      "X-Content-Sha256":
+        "2ca34efb38814a207a7341ab183332df8217fadf41e1180a8a17f6d1df6410a7",
+      "X-Signature":
+        "HMAC-SHA256 KeyId=key-1, " +
+        "Signature=9e9b95e2d51f4235a3eedfbe48e9ba10d243b09325e9b1d64c47829882d23597",
+    });
+  });
+
+  test("signature can be verified with the string to sign", () => {
+    const request = signable("GET", "http://127.0.0.1:4501/v1/sayHello/huk");
+    const expected = createHmac("sha256", "s3cret")
+      .update(hmacStringToSign(request, "1700000000"))
+      .digest("hex");
+    expect(signer(request)).toMatchObject({
+      "X-Signature": `HMAC-SHA256 KeyId=key-1, Signature=${expected}`,
+    });
+  });
+
+  test("canonicalQuery", () => {
+    expect(canonicalQuery("?b=2&a=x+y&a=%21&c")).toBe("a=%21&a=x%20y&b=2&c=");
+  });
+});
+
+describe("request-signer: signingMiddleware", () => {
+  const request: ProxyRequest = {
+    callPath: "/a/b",
+    baseUrl: "http://127.0.0.1:4501",
+    config: {
+      method: "post",
+      url: "/v1/eqMetadata",
+      params: "b=2&a=1",
+      data: { name: "huk" },
+      headers: { TE: "trailers" },
+    },
+  };
+
+  test("send exactly what was signed", async () => {
+    const signer = jest.fn(() => ({ "X-Signature": "signed" }));
+    const next = jest.fn(async (req: ProxyRequest) => req as any);
+    const sent = (await signingMiddleware(signer)(request, next)) as any;
+    expect(signer).toHaveBeenCalledWith({
+      callPath: "/a/b",
+      method: "POST",
+      url: "http://127.0.0.1:4501/v1/eqMetadata?b=2&a=1",
+      body: '{"name":"huk"}',
+      headers: { TE: "trailers", "Content-Type": "application/json" },
+    });
+    expect(sent.config).toMatchObject({
+      url: "http://127.0.0.1:4501/v1/eqMetadata?b=2&a=1",
+      data: '{"name":"huk"}',
+      params: undefined,
+      headers: {
+        TE: "trailers",
+        "Content-Type": "application/json",
+        "X-Signature": "signed",
+      },
+    });
+  });
+
+  test("signer error returns INTERNAL", async () => {
+    const next = jest.fn();
+    const result = await signingMiddleware(() => {
+      throw new Error("no key");
+    })(request, next);
+    expect(next).not.toHaveBeenCalled();
+    expect(result.status.code).toBe(status.INTERNAL);
+    expect(result.status.details).toBe("failed to sign request: no key");
+    expect(result.error?.cause).toEqual(new Error("no key"));
+  });
+});
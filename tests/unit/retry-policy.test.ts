@@ -0,0 +1,121 @@
+import { Metadata, status } from "@grpc/grpc-js";
+import { CallResult } from "../../src/grpc-utils";
+import {
+  getPushback,
+  getRetryPolicy,
+  parseServiceConfig,
+  sleep,
+  withRetry,
+} from "../../src/retry-policy";
+
+const callPath = "/example.greeter.v1.services.Greeter/SayHello";
+
+const retryPolicy = {
+  maxAttempts: 3,
+  initialBackoff: "0.01s",
+  maxBackoff: "0.02s",
+  backoffMultiplier: 2,
+  retryableStatusCodes: ["UNAVAILABLE"],
+};
+
+function result(code: status, trailers: Record<string, string> = {}) {
+  const metadata = new Metadata();
+  for (const [key, value] of Object.entries(trailers)) metadata.set(key, value);
+  return {
+    response: null,
+    metadata: new Metadata(),
+    status: { code, details: "", metadata },
+  } as CallResult<any>;
+}
+
+describe("retry-policy: service config", () => {
+  test("most specific method config wins", () => {
+    const config = parseServiceConfig({
+      "grpc.service_config": JSON.stringify({
+        methodConfig: [
+          { name: [{}], retryPolicy: { ...retryPolicy, maxAttempts: 2 } },
+          {
+            name: [{ service: "example.greeter.v1.services.Greeter" }],
+            retryPolicy: { ...retryPolicy, maxAttempts: 4 },
+          },
+        ],
+      }),
+    });
+    expect(getRetryPolicy(config, callPath)?.maxAttempts).toBe(4);
+    expect(getRetryPolicy(config, "/other.Service/Call")?.maxAttempts).toBe(2);
+    expect(getRetryPolicy(parseServiceConfig({}), callPath)).toBeNull();
+  });
+
+  test("invalid service config", () => {
+    expect(() =>
+      parseServiceConfig({ "grpc.service_config": "{methodConfig: []}" })
+    ).toThrow(/^Invalid grpc\.service_config: /);
+  });
+
+  test("pushback from trailers and Retry-After", () => {
+    expect(getPushback(result(status.UNAVAILABLE))).toBeUndefined();
+    expect(
+      getPushback(result(status.UNAVAILABLE, { "grpc-retry-pushback-ms": "5" }))
+    ).toBe(5);
+    expect(
+      getPushback(result(status.UNAVAILABLE, { "grpc-retry-pushback-ms": "-1" }))
+    ).toBeNull();
+    const withHeader = result(status.RESOURCE_EXHAUSTED);
+    withHeader.http = {
+      status: 429,
+      headers: { "retry-after": "2" },
+      gateway: "",
+    };
+    expect(getPushback(withHeader)).toBe(2000);
+  });
+});
+
+describe("retry-policy: withRetry", () => {
+  test("retry retryable codes up to maxAttempts", async () => {
+    const handler = jest.fn(async () => result(status.UNAVAILABLE));
+    const retry = withRetry(handler, retryPolicy, true);
+    const last = await retry({}, new Metadata());
+    expect(last.status.code).toBe(status.UNAVAILABLE);
+    expect(handler).toHaveBeenCalledTimes(3);
+  });
+
+  test("do not retry non idempotent methods or other codes", async () => {
+    const handler = jest.fn(async () => result(status.UNAVAILABLE));
+    await withRetry(handler, retryPolicy, false)({}, new Metadata());
+    expect(handler).toHaveBeenCalledTimes(1);
+    const internal = jest.fn(async () => result(status.INTERNAL));
+    await withRetry(internal, retryPolicy, true)({}, new Metadata());
+    expect(internal).toHaveBeenCalledTimes(1);
+  });
+
+  test("stop when the server pushes back with a negative value", async () => {
+    const handler = jest.fn(async () =>
+      result(status.UNAVAILABLE, { "grpc-retry-pushback-ms": "-1" })
+    );
+    await withRetry(handler, retryPolicy, true)({}, new Metadata());
+    expect(handler).toHaveBeenCalledTimes(1);
+  });
+
+  test("stop waiting when the call is cancelled", async () => {
+    const controller = new AbortController();
+    const handler = jest.fn(async () =>
+      result(status.UNAVAILABLE, { "grpc-retry-pushback-ms": "60000" })
+    );
+    const retry = withRetry(handler, retryPolicy, true);
+    const pending = retry({}, new Metadata(), controller.signal);
+    setTimeout(() => controller.abort(), 10);
+    const last = await pending;
+    expect(last.status.code).toBe(status.UNAVAILABLE);
+    expect(handler).toHaveBeenCalledTimes(1);
+  });
+});
+
+describe("retry-policy: sleep", () => {
+  test("reject when the signal is aborted", async () => {
+    const controller = new AbortController();
+    const waiting = sleep(60000, controller.signal);
+    controller.abort();
+    await expect(waiting).rejects.toThrow("sleep aborted");
+    await expect(sleep(0, controller.signal)).rejects.toThrow("sleep aborted");
+  });
+});
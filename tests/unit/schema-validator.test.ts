@@ -0,0 +1,160 @@
+import { OpenAPIV2 } from "openapi-types";
+import { Metadata, status } from "@grpc/grpc-js";
+import { ValidationError } from "../../src/proxy-error";
+import { Operation } from "../../src/openapi-v2-parser";
+import {
+  createValidator,
+  SchemaValidator,
+  validateRequest,
+  validateResponse,
+} from "../../src/schema-validator";
+
+const document = {
+  swagger: "2.0",
+  info: { title: "test", version: "1" },
+  paths: {},
+  definitions: {
+    "example.User": {
+      type: "object",
+      properties: {
+        id: { type: "string", format: "int64" },
+        name: { type: "string" },
+      },
+    },
+  },
+} as unknown as OpenAPIV2.Document;
+
+const operation: Operation = {
+  path: "/v1/users/{id}",
+  filePath: "user.swagger.json",
+  method: "patch",
+  operationId: "Users_UpdateUser",
+  parameters: [
+    { name: "id", in: "path", required: true, type: "string", format: "int64" },
+    {
+      name: "user",
+      in: "body",
+      required: true,
+      schema: { $ref: "#/definitions/example.User" },
+    },
+    {
+      name: "mask.paths",
+      in: "query",
+      type: "array",
+      items: { type: "string" },
+    },
+  ],
+  responses: {
+    "200": {
+      description: "A successful response.",
+      schema: { $ref: "#/definitions/example.User" },
+    },
+  },
+};
+
+const callPath = "/example.Users/UpdateUser";
+
+function okResult(response: unknown) {
+  return {
+    response,
+    metadata: new Metadata(),
+    status: { code: status.OK, details: "", metadata: new Metadata() },
+  };
+}
+
+describe("schema-validator: validateRequest", () => {
+  test("path, body and query params", () => {
+    expect(
+      validateRequest(
+        operation,
+        { id: "1", user: { name: "huk" }, mask: { paths: ["name"] } },
+        document
+      )
+    ).toEqual([]);
+    expect(
+      validateRequest(
+        operation,
+        { user: { name: 1 }, mask: { paths: "name" } },
+        document
+      )
+    ).toEqual([
+      { path: "id", message: "is required" },
+      { path: "user.name", message: "must be a string" },
+      { path: "mask.paths", message: "must be an array" },
+    ]);
+  });
+
+  test("unknown fields are allowed", () => {
+    expect(
+      validateRequest(operation, { id: 1, user: { extra: 1 } }, document)
+    ).toEqual([]);
+  });
+});
+
+describe("schema-validator: validateResponse", () => {
+  test("unknown fields are reported", () => {
+    expect(validateResponse(operation, { id: "1", age: 1 }, document)).toEqual(
+      [{ path: "age", message: "is not defined" }]
+    );
+    const empty = { ...operation, responses: {} };
+    expect(validateResponse(empty, 1, document)).toEqual([]);
+  });
+});
+
+describe("schema-validator: SchemaValidator", () => {
+  test("invalid requests throw INVALID_ARGUMENT", () => {
+    const validator = new SchemaValidator({});
+    let error: unknown = null;
+    try {
+      validator.checkRequest(callPath, operation, { id: "x" }, document);
+    } catch (err) {
+      error = err;
+    }
+    expect(error).toBeInstanceOf(ValidationError);
+    expect(error).toMatchObject({
+      code: status.INVALID_ARGUMENT,
+      message:
+        `request message of ${callPath} does not match the openapi schema: ` +
+        "id must be an int64 string",
+      violations: [{ path: "id", message: "must be an int64 string" }],
+    });
+    expect(() =>
+      new SchemaValidator({ request: false }).checkRequest(
+        callPath,
+        operation,
+        { id: "x" },
+        document
+      )
+    ).not.toThrow();
+  });
+
+  test("response modes", () => {
+    const drift = okResult({ id: "1", age: 1 });
+    const valid = okResult({ id: "1" });
+    const disabled = new SchemaValidator({});
+    expect(disabled.checkResponse(callPath, operation, drift, document)).toBe(
+      drift
+    );
+    expect(
+      new SchemaValidator({ response: "warn" }).checkResponse(
+        callPath,
+        operation,
+        drift,
+        document
+      )
+    ).toBe(drift);
+    const error = new SchemaValidator({ response: "error" });
+    expect(error.checkResponse(callPath, operation, valid, document)).toBe(
+      valid
+    );
+    const result = error.checkResponse(callPath, operation, drift, document);
+    expect(result.response).toBeNull();
+    expect(result.status.code).toBe(status.INTERNAL);
+    expect(result.error).toBeInstanceOf(ValidationError);
+  });
+
+  test("createValidator", () => {
+    expect(createValidator()).toBeNull();
+    expect(createValidator({})).toBeInstanceOf(SchemaValidator);
+  });
+});
@@ -0,0 +1,30 @@
+import { credentials } from "@grpc/grpc-js";
+import { toConnectionOptions } from "../../src/tls-options";
+
+describe("tls-options: toConnectionOptions", () => {
+  test("explicit options", () => {
+    expect(
+      toConnectionOptions({ ca: "ca", cert: "cert", key: "key" })
+    ).toEqual({ ca: "ca", cert: "cert", key: "key" });
+  });
+
+  test("servername from grpc.ssl_target_name_override", () => {
+    const channelOptions = { "grpc.ssl_target_name_override": "gateway.test" };
+    expect(toConnectionOptions({}, channelOptions).servername).toBe(
+      "gateway.test"
+    );
+    expect(
+      toConnectionOptions({ servername: "a.test" }, channelOptions).servername
+    ).toBe("a.test");
+  });
+
+  test("derive from ChannelCredentials", () => {
+    const options = toConnectionOptions({
+      credentials: credentials.createSsl(),
+    });
+    expect(options.secureContext).toBeDefined();
+    expect(
+      toConnectionOptions({ credentials: credentials.createInsecure() })
+    ).toEqual({});
+  });
+});
@@ -0,0 +1,99 @@
+import { Metadata, status } from "@grpc/grpc-js";
+import { HttpTransport } from "../../src/http-transport";
+import { TransportError } from "../../src/proxy-error";
+import { createFakeTracer } from "../resources/tracing/fake-tracer";
+import {
+  encodeGrpcTraceBin,
+  formatTraceparent,
+  traceHeaders,
+  Tracing,
+} from "../../src/tracing";
+
+const ctx = {
+  traceId: "4bf92f3577b34da6a3ce929d0e0e4736",
+  spanId: "00f067aa0ba902b7",
+  traceFlags: 1,
+};
+const callPath = "/example.greeter.v1.services.Greeter/SayHello";
+
+describe("tracing: propagation format", () => {
+  test("traceparent", () => {
+    expect(formatTraceparent(ctx)).toBe(
+      "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+    );
+  });
+
+  test("grpc-trace-bin", () => {
+    const bin = encodeGrpcTraceBin(ctx);
+    expect(bin).toHaveLength(29);
+    expect(bin.toString("hex")).toBe(
+      "0000" + ctx.traceId + "01" + ctx.spanId + "0201"
+    );
+  });
+
+  test("invalid span context is not propagated", () => {
+    expect(
+      traceHeaders({ ...ctx, traceId: "0".repeat(32) }, "grpc-web")
+    ).toEqual({});
+    expect(Object.keys(traceHeaders(ctx, "grpc-web"))).toEqual([
+      "traceparent",
+      "grpc-trace-bin",
+    ]);
+  });
+});
+
+describe("tracing: Tracing", () => {
+  test("one span for all attempts of a call", async () => {
+    const { tracer, spans } = createFakeTracer();
+    const tracing = new Tracing(tracer, "grpc-gateway");
+    const sent: Record<string, string>[] = [];
+    const transport = new HttpTransport({
+      send: async (request) => {
+        sent.push(request.config.headers as Record<string, string>);
+        return { status: 503, headers: {}, data: { message: "down" } };
+      },
+    }).use(tracing.middleware());
+    const handler = tracing.wrap(async (message, metadata) => {
+      const request = { callPath, baseUrl: "http://gw", metadata, config: {} };
+      await transport.send(request);
+      return transport.send(request);
+    }, callPath);
+    const result = await handler({}, new Metadata());
+    expect(result.status.code).toBe(status.UNAVAILABLE);
+    expect(spans).toHaveLength(1);
+    expect(sent.map((h) => h.traceparent)).toEqual([
+      formatTraceparent(spans[0].context),
+      formatTraceparent(spans[0].context),
+    ]);
+    expect(spans[0]).toMatchObject({
+      ended: true,
+      status: { code: 2, message: "down" },
+      attributes: {
+        "rpc.grpc.status_code": status.UNAVAILABLE,
+        "grpc_proxy.gateway.url": "http://gw",
+        "http.response.status_code": 503,
+      },
+    });
+  });
+
+  test("proxy errors are recorded as exceptions", async () => {
+    const { tracer, spans } = createFakeTracer();
+    const error = new TransportError("connect ECONNREFUSED");
+    const handler = new Tracing(tracer, "grpc-web").wrap(async () => {
+      throw error;
+    }, callPath);
+    await handler({}, new Metadata());
+    expect(spans[0].exceptions).toEqual([error]);
+  });
+
+  test("requests of other calls are not traced", async () => {
+    const { tracer } = createFakeTracer();
+    const next = jest.fn(async (request: any) => request);
+    const request = { callPath, baseUrl: "", metadata: new Metadata() };
+    await new Tracing(tracer, "grpc-web").middleware()(
+      { ...request, config: {} },
+      next
+    );
+    expect(next.mock.calls[0][0].config.headers).toBeUndefined();
+  });
+});
@@ -0,0 +1,76 @@
+import { TransportHealth } from "../../src/transport-health";
+import { idempotentMethodsFromDefinition } from "../../src/idempotency";
+
+const target = "/example.greeter.v1.services.Greeter";
+
+describe("transport-health: TransportHealth", () => {
+  afterEach(() => {
+    jest.useRealTimers();
+  });
+
+  test("switch to fallback and back after backoff", () => {
+    jest.useFakeTimers({ now: 0 });
+    const health = new TransportHealth("direct", { initial: 100, max: 400 });
+    const events: unknown[] = [];
+    health.on("switch", (event) => events.push(event));
+
+    expect(health.current(target)).toBe("direct");
+    health.reportFailure(target, "direct");
+    expect(health.current(target)).toBe("proxy");
+    jest.setSystemTime(100);
+    expect(health.current(target)).toBe("direct");
+    expect(events).toEqual([
+      { target, from: "direct", to: "proxy" },
+      { target, from: "proxy", to: "direct" },
+    ]);
+  });
+
+  test("backoff grows with consecutive failures", () => {
+    jest.useFakeTimers({ now: 0 });
+    const health = new TransportHealth("proxy", { initial: 100, max: 300 });
+    health.reportFailure(target, "proxy");
+    health.reportFailure(target, "proxy");
+    jest.setSystemTime(199);
+    expect(health.current(target)).toBe("direct");
+    jest.setSystemTime(200);
+    expect(health.current(target)).toBe("proxy");
+    health.reportSuccess(target, "proxy");
+    health.reportFailure(target, "proxy");
+    jest.setSystemTime(300);
+    expect(health.current(target)).toBe("proxy");
+  });
+
+  test("switch events are emitted when failures and successes are reported", () => {
+    jest.useFakeTimers({ now: 0 });
+    const health = new TransportHealth("direct", { initial: 100 });
+    const events: unknown[] = [];
+    health.on("switch", (event) => events.push(event));
+    health.reportFailure(target, "direct");
+    expect(events).toEqual([{ target, from: "direct", to: "proxy" }]);
+    health.reportSuccess(target, "direct");
+    expect(events).toEqual([
+      { target, from: "direct", to: "proxy" },
+      { target, from: "proxy", to: "direct" },
+    ]);
+    health.reportSuccess(target, "direct");
+    expect(events).toHaveLength(2);
+  });
+});
+
+describe("idempotency: idempotentMethodsFromDefinition", () => {
+  test("read idempotency_level from method options", () => {
+    const definition = {
+      "example.Greeter": {
+        SayHello: {
+          path: "/example.Greeter/SayHello",
+          options: { idempotency_level: "NO_SIDE_EFFECTS" },
+        },
+        Update: { path: "/example.Greeter/Update", options: {} },
+      },
+      "example.HelloRequest": { format: "Protocol Buffer 3 DescriptorProto" },
+    };
+    expect(idempotentMethodsFromDefinition(definition)).toEqual([
+      "/example.Greeter/SayHello",
+    ]);
+  });
+});